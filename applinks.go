@@ -0,0 +1,80 @@
+package main
+
+import "strings"
+
+// AppLinks are the deep links into native apps a page declares, from
+// Twitter app cards and Facebook App Links, keyed by platform as the tags
+// name it: iphone, ipad and googleplay for Twitter; ios, iphone, ipad,
+// android, windows_phone, windows, windows_universal and web for App Links.
+type AppLinks struct {
+	Twitter map[string]*AppLink `json:"twitter,omitempty"`
+	AL      map[string]*AppLink `json:"al,omitempty"`
+}
+
+// AppLink is one app's deep link. ID is its App Store ID, Android package or
+// Windows app ID; Class is the Android activity to open.
+type AppLink struct {
+	URL   string `json:"url,omitempty"`
+	Name  string `json:"name,omitempty"`
+	ID    string `json:"id,omitempty"`
+	Class string `json:"class,omitempty"`
+}
+
+// addAppLink records a twitter:app:<field>:<platform> or
+// al:<platform>:<field> meta tag. The first value of each field wins.
+func addAppLink(metadata *MetadataResponse, key, content string) {
+	var source, platform, field string
+	if rest, ok := strings.CutPrefix(key, "twitter:app:"); ok {
+		source = "twitter"
+		field, platform, _ = strings.Cut(rest, ":")
+	} else if rest, ok := strings.CutPrefix(key, "al:"); ok {
+		source = "al"
+		platform, field, _ = strings.Cut(rest, ":")
+	}
+	if platform == "" || content == "" || !appLinkFields[field] {
+		return
+	}
+
+	if metadata.AppLinks == nil {
+		metadata.AppLinks = &AppLinks{}
+	}
+	platforms := &metadata.AppLinks.Twitter
+	if source == "al" {
+		platforms = &metadata.AppLinks.AL
+	}
+	if *platforms == nil {
+		*platforms = make(map[string]*AppLink)
+	}
+	link := (*platforms)[platform]
+	if link == nil {
+		link = &AppLink{}
+		(*platforms)[platform] = link
+	}
+
+	var target *string
+	switch field {
+	case "url":
+		target = &link.URL
+	case "name", "app_name":
+		target = &link.Name
+	case "class":
+		target = &link.Class
+	default:
+		target = &link.ID
+	}
+	if *target == "" {
+		*target = content
+	}
+}
+
+// appLinkFields are the fields of twitter:app and al tags that are kept
+var appLinkFields = map[string]bool{
+	"url":          true,
+	"name":         true,
+	"app_name":     true,
+	"id":           true,
+	"app_store_id": true,
+	"package":      true,
+	"app_id":       true,
+	"class":        true,
+}
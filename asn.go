@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// asnDB maps addresses to the networks announcing them; main loads it from
+// ASN_DB. It is nil when no database is configured.
+var asnDB *asnDatabase
+
+// ASNInfo describes the network an address belongs to
+type ASNInfo struct {
+	IP      string `json:"ip"`
+	ASN     int    `json:"asn"`
+	Org     string `json:"org"`
+	Country string `json:"country,omitempty"`
+}
+
+// asnRange is one routed range of the database. Addresses are kept in their
+// 16-byte form so IPv4 and IPv6 ranges sort together.
+type asnRange struct {
+	start, end net.IP
+	asn        int
+	org        string
+	country    string
+}
+
+// asnDatabase is a sorted list of non-overlapping address ranges
+type asnDatabase struct {
+	ranges []asnRange
+}
+
+// loadASNDatabase reads an IP-to-ASN table in the tab-separated format
+// published by iptoasn.com: range start, range end, AS number, country code
+// and AS description. Ranges with AS number 0 are not routed and are skipped.
+func loadASNDatabase(path string) (*asnDatabase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	db := &asnDatabase{}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, "\t", 5)
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("%s:%d: expected 5 tab-separated fields", path, line)
+		}
+		start, end := net.ParseIP(fields[0]), net.ParseIP(fields[1])
+		asn, err := strconv.Atoi(fields[2])
+		if start == nil || end == nil || err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid range or AS number", path, line)
+		}
+		if asn == 0 {
+			continue
+		}
+		db.ranges = append(db.ranges, asnRange{
+			start:   start.To16(),
+			end:     end.To16(),
+			asn:     asn,
+			country: fields[3],
+			org:     fields[4],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].start, db.ranges[j].start) < 0
+	})
+	return db, nil
+}
+
+// lookup returns the network announcing ip, if the database has it
+func (db *asnDatabase) lookup(ip net.IP) (ASNInfo, bool) {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return ASNInfo{}, false
+	}
+	// The candidate is the last range starting at or before ip
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start, ip16) > 0
+	}) - 1
+	if i < 0 || bytes.Compare(ip16, db.ranges[i].end) > 0 {
+		return ASNInfo{}, false
+	}
+	r := db.ranges[i]
+	return ASNInfo{IP: ip.String(), ASN: r.asn, Org: r.org, Country: r.country}, true
+}
+
+// addNetworkInfo sets the addresses host resolves to, taken from the DNS
+// cache the fetch connected through, and their networks when includeASN is
+// set
+func addNetworkInfo(ctx context.Context, cfg *Config, metadata *MetadataResponse, host string, includeASN bool) {
+	ips, err := resolveHost(ctx, cfg, host)
+	if err != nil {
+		return
+	}
+	for _, ip := range ips {
+		metadata.ResolvedIPs = append(metadata.ResolvedIPs, ip.String())
+	}
+
+	if !includeASN {
+		return
+	}
+	if asnDB == nil {
+		addWarning(metadata, warnASNUnavailable, "include_asn needs an ASN database; set ASN_DB")
+		return
+	}
+	for _, ip := range ips {
+		if info, ok := asnDB.lookup(ip); ok {
+			metadata.ASN = append(metadata.ASN, info)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// defaultAssetURLSchemes are the schemes image and favicon URLs may have
+// unless ASSET_URL_SCHEMES says otherwise
+var defaultAssetURLSchemes = []string{"http", "https"}
+
+// parseSchemes parses a comma-separated list of URL schemes, lowercased
+func parseSchemes(v string) ([]string, error) {
+	var schemes []string
+	for _, item := range strings.Split(v, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		if u, err := url.Parse(item + ":x"); err != nil || u.Scheme != item {
+			return nil, fmt.Errorf("invalid URL scheme %q", item)
+		}
+		schemes = append(schemes, item)
+	}
+	return schemes, nil
+}
+
+// validAssetURL reports whether a resolved image or favicon URL has one of
+// schemes, and a host if it is http(s). Hrefs like javascript:void(0) or
+// garbage that doesn't parse survive resolveURL as they are.
+func validAssetURL(rawURL string, schemes []string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || !contains(schemes, strings.ToLower(u.Scheme)) {
+		return false
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		return u.Host != ""
+	}
+	return true
+}
+
+// dropInvalidAssetURLs removes image candidates, icons and the favicon
+// whose URLs don't pass validAssetURL, with a warning for each. A dropped
+// favicon is replaced with the next icon that isn't for dark themes.
+func dropInvalidAssetURLs(metadata *MetadataResponse, schemes []string) {
+	drop := func(kind, rawURL string) {
+		addWarning(metadata, warnInvalidAssetURL, fmt.Sprintf("dropped %s URL without an allowed scheme: %.100q", kind, rawURL))
+	}
+
+	images := metadata.Images[:0]
+	details := metadata.ImageDetails[:0]
+	for _, img := range metadata.ImageDetails {
+		if !validAssetURL(img.URL, schemes) {
+			drop("image", img.URL)
+			continue
+		}
+		images = append(images, img.URL)
+		details = append(details, img)
+	}
+	metadata.Images, metadata.ImageDetails = images, details
+
+	icons := metadata.Icons[:0]
+	for _, icon := range metadata.Icons {
+		if !validAssetURL(icon.URL, schemes) {
+			if icon.URL != metadata.Favicon {
+				drop("icon", icon.URL)
+			}
+			continue
+		}
+		icons = append(icons, icon)
+	}
+	metadata.Icons = icons
+
+	if metadata.Favicon == "" || validAssetURL(metadata.Favicon, schemes) {
+		return
+	}
+	drop("favicon", metadata.Favicon)
+	metadata.Favicon = ""
+	delete(metadata.Sources, "favicon")
+	for _, icon := range metadata.Icons {
+		if !isDarkMedia(icon.Media) {
+			metadata.Favicon = icon.URL
+			noteSource(metadata, "favicon", "link[rel="+icon.Rel+"]")
+			break
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// maxRequestHeaders caps the headers a request may add to its page fetches
+const maxRequestHeaders = 20
+
+// reservedRequestHeaders are set by the fetch itself, or would change what
+// the response means to it, so requests can't send their own
+var reservedRequestHeaders = []string{
+	"Host", "Content-Length", "Transfer-Encoding", "Connection", "Keep-Alive", "Upgrade",
+	"Te", "Trailer", "Proxy-Authorization", "Proxy-Connection", "Accept-Encoding", "User-Agent",
+}
+
+// BatchURL is an entry of a request's urls: either a plain URL string or
+// an object with the URL and options for it alone, which replace the
+// request's own
+type BatchURL struct {
+	URL       string            `json:"url"`
+	TimeoutMs *int64            `json:"timeout_ms,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+// UnmarshalJSON accepts both forms of an entry
+func (b *BatchURL) UnmarshalJSON(data []byte) error {
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '"' {
+		*b = BatchURL{}
+		return json.Unmarshal(data, &b.URL)
+	}
+	type entry BatchURL
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return errors.New("each of 'urls' must be a URL or an object with 'url'")
+	}
+	*b = BatchURL(e)
+	return nil
+}
+
+// options are the extraction options of the entry, starting from the
+// request's
+func (b BatchURL) options(opts ExtractOptions) ExtractOptions {
+	if b.TimeoutMs != nil {
+		opts.TimeoutMs = b.TimeoutMs
+	}
+	if b.UserAgent != "" {
+		opts.UserAgent = b.UserAgent
+	}
+	if b.Headers != nil {
+		opts.Headers = b.Headers
+	}
+	return opts
+}
+
+// checkExtractOptions validates the options that can differ per URL
+func checkExtractOptions(opts ExtractOptions) error {
+	if opts.TimeoutMs != nil && *opts.TimeoutMs <= 0 {
+		return errors.New("'timeout_ms' must be positive")
+	}
+	if len(opts.Headers) > maxRequestHeaders {
+		return fmt.Errorf("at most %d 'headers' may be sent", maxRequestHeaders)
+	}
+	for name, value := range opts.Headers {
+		if !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+			return fmt.Errorf("invalid header %q", name)
+		}
+		for _, reserved := range reservedRequestHeaders {
+			if http.CanonicalHeaderKey(name) == reserved {
+				return fmt.Errorf("header %q can't be set", name)
+			}
+		}
+	}
+	return nil
+}
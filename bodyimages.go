@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxBodyImages caps the number of <img> candidates taken from the page body
+const maxBodyImages = 20
+
+// extractBodyImages adds the page's <img> elements as image candidates after
+// the declared og/twitter images. Lazy-loading pages often keep the real
+// image in a <noscript> fallback, which html.Parse leaves as raw text, so
+// that text is parsed again and searched too.
+func extractBodyImages(doc *html.Node, metadata *MetadataResponse, baseURL *url.URL) {
+	seen := make(map[string]bool, len(metadata.Images))
+	for _, img := range metadata.Images {
+		seen[img] = true
+	}
+	added := 0
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if added >= maxBodyImages {
+			return
+		}
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Img:
+				if src := imageSource(n); src != "" {
+					imageURL := resolveURL(src, baseURL)
+					if !seen[imageURL] {
+						seen[imageURL] = true
+						addImage(metadata, imageURL, "img")
+						added++
+					}
+				}
+			case atom.Noscript:
+				for _, child := range parseNoscript(n) {
+					walk(child)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+}
+
+// imageSourceAttrs are checked in order for an <img>'s URL. Lazy-loading
+// pages keep the real URL in a data attribute and a placeholder in src until
+// script swaps them.
+var imageSourceAttrs = []string{"data-src", "data-original", "data-lazy-src", "src"}
+
+// imageSource returns the URL an <img> displays, preferring lazy-load
+// attributes over src and skipping inline data URIs
+func imageSource(n *html.Node) string {
+	attrs := make(map[string]string, len(n.Attr))
+	for _, attr := range n.Attr {
+		attrs[attr.Key] = strings.TrimSpace(attr.Val)
+	}
+	for _, key := range imageSourceAttrs {
+		if v := attrs[key]; v != "" && !strings.HasPrefix(v, "data:") {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseNoscript parses the raw text content of a <noscript> element
+func parseNoscript(n *html.Node) []*html.Node {
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			text.WriteString(c.Data)
+		}
+	}
+	if !strings.Contains(text.String(), "<") {
+		return nil
+	}
+
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(text.String()), context)
+	if err != nil {
+		return nil
+	}
+	return nodes
+}
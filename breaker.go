@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultBreakerFailures is used when BREAKER_FAILURES is not set
+	defaultBreakerFailures = 5
+
+	// defaultBreakerWindow is used when BREAKER_WINDOW is not set
+	defaultBreakerWindow = time.Minute
+
+	// defaultBreakerCooldown is used when BREAKER_COOLDOWN is not set
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// breakerOutcome is how an upstream request went, as far as the breaker cares
+type breakerOutcome int
+
+const (
+	outcomeSuccess breakerOutcome = iota
+	outcomeFailure
+	// outcomeIgnored is for requests that ended for reasons that say nothing
+	// about the upstream, such as the client going away
+	outcomeIgnored
+)
+
+// circuitOpenError is returned for requests to a domain whose circuit is open
+type circuitOpenError struct {
+	domain     string
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for %s after repeated failures; retry in %s", e.domain, e.retryAfter.Round(time.Second))
+}
+
+// breakers tracks upstream failures per registrable domain
+var breakers = newBreakerSet()
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half_open"
+)
+
+// breaker is the state of one domain. Once open, it fails requests until the
+// cooldown has passed, then lets a single probe through: success closes it,
+// failure opens it again.
+type breaker struct {
+	state        breakerState
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	probing      bool
+}
+
+type breakerSet struct {
+	mu      sync.Mutex
+	domains map[string]*breaker
+}
+
+func newBreakerSet() *breakerSet {
+	return &breakerSet{domains: make(map[string]*breaker)}
+}
+
+// allow reports whether a request to host may go ahead. When it may, done
+// must be called with the request's outcome. A BREAKER_FAILURES of 0
+// disables the breaker.
+func (s *breakerSet) allow(cfg *Config, host string) (func(breakerOutcome), error) {
+	if cfg.BreakerFailures <= 0 {
+		return func(breakerOutcome) {}, nil
+	}
+	// All of a site's subdomains share a breaker
+	domain := registrableDomain(host)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.domains[domain]
+	probe := false
+	if b != nil && b.state != breakerClosed {
+		if wait := cfg.BreakerCooldown - time.Since(b.openedAt); wait > 0 || b.probing {
+			if wait < 0 {
+				wait = 0
+			}
+			return nil, &circuitOpenError{domain: domain, retryAfter: wait}
+		}
+		b.state, b.probing, probe = breakerHalfOpen, true, true
+	}
+
+	var once sync.Once
+	return func(outcome breakerOutcome) {
+		once.Do(func() { s.record(cfg, domain, probe, outcome) })
+	}, nil
+}
+
+func (s *breakerSet) record(cfg *Config, domain string, probe bool, outcome breakerOutcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.domains[domain]
+	if probe && b != nil {
+		b.probing = false
+	}
+
+	switch outcome {
+	case outcomeSuccess:
+		// Closed domains without failures aren't worth remembering
+		delete(s.domains, domain)
+	case outcomeFailure:
+		now := time.Now()
+		if b == nil {
+			b = &breaker{state: breakerClosed}
+			s.domains[domain] = b
+		}
+		if probe {
+			b.state, b.openedAt = breakerOpen, now
+			return
+		}
+		if b.state != breakerClosed {
+			return
+		}
+		if b.failures == 0 || now.Sub(b.firstFailure) > cfg.BreakerWindow {
+			b.failures, b.firstFailure = 0, now
+		}
+		b.failures++
+		if b.failures >= cfg.BreakerFailures {
+			b.state, b.openedAt = breakerOpen, now
+		}
+	case outcomeIgnored:
+		if probe && b != nil {
+			// Let the next request probe instead
+			b.state = breakerOpen
+		}
+	}
+}
+
+// reset closes the breaker for domain, or every breaker when domain is empty.
+// It returns how many were reset.
+func (s *breakerSet) reset(domain string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if domain == "" {
+		n := len(s.domains)
+		s.domains = make(map[string]*breaker)
+		return n
+	}
+	domain = registrableDomain(domain)
+	if _, ok := s.domains[domain]; !ok {
+		return 0
+	}
+	delete(s.domains, domain)
+	return 1
+}
+
+// BreakerStatus describes a domain that has recently failed
+type BreakerStatus struct {
+	Domain   string       `json:"domain"`
+	State    breakerState `json:"state"`
+	Failures int          `json:"consecutive_failures"`
+	OpenedAt *time.Time   `json:"opened_at,omitempty"`
+}
+
+func (s *breakerSet) stats() []BreakerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]BreakerStatus, 0, len(s.domains))
+	for domain, b := range s.domains {
+		status := BreakerStatus{Domain: domain, State: b.state, Failures: b.failures}
+		if b.state != breakerClosed {
+			openedAt := b.openedAt
+			status.OpenedAt = &openedAt
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Domain < statuses[j].Domain })
+	return statuses
+}
+
+// classifyOutcome decides whether an upstream request counts against its
+// domain: timeouts, refused connections and 5xx responses do
+func classifyOutcome(ctx context.Context, statusCode int, err error) breakerOutcome {
+	if err == nil {
+		if statusCode >= 500 {
+			return outcomeFailure
+		}
+		return outcomeSuccess
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return outcomeIgnored
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.ECONNREFUSED) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		return outcomeFailure
+	}
+	return outcomeIgnored
+}
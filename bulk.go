@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxBulkUploadBytes caps the uploaded URL list
+	maxBulkUploadBytes = 1024 * 1024
+
+	// bulkWorkers is the number of URLs extracted concurrently for one upload
+	bulkWorkers = 5
+
+	// defaultBulkMaxURLs is used when BULK_MAX_URLS is not set
+	defaultBulkMaxURLs = 100
+)
+
+// bulkResult is one NDJSON line of a bulk response. Lines are written as
+// extractions finish, so index gives the URL's position in the upload.
+type bulkResult struct {
+	Index int `json:"index"`
+	MetadataResult
+}
+
+// bulkExtractHandler accepts a multipart upload of URLs in a "file" field and
+// streams one NDJSON result per URL
+func bulkExtractHandler(store *configStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveBulkExtract(store.Load(), w, r)
+	}
+}
+
+func serveBulkExtract(cfg *Config, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed. Use POST."})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBulkUploadBytes+64*1024)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "A multipart 'file' field with the URL list is required"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxBulkUploadBytes+1))
+	if err != nil || len(data) > maxBulkUploadBytes {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("Uploaded file must be at most %d bytes", maxBulkUploadBytes)})
+		return
+	}
+
+	urls := parseURLList(string(data))
+	if len(urls) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "The uploaded file contains no URLs"})
+		return
+	}
+	if len(urls) > cfg.BulkMaxURLs {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Maximum %d URLs allowed per upload", cfg.BulkMaxURLs)})
+		return
+	}
+
+	// Uploads are backfills, which shouldn't hold up requests a client is
+	// waiting on; only admins can put theirs on a par with those
+	prio := priorityLow
+	if name := r.URL.Query().Get("priority"); name != "" {
+		if prio, err = parsePriority(name); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if prio == priorityHigh && !hasAdminToken(cfg, r) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "priority=high requires the admin token"})
+			return
+		}
+	}
+	ctx := withPriority(r.Context(), prio)
+
+	opts := ExtractOptions{Debug: r.URL.Query().Get("debug") == "1"}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	rc := http.NewResponseController(w)
+	// The server's WriteTimeout is shorter than an upload takes, so keep
+	// pushing the deadline out as results arrive
+	rc.SetWriteDeadline(time.Now().Add(fetchTimeout + responseWriteMargin))
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	jobs := make(chan int)
+	results := make(chan bulkResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < bulkWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				metadata, err := extractMetadata(ctx, cfg, urls[idx], opts)
+				results <- bulkResult{Index: idx, MetadataResult: newMetadataResult(r, urls[idx], metadata, err)}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := range urls {
+			select {
+			case jobs <- i:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for res := range results {
+		rc.SetWriteDeadline(time.Now().Add(fetchTimeout + responseWriteMargin))
+		// Each line is encoded in full first so that a failure can't leave
+		// half a line in the stream
+		line, err := json.Marshal(res)
+		if err != nil {
+			encodeFailures.Add(1)
+			log.Printf("❌ Failed to encode bulk result %d: %v\n", res.Index, err)
+			line, _ = json.Marshal(bulkResult{Index: res.Index, MetadataResult: MetadataResult{
+				MetadataResponse: &MetadataResponse{URL: urls[res.Index]},
+				Status:           http.StatusInternalServerError,
+				Error:            "failed to encode result",
+				Code:             codeInternal,
+				RequestID:        requestID(r.Context()),
+			}})
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			// The client is gone; drain so the workers can exit
+			continue
+		}
+		rc.Flush()
+	}
+}
+
+// parseURLList splits an uploaded list of URLs separated by newlines or
+// commas, tolerating a UTF-8 BOM, Windows line endings and quoted CSV cells
+func parseURLList(data string) []string {
+	data = strings.TrimPrefix(data, "\ufeff")
+
+	var urls []string
+	for _, field := range strings.FieldsFunc(data, func(r rune) bool {
+		return r == '\n' || r == '\r' || r == ','
+	}) {
+		field = strings.TrimSpace(field)
+		field = strings.TrimSpace(strings.Trim(field, `"'`))
+		if field != "" {
+			urls = append(urls, field)
+		}
+	}
+	return urls
+}
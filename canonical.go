@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Why follow_canonical left a page's own metadata as it was
+const (
+	canonicalSameURL  = "same_url" // The canonical is the page, give or take tracking parameters
+	canonicalCycle    = "cycle"    // The canonical page names the page as its own canonical
+	canonicalUnusable = "unusable" // The canonical page looks like an error or parked page
+	canonicalFailed   = "failed"   // The canonical page couldn't be extracted
+)
+
+// CanonicalFollow records what follow_canonical did: the two URLs, and
+// either the fields taken from the canonical page or why none were
+type CanonicalFollow struct {
+	FetchedURL   string   `json:"fetched_url"`
+	CanonicalURL string   `json:"canonical_url"`
+	Followed     bool     `json:"followed"`
+	Skipped      string   `json:"skipped,omitempty"`
+	Merged       []string `json:"merged,omitempty"`
+}
+
+// followCanonical extracts the page metadata declares as its canonical and
+// takes the fields that describe the content from it, for syndicated copies
+// and mirrors whose own metadata is thinner. It goes one level deep: the
+// canonical page's own canonical is only used to notice a cycle.
+func followCanonical(ctx context.Context, cfg *Config, metadata *MetadataResponse, opts ExtractOptions) {
+	if metadata.Canonical == "" {
+		return
+	}
+	start := time.Now()
+	follow := &CanonicalFollow{FetchedURL: metadata.URL, CanonicalURL: metadata.Canonical}
+	metadata.CanonicalFollow = follow
+	if sameResource(cfg, metadata.URL, metadata.Canonical) {
+		follow.Skipped = canonicalSameURL
+		return
+	}
+
+	// The canonical page is extracted like any other, SSRF checks, host
+	// limits and cache included, within what is left of this one's time
+	canonicalOpts := opts
+	canonicalOpts.FollowCanonical = false
+	canonicalOpts.InlineFavicon = false
+	canonical, err := cachedMetadata(ctx, cfg, metadata.Canonical, canonicalOpts)
+	metadata.Duration += time.Since(start).Milliseconds()
+	switch {
+	case err != nil:
+		follow.Skipped = canonicalFailed
+		addWarning(metadata, warnCanonicalNotFollowed, fmt.Sprintf("canonical %.200q not followed: %v", metadata.Canonical, err))
+		return
+	case canonical.Canonical != "" && sameResource(cfg, canonical.Canonical, metadata.URL):
+		follow.Skipped = canonicalCycle
+		return
+	case canonical.Soft404 || canonical.Parked || canonical.Partial:
+		follow.Skipped = canonicalUnusable
+		return
+	}
+
+	follow.Followed = true
+	follow.Merged = mergeCanonical(metadata, canonical)
+}
+
+// mergeCanonical takes the content fields canonical has from it and
+// returns their names
+func mergeCanonical(metadata, canonical *MetadataResponse) []string {
+	var merged []string
+	take := func(field string, ok bool, set func()) {
+		if !ok {
+			return
+		}
+		set()
+		merged = append(merged, field)
+		if metadata.Sources != nil {
+			metadata.Sources[field] = "canonical"
+		}
+	}
+
+	take("title", canonical.Title != "", func() {
+		metadata.Title = canonical.Title
+		metadata.TitleClean = canonical.TitleClean
+	})
+	take("description", canonical.Description != "", func() { metadata.Description = canonical.Description })
+	take("images", len(canonical.Images) > 0, func() {
+		metadata.Images, metadata.ImageDetails = canonical.Images, canonical.ImageDetails
+		metadata.Colors = canonical.Colors
+	})
+	take("sitename", len(canonical.SiteName) > 0, func() { metadata.SiteName = canonical.SiteName })
+	take("video", canonical.Video != nil, func() { metadata.Video = canonical.Video })
+	take("publisher", canonical.Publisher != nil, func() { metadata.Publisher = canonical.Publisher })
+	return merged
+}
+
+// sameResource reports whether two URLs name the same page: they differ at
+// most in tracking parameters, fragments, the case of the scheme and host,
+// a default port or an empty path
+func sameResource(cfg *Config, a, b string) bool {
+	normalize := func(rawURL string) string {
+		u, err := url.Parse(stripTrackingParams(rawURL, cfg.TrackingParams, cfg.PreservedParams))
+		if err != nil {
+			return rawURL
+		}
+		u.Scheme = strings.ToLower(u.Scheme)
+		u.Host = strings.ToLower(u.Host)
+		if port := u.Port(); (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+			u.Host = strings.TrimSuffix(u.Host, ":"+port)
+		}
+		if u.Path == "" {
+			u.Path = "/"
+		}
+		u.Fragment, u.RawFragment = "", ""
+		return u.String()
+	}
+	return normalize(a) == normalize(b)
+}
@@ -0,0 +1,62 @@
+package main
+
+// Card is the minimal, stable response returned for ?format=card. Every field
+// is always present so clients can rely on the shape as MetadataResponse grows.
+type Card struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	Favicon     string `json:"favicon"`
+	Domain      string `json:"domain"`
+	URL         string `json:"url"`
+}
+
+// CardResult is one entry of a batch response in card format
+type CardResult struct {
+	*Card
+	Status    int    `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Code      string `json:"code,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// BatchCardResponse is the batch response in card format
+type BatchCardResponse struct {
+	Results   []CardResult `json:"results"`
+	Total     int          `json:"total"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+}
+
+// newCard reduces metadata to a card. The image is the first candidate, or
+// the screenshot fallback when the page had none.
+func newCard(metadata *MetadataResponse) *Card {
+	card := &Card{
+		Title:       metadata.Title,
+		Description: metadata.Description,
+		Favicon:     metadata.Favicon,
+		Domain:      metadata.Domain,
+		URL:         metadata.URL,
+	}
+	if len(metadata.Images) > 0 {
+		card.Image = metadata.Images[0]
+	} else {
+		card.Image = metadata.Screenshot
+	}
+	return card
+}
+
+// newBatchCardResponse converts a batch response to card format
+func newBatchCardResponse(batch BatchMetadataResponse) BatchCardResponse {
+	results := make([]CardResult, len(batch.Results))
+	for i, res := range batch.Results {
+		results[i] = CardResult{
+			Card:      newCard(res.MetadataResponse),
+			Status:    res.Status,
+			Error:     res.Error,
+			Code:      res.Code,
+			RequestID: res.RequestID,
+		}
+	}
+	return BatchCardResponse{Results: results, Total: batch.Total, Succeeded: batch.Succeeded, Failed: batch.Failed}
+}
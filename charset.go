@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+const (
+	// charsetSniffBytes is how much of a page is searched for a <meta>
+	// charset, as browsers do
+	charsetSniffBytes = 1024
+
+	// maxDecodingReplacements is how many characters a charset may fail to
+	// decode in a page before another one is tried
+	maxDecodingReplacements = 8
+
+	// assumedCharset is used when DEFAULT_CHARSET is not set. Browsers assume
+	// it for undeclared pages in most locales.
+	assumedCharset = "windows-1252"
+)
+
+// replacementChar is what decoders put in place of bytes they can't decode
+var replacementChar = []byte(string(utf8.RuneError))
+
+// Values of MetadataResponse.CharsetSource
+const (
+	charsetFromBOM      = "bom"
+	charsetFromHeader   = "header"
+	charsetFromMeta     = "meta"
+	charsetFromSniffing = "sniffed"
+	charsetFromDefault  = "default"
+	charsetFromFallback = "fallback"
+)
+
+// pageDecoding is how a page's bytes were turned into UTF-8
+type pageDecoding struct {
+	charset      string
+	source       string
+	replacements int
+	warning      string
+}
+
+// chooseCharset picks the charset of an HTML page from its byte order mark,
+// its Content-Type header or a <meta> tag near its start, in that order.
+// Otherwise, with DETECT_CHARSET, a page that decodes as UTF-8 is UTF-8, and
+// the rest are assumed to be DEFAULT_CHARSET. sample is the start of the
+// page, or all of it.
+func chooseCharset(cfg *Config, sample []byte, contentType string) (encoding.Encoding, *pageDecoding) {
+	enc, name, source := declaredCharset(cfg, sample, contentType)
+	decoding := &pageDecoding{charset: name, source: source}
+
+	// A charset that can't decode the page is likely the wrong one; one
+	// alternative is tried, and kept if it does better
+	replacements := countReplacements(enc, sample)
+	if replacements <= maxDecodingReplacements {
+		return enc, decoding
+	}
+	altName := "utf-8"
+	if name == "utf-8" {
+		altName = cfg.DefaultCharset
+		if altName == "utf-8" {
+			altName = assumedCharset
+		}
+	}
+	alt, _ := charset.Lookup(altName)
+	if altReplacements := countReplacements(alt, sample); altReplacements < replacements {
+		decoding.warning = fmt.Sprintf("page had %d undecodable characters as %s; decoded as %s instead", replacements, name, altName)
+		decoding.charset, decoding.source = altName, charsetFromFallback
+		return alt, decoding
+	}
+	decoding.warning = fmt.Sprintf("page has %d undecodable characters as %s; %s did no better", replacements, name, altName)
+	return enc, decoding
+}
+
+// declaredCharset returns the charset the page declares, or the one it is
+// detected or assumed to be
+func declaredCharset(cfg *Config, sample []byte, contentType string) (encoding.Encoding, string, string) {
+	if len(sample) > charsetSniffBytes {
+		sample = sample[:charsetSniffBytes]
+	}
+	for _, bom := range []struct {
+		prefix string
+		name   string
+	}{{"\xef\xbb\xbf", "utf-8"}, {"\xfe\xff", "utf-16be"}, {"\xff\xfe", "utf-16le"}} {
+		if bytes.HasPrefix(sample, []byte(bom.prefix)) {
+			enc, name := charset.Lookup(bom.name)
+			return enc, name, charsetFromBOM
+		}
+	}
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+		if enc, name := charset.Lookup(params["charset"]); enc != nil {
+			return enc, name, charsetFromHeader
+		}
+	}
+	if enc, name := metaCharset(sample); enc != nil {
+		return enc, name, charsetFromMeta
+	}
+	if cfg.DetectCharset {
+		// Only a partial character at the end of the sample may be invalid
+		valid := sample
+		for i := 1; i < utf8.UTFMax && i <= len(valid); i++ {
+			if start := len(valid) - i; utf8.RuneStart(valid[start]) {
+				if !utf8.FullRune(valid[start:]) {
+					valid = valid[:start]
+				}
+				break
+			}
+		}
+		if utf8.Valid(valid) {
+			enc, name := charset.Lookup("utf-8")
+			return enc, name, charsetFromSniffing
+		}
+	}
+	enc, name := charset.Lookup(cfg.DefaultCharset)
+	return enc, name, charsetFromDefault
+}
+
+// parseCharset parses DEFAULT_CHARSET into the canonical name of a charset
+// known to browsers
+func parseCharset(v string) (string, error) {
+	enc, name := charset.Lookup(strings.TrimSpace(v))
+	if enc == nil {
+		return "", fmt.Errorf("unknown charset %q", v)
+	}
+	// UTF-16 can't be assumed, as with <meta> declarations
+	if strings.HasPrefix(name, "utf-16") {
+		return "", fmt.Errorf("%s can't be assumed, it must be declared", name)
+	}
+	return name, nil
+}
+
+// metaCharset finds a charset declared by <meta charset> or
+// <meta http-equiv="Content-Type"> in sample
+func metaCharset(sample []byte) (encoding.Encoding, string) {
+	z := html.NewTokenizer(bytes.NewReader(sample))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return nil, ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			if token.DataAtom != atom.Meta {
+				continue
+			}
+			var label, httpEquiv, content string
+			for _, attr := range token.Attr {
+				switch strings.ToLower(attr.Key) {
+				case "charset":
+					label = attr.Val
+				case "http-equiv":
+					httpEquiv = attr.Val
+				case "content":
+					content = attr.Val
+				}
+			}
+			if label == "" && strings.EqualFold(httpEquiv, "content-type") {
+				if _, params, err := mime.ParseMediaType(content); err == nil {
+					label = params["charset"]
+				}
+			}
+			if label == "" {
+				continue
+			}
+			enc, name := charset.Lookup(label)
+			// A page can't declare UTF-16 in ASCII, so the declaration is wrong
+			if strings.HasPrefix(name, "utf-16") {
+				enc, name = charset.Lookup("utf-8")
+			}
+			if enc != nil {
+				return enc, name
+			}
+		}
+	}
+}
+
+// countReplacements decodes data with enc and counts the characters it
+// couldn't decode
+func countReplacements(enc encoding.Encoding, data []byte) int {
+	decoded, _, _ := transform.Bytes(enc.NewDecoder(), data)
+	return bytes.Count(decoded, replacementChar)
+}
+
+// replacementCounter counts the replacement characters in the UTF-8 read
+// through it. tail keeps the end of the last read, in case one straddles two.
+type replacementCounter struct {
+	r    io.Reader
+	n    int
+	tail []byte
+}
+
+func (c *replacementCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	buf := append(c.tail, p[:n]...)
+	c.n += bytes.Count(buf, replacementChar)
+	keep := min(len(buf), len(replacementChar)-1)
+	c.tail = append(c.tail[:0], buf[len(buf)-keep:]...)
+	return n, err
+}
+
+// applyDecoding reports how the page was decoded, warning when many of its
+// characters couldn't be
+func applyDecoding(metadata *MetadataResponse, decoding *pageDecoding) {
+	if decoding == nil {
+		return
+	}
+	metadata.Charset = decoding.charset
+	metadata.CharsetSource = decoding.source
+	metadata.DecodingReplacements = decoding.replacements
+	switch {
+	case decoding.warning != "":
+		addWarning(metadata, warnCharsetFallback, decoding.warning)
+	case decoding.replacements > maxDecodingReplacements:
+		addWarning(metadata, warnDecodingErrors, fmt.Sprintf("page has %d undecodable characters as %s", decoding.replacements, decoding.charset))
+	}
+}
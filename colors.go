@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxColorImageBytes caps the download of the image used for colors
+	maxColorImageBytes = 5 * 1024 * 1024
+
+	// maxColorImagePixels guards against decompression bombs
+	maxColorImagePixels = 40 * 1000 * 1000
+
+	// colorSampleSize is the longest side of the sampling grid
+	colorSampleSize = 64
+
+	// maxPaletteSize is the number of swatches returned
+	maxPaletteSize = 5
+
+	// minSwatchDistance is the squared RGB distance two swatches must be apart
+	minSwatchDistance = 48 * 48
+)
+
+// ImageColors is the color summary of the primary image
+type ImageColors struct {
+	Dominant string   `json:"dominant"`
+	Palette  []string `json:"palette"`
+}
+
+// colorBucket accumulates the pixels that quantize to the same color
+type colorBucket struct {
+	key     int
+	count   int
+	r, g, b int
+}
+
+func (c colorBucket) average() (int, int, int) {
+	return c.r / c.count, c.g / c.count, c.b / c.count
+}
+
+// colorsResult is the color summary of an image, for extract_colors and
+// probe_image_color, which share the download
+type colorsResult struct {
+	url    string
+	colors *ImageColors
+	err    error
+}
+
+// fetchColors downloads imageURL and computes its color summary
+func fetchColors(ctx context.Context, cfg *Config, imageURL string) colorsResult {
+	img, err := fetchImage(ctx, cfg, imageURL, maxColorImageBytes)
+	if err != nil {
+		return colorsResult{url: imageURL, err: err}
+	}
+	colors := imageColors(img)
+	if colors == nil {
+		return colorsResult{url: imageURL, err: fmt.Errorf("image is fully transparent")}
+	}
+	return colorsResult{url: imageURL, colors: colors}
+}
+
+// applyColors sets Colors to the primary image's. Failures leave Colors
+// unset and add a warning.
+func applyColors(metadata *MetadataResponse, result colorsResult) {
+	if result.err != nil {
+		addWarning(metadata, warnColorsSkipped, fmt.Sprintf("color extraction skipped: %v", result.err))
+		return
+	}
+	metadata.Colors = result.colors
+}
+
+// applyColorProbe records the dominant color on the matching image, which
+// may have been dropped by verification in the meantime
+func applyColorProbe(metadata *MetadataResponse, result colorsResult) {
+	if result.err != nil {
+		addWarning(metadata, warnColorProbeFailed, fmt.Sprintf("image color probe failed: %v", result.err))
+		return
+	}
+	for i := range metadata.ImageDetails {
+		if metadata.ImageDetails[i].URL == result.url {
+			metadata.ImageDetails[i].DominantColor = result.colors.Dominant
+			return
+		}
+	}
+}
+
+// fetchImage downloads and decodes a JPEG, PNG or GIF image of at most
+// maxBytes, validating it against SSRF rules first
+func fetchImage(ctx context.Context, cfg *Config, imageURL string, maxBytes int64) (image.Image, error) {
+	parsedURL, err := url.Parse(imageURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return nil, fmt.Errorf("invalid image URL")
+	}
+	if err := validateURLForSSRF(cfg, parsedURL); err != nil {
+		return nil, err
+	}
+
+	resp, release, err := fetchURL(ctx, cfg, parsedURL, http.Header{"Accept": {"image/*"}})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP error: %d", resp.StatusCode)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "image/svg+xml" {
+		return nil, fmt.Errorf("unsupported image format: %s", mediaType)
+	}
+	if resp.ContentLength > maxBytes {
+		return nil, fmt.Errorf("image too large: %d bytes", resp.ContentLength)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %v", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("image too large: over %d bytes", maxBytes)
+	}
+
+	imgCfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		if strings.HasPrefix(mediaType, "image/") {
+			return nil, fmt.Errorf("unsupported image format: %s", mediaType)
+		}
+		return nil, fmt.Errorf("unsupported image format")
+	}
+	if imgCfg.Width*imgCfg.Height > maxColorImagePixels {
+		return nil, fmt.Errorf("image too large: %dx%d", imgCfg.Width, imgCfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s image: %v", format, err)
+	}
+	return img, nil
+}
+
+// imageColors samples img on a coarse grid, quantizes the samples and returns
+// the most common color along with a palette of distinct swatches
+func imageColors(img image.Image) *ImageColors {
+	bounds := img.Bounds()
+	step := bounds.Dx()
+	if bounds.Dy() > step {
+		step = bounds.Dy()
+	}
+	step /= colorSampleSize
+	if step < 1 {
+		step = 1
+	}
+
+	buckets := make(map[int]*colorBucket)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			r, g, b, a := img.At(x, y).RGBA()
+			// Skip mostly transparent pixels
+			if a < 0x8000 {
+				continue
+			}
+			r, g, b = r>>8, g>>8, b>>8
+			key := int(r>>5)<<6 | int(g>>5)<<3 | int(b>>5)
+			bucket, ok := buckets[key]
+			if !ok {
+				bucket = &colorBucket{key: key}
+				buckets[key] = bucket
+			}
+			bucket.count++
+			bucket.r += int(r)
+			bucket.g += int(g)
+			bucket.b += int(b)
+		}
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	sorted := make([]colorBucket, 0, len(buckets))
+	for _, bucket := range buckets {
+		sorted = append(sorted, *bucket)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].key < sorted[j].key
+	})
+
+	var palette [][3]int
+	for _, bucket := range sorted {
+		r, g, b := bucket.average()
+		distinct := true
+		for _, c := range palette {
+			dr, dg, db := r-c[0], g-c[1], b-c[2]
+			if dr*dr+dg*dg+db*db < minSwatchDistance {
+				distinct = false
+				break
+			}
+		}
+		if distinct {
+			palette = append(palette, [3]int{r, g, b})
+		}
+		if len(palette) == maxPaletteSize {
+			break
+		}
+	}
+
+	colors := &ImageColors{Dominant: hexColor(palette[0])}
+	for _, c := range palette {
+		colors.Palette = append(colors.Palette, hexColor(c))
+	}
+	return colors
+}
+
+func hexColor(c [3]int) string {
+	return fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2])
+}
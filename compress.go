@@ -0,0 +1,208 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultCompressMinBytes is used when COMPRESS_MIN_BYTES is not set. Below
+// about a packet, compressing saves nothing worth the CPU.
+const defaultCompressMinBytes = 1024
+
+// compressibleTypes are the response media types worth compressing. Images
+// the proxy passes through are already compressed.
+var compressibleTypes = []string{
+	"application/json", "application/x-ndjson", "application/javascript",
+	"application/xml", "image/svg+xml",
+}
+
+// compressEncodings are the content codings responses can be sent in, most
+// preferred first
+var compressEncodings = []string{"gzip", "deflate"}
+
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+// compressMiddleware compresses responses for clients that accept gzip or
+// deflate, once they are COMPRESS_MIN_BYTES long. Streams are compressed
+// too: each Flush sends what was written so far, so NDJSON lines still
+// arrive as they are produced.
+func compressMiddleware(store *configStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := store.Load()
+		if !cfg.CompressResponses || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			encoding:       negotiateEncoding(r.Header.Get("Accept-Encoding")),
+			minBytes:       cfg.CompressMinBytes,
+		}
+		defer cw.finish()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks the first of compressEncodings that acceptEncoding
+// allows, or "" to send the response as it is
+func negotiateEncoding(acceptEncoding string) string {
+	accepted := make(map[string]bool)
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(item, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = v
+			}
+		}
+		accepted[coding] = q > 0
+	}
+	for _, coding := range compressEncodings {
+		if ok, listed := accepted[coding]; ok || (!listed && accepted["*"]) {
+			return coding
+		}
+	}
+	return ""
+}
+
+// compressWriter holds a response back until it knows whether to compress
+// it: at once when the handler set Content-Length, otherwise once minBytes
+// were written or the handler flushes. Responses that end shorter are sent
+// as they are.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minBytes int
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	enc         io.WriteCloser
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if status < 200 {
+		// Informational responses go out as they are and don't end the header
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+
+	h := w.Header()
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	compressible := strings.HasPrefix(mediaType, "text/") || contains(compressibleTypes, mediaType)
+	switch {
+	case !compressible, h.Get("Content-Encoding") != "", h.Get("Content-Range") != "",
+		status == http.StatusNoContent, status == http.StatusNotModified:
+		w.sendPlain()
+		return
+	}
+
+	// The response would have been compressed for another client
+	h.Add("Vary", "Accept-Encoding")
+	if w.encoding == "" {
+		w.sendPlain()
+		return
+	}
+	if length := h.Get("Content-Length"); length != "" {
+		if n, err := strconv.Atoi(length); err == nil && n < w.minBytes {
+			w.sendPlain()
+		} else {
+			w.startCompressing()
+		}
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case w.enc != nil:
+		return w.enc.Write(p)
+	case w.decided:
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minBytes {
+		w.startCompressing()
+	}
+	return len(p), nil
+}
+
+// Flush sends what was written so far, compressing the rest of a response
+// that wasn't decided yet since more is evidently coming
+func (w *compressWriter) Flush() {
+	if w.wroteHeader && !w.decided {
+		w.startCompressing()
+	}
+	if flusher, ok := w.enc.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// sendPlain sends the header, and anything held back, as it is
+func (w *compressWriter) sendPlain() {
+	w.decided = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) > 0 {
+		w.ResponseWriter.Write(w.buf)
+		w.buf = nil
+	}
+}
+
+// startCompressing sends the header for an encoded body and compresses
+// anything held back
+func (w *compressWriter) startCompressing() {
+	w.decided = true
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", w.encoding)
+	w.ResponseWriter.WriteHeader(w.status)
+
+	if w.encoding == "gzip" {
+		gz := gzipWriters.Get().(*gzip.Writer)
+		gz.Reset(w.ResponseWriter)
+		w.enc = gz
+	} else {
+		w.enc = zlib.NewWriter(w.ResponseWriter)
+	}
+	if len(w.buf) > 0 {
+		w.enc.Write(w.buf)
+		w.buf = nil
+	}
+}
+
+// finish ends the response once the handler returned
+func (w *compressWriter) finish() {
+	switch {
+	case !w.wroteHeader:
+	case w.enc != nil:
+		w.enc.Close()
+		if gz, ok := w.enc.(*gzip.Writer); ok {
+			gzipWriters.Put(gz)
+		}
+	case !w.decided:
+		w.Header().Set("Content-Length", strconv.Itoa(len(w.buf)))
+		w.sendPlain()
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding, want string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"GZIP;q=0.5", "gzip"},
+		{"gzip;q=0, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"br", ""},
+		{"*", "gzip"},
+		{"*;q=0", ""},
+		{"gzip;q=0, *", "deflate"},
+		{"*, gzip;q=0, deflate;q=0", ""},
+		{"identity, *;q=0", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.acceptEncoding); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
+
+// compressStore returns a store with compression on from minBytes
+func compressStore(minBytes int) *configStore {
+	cfg := defaultConfig()
+	cfg.CompressResponses = true
+	cfg.CompressMinBytes = minBytes
+	return newConfigStore(cfg, nil)
+}
+
+// serveCompressed runs a response of body with contentType through the
+// middleware for a client sending acceptEncoding
+func serveCompressed(t *testing.T, acceptEncoding, contentType, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	handler := compressMiddleware(compressStore(100), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		io.WriteString(w, body)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	body := `{"title":"` + strings.Repeat("compressible ", 100) + `"}`
+	readers := map[string]func(io.Reader) (io.Reader, error){
+		"gzip":    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"deflate": func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+	}
+	for encoding, newReader := range readers {
+		rec := serveCompressed(t, encoding, "application/json", body)
+		if got := rec.Header().Get("Content-Encoding"); got != encoding {
+			t.Fatalf("%s: Content-Encoding = %q", encoding, got)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("%s: Vary = %q, want Accept-Encoding", encoding, got)
+		}
+		if rec.Header().Get("Content-Length") != "" {
+			t.Errorf("%s: Content-Length is set on an encoded body", encoding)
+		}
+		if rec.Body.Len() >= len(body) {
+			t.Errorf("%s: encoded body is %d bytes, not smaller than %d", encoding, rec.Body.Len(), len(body))
+		}
+		r, err := newReader(rec.Body)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		decoded, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		if string(decoded) != body {
+			t.Errorf("%s: decoded body differs from the original", encoding)
+		}
+	}
+}
+
+func TestCompressHeaders(t *testing.T) {
+	long := strings.Repeat("x", 500)
+	tests := []struct {
+		name, acceptEncoding, contentType, body string
+		encoding, vary                          string
+	}{
+		{"below COMPRESS_MIN_BYTES", "gzip", "application/json", "{}", "", "Accept-Encoding"},
+		{"not accepted", "", "application/json", long, "", "Accept-Encoding"},
+		{"refused with q=0", "gzip;q=0, deflate;q=0", "text/plain", long, "", "Accept-Encoding"},
+		{"incompressible type", "gzip", "image/png", long, "", ""},
+		{"text type", "gzip", "text/html; charset=utf-8", long, "gzip", "Accept-Encoding"},
+	}
+	for _, tt := range tests {
+		rec := serveCompressed(t, tt.acceptEncoding, tt.contentType, tt.body)
+		if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s: Content-Encoding = %q, want %q", tt.name, got, tt.encoding)
+		}
+		if got := rec.Header().Get("Vary"); got != tt.vary {
+			t.Errorf("%s: Vary = %q, want %q", tt.name, got, tt.vary)
+		}
+		if tt.encoding == "" && rec.Body.String() != tt.body {
+			t.Errorf("%s: body was altered", tt.name)
+		}
+	}
+
+	// A short response is sent whole, with its length
+	rec := serveCompressed(t, "gzip", "application/json", "{}")
+	if got := rec.Header().Get("Content-Length"); got != "2" {
+		t.Errorf("short response: Content-Length = %q, want 2", got)
+	}
+}
+
+// Each NDJSON line must reach the client when it is flushed, even though
+// it is shorter than COMPRESS_MIN_BYTES
+func TestCompressFlushesNDJSON(t *testing.T) {
+	next := make(chan struct{})
+	handler := compressMiddleware(compressStore(4096), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for i := 0; i < 2; i++ {
+			io.WriteString(w, `{"line":true}`+"\n")
+			w.(http.Flusher).Flush()
+			select {
+			case <-next:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	defer close(next)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return
+		}
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case line := <-lines:
+			if line != `{"line":true}` {
+				t.Fatalf("line %d = %q", i, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("line %d was held back after its flush", i)
+		}
+		next <- struct{}{}
+	}
+}
@@ -0,0 +1,1081 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the complete server configuration. It is loaded at startup and
+// passed to the server and extractor; the reloadable settings can be swapped
+// at runtime through a configStore.
+type Config struct {
+	Port                 string
+	Listen               string
+	SocketMode           os.FileMode
+	TLSCertFile          string
+	TLSKeyFile           string
+	AutocertDomains      []string
+	AutocertCacheDir     string
+	AutocertEmail        string
+	AllowedOrigin        string
+	EnableJSONP          bool
+	CompressResponses    bool
+	CompressMinBytes     int
+	MaxRequestsPerHost   int
+	InflightLimit        int
+	InflightSoftLimit    int
+	HTMLStreamThreshold  int64
+	MaxMetaContentBytes  int
+	DefaultCharset       string
+	DetectCharset        bool
+	BulkMaxURLs          int
+	MinTLSVersion        string
+	MaxIdleConns         int
+	MaxIdleConnsPerHost  int
+	MaxConnsPerHost      int
+	IdleConnTimeout      time.Duration
+	ForceHTTP2           bool
+	DisableKeepAlives    bool
+	AllowedContentTypes  []string
+	ResponseHeaders      []string
+	RedirectStripHeaders []string
+	BlockedCIDRs         []*net.IPNet
+	BlockedHosts         []string
+	AllowedHosts         []string
+	SSRFAllowHosts       []string
+	SSRFCacheTTL         time.Duration
+	DNSCacheTTL          time.Duration
+	DNSNegativeTTL       time.Duration
+	DNSServers           []string
+	DoHURL               string
+	DNSTimeout           time.Duration
+	DNSFallback          bool
+	HedgeAfter           time.Duration
+	BodyReadTimeout      time.Duration
+	MetadataCacheTTL     time.Duration
+	MetadataCacheMaxTTL  time.Duration
+	BreakerFailures      int
+	BreakerWindow        time.Duration
+	BreakerCooldown      time.Duration
+	UserAgents           []string
+	UserAgentsFile       string
+	UserAgentFileEntries []string
+	UserAgentPins        []userAgentPin
+	UserAgentStickiness  time.Duration
+	ConsentSignatures    *signatureList
+	ConsentFile          string
+	ParkingSignatures    *signatureList
+	ParkingFile          string
+	PaywallMarkers       []string
+	TrackingParams       []string
+	PreservedParams      []string
+	AssetURLSchemes      []string
+	Soft404Patterns      []string
+	ImageProxySecret     string
+	ImageProxyMaxBytes   int64
+	PublicURL            string
+	RendererURL          string
+	ScreenshotSecret     string
+	ScreenshotCacheTTL   time.Duration
+	ScreenshotCacheBytes int
+	AdminToken           string
+	GitHubToken          string
+	ASNDB                string
+}
+
+// setting describes one configuration value. Each can be set in the config
+// file (as the lowercase name), the environment (as name) or on the command
+// line (as the lowercase, dashed name). Reloadable settings are re-read on
+// SIGHUP and POST /admin/reload; the rest need a restart. A reload compares
+// fingerprint, when set, instead of get, for settings such as file paths
+// whose value doesn't change when their content does. An empty value
+// clears a list setting, including one set in the environment.
+type setting struct {
+	name        string
+	usage       string
+	list        bool
+	secret      bool
+	reloadable  bool
+	set         func(c *Config, v string) error
+	get         func(c *Config) string
+	fingerprint func(c *Config) string
+}
+
+var settings = []setting{
+	{
+		name:  "PORT",
+		usage: "port to listen on",
+		set: func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 65535 {
+				return fmt.Errorf("must be a port number between 1 and 65535")
+			}
+			c.Port = v
+			return nil
+		},
+		get: func(c *Config) string { return c.Port },
+	},
+	{
+		name:  "LISTEN",
+		usage: "address to listen on, host:port or unix:/path/to.sock; overrides PORT",
+		set: func(c *Config, v string) error {
+			if path, ok := unixSocketPath(v); ok {
+				if path == "" {
+					return fmt.Errorf("must give a socket path, e.g. unix:/run/metadata.sock")
+				}
+			} else if _, _, err := net.SplitHostPort(v); err != nil {
+				return fmt.Errorf("must be host:port or unix:/path")
+			}
+			c.Listen = v
+			return nil
+		},
+		get: func(c *Config) string { return c.Listen },
+	},
+	{
+		name:  "SOCKET_MODE",
+		usage: "permissions of the Unix socket, in octal",
+		set: func(c *Config, v string) error {
+			mode, err := strconv.ParseUint(v, 8, 32)
+			if err != nil || mode > 0o777 {
+				return fmt.Errorf("must be an octal file mode such as 0660")
+			}
+			c.SocketMode = os.FileMode(mode)
+			return nil
+		},
+		get: func(c *Config) string { return fmt.Sprintf("%#o", uint32(c.SocketMode)) },
+	},
+	{
+		name:  "TLS_CERT_FILE",
+		usage: "PEM certificate chain to serve HTTPS with; requires TLS_KEY_FILE",
+		set:   func(c *Config, v string) error { c.TLSCertFile = v; return nil },
+		get:   func(c *Config) string { return c.TLSCertFile },
+	},
+	{
+		name:  "TLS_KEY_FILE",
+		usage: "PEM private key for TLS_CERT_FILE",
+		set:   func(c *Config, v string) error { c.TLSKeyFile = v; return nil },
+		get:   func(c *Config) string { return c.TLSKeyFile },
+	},
+	{
+		name:  "AUTOCERT_DOMAINS",
+		usage: "comma-separated hostnames to obtain Let's Encrypt certificates for; serves HTTPS on :443 and redirects :80",
+		list:  true,
+		set:   func(c *Config, v string) error { c.AutocertDomains = parseHostList(v); return nil },
+		get:   func(c *Config) string { return strings.Join(c.AutocertDomains, ",") },
+	},
+	{
+		name:  "AUTOCERT_CACHE_DIR",
+		usage: "directory where Let's Encrypt certificates are stored",
+		set:   func(c *Config, v string) error { c.AutocertCacheDir = v; return nil },
+		get:   func(c *Config) string { return c.AutocertCacheDir },
+	},
+	{
+		name:  "AUTOCERT_EMAIL",
+		usage: "contact address given to Let's Encrypt for expiry notices",
+		set:   func(c *Config, v string) error { c.AutocertEmail = v; return nil },
+		get:   func(c *Config) string { return c.AutocertEmail },
+	},
+	{
+		name:  "ALLOWED_ORIGIN",
+		usage: "CORS allowed origin",
+		set:   func(c *Config, v string) error { c.AllowedOrigin = v; return nil },
+		get:   func(c *Config) string { return c.AllowedOrigin },
+	},
+	{
+		name:       "ENABLE_JSONP",
+		usage:      "allow GET /extract to answer as JSONP with ?callback=, for clients that can only load scripts",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.EnableJSONP, err = parseBool(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.FormatBool(c.EnableJSONP) },
+	},
+	{
+		name:       "COMPRESS_RESPONSES",
+		usage:      "gzip or deflate responses for clients that accept it",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.CompressResponses, err = parseBool(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.FormatBool(c.CompressResponses) },
+	},
+	{
+		name:       "COMPRESS_MIN_BYTES",
+		usage:      "responses shorter than this are sent uncompressed",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.CompressMinBytes, err = parseNonNegativeInt(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.CompressMinBytes) },
+	},
+	{
+		name:       "MAX_REQUESTS_PER_HOST",
+		usage:      "concurrent upstream requests allowed per host",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.MaxRequestsPerHost, err = parsePositiveInt(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.MaxRequestsPerHost) },
+	},
+	{
+		name:  "BULK_MAX_URLS",
+		usage: "maximum number of URLs in a /extract/bulk upload",
+		set: func(c *Config, v string) (err error) {
+			c.BulkMaxURLs, err = parsePositiveInt(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.BulkMaxURLs) },
+	},
+	{
+		name:  "MIN_TLS_VERSION",
+		usage: "oldest TLS version accepted from upstream servers (1.0, 1.1, 1.2 or 1.3)",
+		set: func(c *Config, v string) error {
+			if _, err := parseTLSVersion(v); err != nil {
+				return err
+			}
+			c.MinTLSVersion = v
+			return nil
+		},
+		get: func(c *Config) string { return c.MinTLSVersion },
+	},
+	{
+		name:  "MAX_IDLE_CONNS",
+		usage: "idle upstream connections kept open across all hosts; 0 means no limit",
+		set: func(c *Config, v string) (err error) {
+			c.MaxIdleConns, err = parseNonNegativeInt(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.MaxIdleConns) },
+	},
+	{
+		name:  "MAX_IDLE_CONNS_PER_HOST",
+		usage: "idle upstream connections kept open per host; defaults to MAX_REQUESTS_PER_HOST",
+		set: func(c *Config, v string) (err error) {
+			c.MaxIdleConnsPerHost, err = parsePositiveInt(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.MaxIdleConnsPerHost) },
+	},
+	{
+		name:  "MAX_CONNS_PER_HOST",
+		usage: "upstream connections open per host, idle or not; 0 means no limit",
+		set: func(c *Config, v string) (err error) {
+			c.MaxConnsPerHost, err = parseNonNegativeInt(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.MaxConnsPerHost) },
+	},
+	{
+		name:  "IDLE_CONN_TIMEOUT",
+		usage: "how long an idle upstream connection is kept open; 0 keeps it until the server closes it",
+		set: func(c *Config, v string) (err error) {
+			c.IdleConnTimeout, err = parseNonNegativeDuration(v)
+			return err
+		},
+		get: func(c *Config) string { return c.IdleConnTimeout.String() },
+	},
+	{
+		name:  "FORCE_HTTP2",
+		usage: "offer HTTP/2 to upstream servers over TLS; false speaks only HTTP/1.1",
+		set: func(c *Config, v string) (err error) {
+			c.ForceHTTP2, err = parseBool(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.FormatBool(c.ForceHTTP2) },
+	},
+	{
+		name:  "DISABLE_KEEPALIVES",
+		usage: "close every upstream connection after its response instead of pooling it",
+		set: func(c *Config, v string) (err error) {
+			c.DisableKeepAlives, err = parseBool(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.FormatBool(c.DisableKeepAlives) },
+	},
+	{
+		name:       "RESPONSE_HEADERS",
+		usage:      "comma-separated upstream response headers returned in response_headers; empty returns none",
+		list:       true,
+		reloadable: true,
+		set: func(c *Config, v string) error {
+			c.ResponseHeaders = parseHeaderNames(v)
+			return nil
+		},
+		get: func(c *Config) string { return strings.Join(c.ResponseHeaders, ",") },
+	},
+	{
+		name:       "REDIRECT_STRIP_HEADERS",
+		usage:      "comma-separated request headers removed when a redirect leads to another origin",
+		list:       true,
+		reloadable: true,
+		set: func(c *Config, v string) error {
+			c.RedirectStripHeaders = parseHeaderNames(v)
+			return nil
+		},
+		get: func(c *Config) string { return strings.Join(c.RedirectStripHeaders, ",") },
+	},
+	{
+		name:  "ALLOWED_CONTENT_TYPES",
+		usage: "comma-separated media types to extract from",
+		set: func(c *Config, v string) error {
+			c.AllowedContentTypes = parseContentTypes(v)
+			if len(c.AllowedContentTypes) == 0 {
+				return fmt.Errorf("must list at least one media type")
+			}
+			return nil
+		},
+		get: func(c *Config) string { return strings.Join(c.AllowedContentTypes, ",") },
+	},
+	{
+		name:       "BLOCKED_CIDRS",
+		usage:      "comma-separated CIDR ranges to block in addition to private and reserved addresses",
+		list:       true,
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.BlockedCIDRs, err = parseCIDRs(v)
+			return err
+		},
+		get: func(c *Config) string {
+			cidrs := make([]string, len(c.BlockedCIDRs))
+			for i, cidr := range c.BlockedCIDRs {
+				cidrs[i] = cidr.String()
+			}
+			return strings.Join(cidrs, ",")
+		},
+	},
+	{
+		name:       "BLOCKED_HOSTS",
+		usage:      "comma-separated hosts that may not be fetched, including their subdomains",
+		list:       true,
+		reloadable: true,
+		set:        func(c *Config, v string) error { c.BlockedHosts = parseHostList(v); return nil },
+		get:        func(c *Config) string { return strings.Join(c.BlockedHosts, ",") },
+	},
+	{
+		name:       "ALLOWED_HOSTS",
+		usage:      "comma-separated hosts, including their subdomains, that are the only ones fetched when set",
+		list:       true,
+		reloadable: true,
+		set:        func(c *Config, v string) error { c.AllowedHosts = parseHostList(v); return nil },
+		get:        func(c *Config) string { return strings.Join(c.AllowedHosts, ",") },
+	},
+	{
+		name:       "SSRF_ALLOW_HOSTS",
+		usage:      "comma-separated hosts and IPs, matched exactly, that may be fetched at private and internal addresses",
+		list:       true,
+		reloadable: true,
+		set:        func(c *Config, v string) error { c.SSRFAllowHosts = parseHostList(v); return nil },
+		get:        func(c *Config) string { return strings.Join(c.SSRFAllowHosts, ",") },
+	},
+	{
+		name:       "SSRF_CACHE_TTL",
+		usage:      "how long a host's SSRF check result is reused; 0 disables the cache",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.SSRFCacheTTL, err = parseNonNegativeDuration(v)
+			return err
+		},
+		get: func(c *Config) string { return c.SSRFCacheTTL.String() },
+	},
+	{
+		name:       "DNS_CACHE_TTL",
+		usage:      "longest time a DNS answer is reused; 0 disables the DNS cache",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.DNSCacheTTL, err = parseNonNegativeDuration(v)
+			return err
+		},
+		get: func(c *Config) string { return c.DNSCacheTTL.String() },
+	},
+	{
+		name:       "DNS_NEGATIVE_TTL",
+		usage:      "how long a nonexistent hostname is remembered; 0 disables negative caching",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.DNSNegativeTTL, err = parseNonNegativeDuration(v)
+			return err
+		},
+		get: func(c *Config) string { return c.DNSNegativeTTL.String() },
+	},
+	{
+		name:  "DNS_SERVERS",
+		usage: "comma-separated DNS servers (ip or ip:port) to resolve upstream hosts with instead of the system resolver",
+		set: func(c *Config, v string) (err error) {
+			c.DNSServers, err = parseDNSServers(v)
+			return err
+		},
+		get: func(c *Config) string { return strings.Join(c.DNSServers, ",") },
+	},
+	{
+		name:  "DOH_URL",
+		usage: "DNS-over-HTTPS (RFC 8484) endpoint to resolve upstream hosts with, e.g. https://cloudflare-dns.com/dns-query",
+		set: func(c *Config, v string) error {
+			u, err := url.Parse(v)
+			if err != nil || u.Scheme != "https" || u.Host == "" {
+				return fmt.Errorf("must be an https URL")
+			}
+			c.DoHURL = v
+			return nil
+		},
+		get: func(c *Config) string { return c.DoHURL },
+	},
+	{
+		name:  "DNS_TIMEOUT",
+		usage: "how long to wait for DNS_SERVERS or DOH_URL to answer",
+		set: func(c *Config, v string) (err error) {
+			c.DNSTimeout, err = parsePositiveDuration(v)
+			return err
+		},
+		get: func(c *Config) string { return c.DNSTimeout.String() },
+	},
+	{
+		name:  "DNS_FALLBACK",
+		usage: "fall back to the system resolver when DNS_SERVERS or DOH_URL can't be reached",
+		set: func(c *Config, v string) (err error) {
+			c.DNSFallback, err = parseBool(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.FormatBool(c.DNSFallback) },
+	},
+	{
+		name:       "INFLIGHT_LIMIT",
+		usage:      "requests served at once before the rest get a 503; 0 means no limit",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.InflightLimit, err = parseNonNegativeInt(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.InflightLimit) },
+	},
+	{
+		name:       "INFLIGHT_SOFT_LIMIT",
+		usage:      "requests served at once before responses carry a Retry-After hint; 0 disables the hint",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.InflightSoftLimit, err = parseNonNegativeInt(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.InflightSoftLimit) },
+	},
+	{
+		name:       "HEDGE_AFTER",
+		usage:      "send a second copy of an upstream request that has no response headers after this long; 0 disables hedging",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.HedgeAfter, err = parseNonNegativeDuration(v)
+			return err
+		},
+		get: func(c *Config) string { return c.HedgeAfter.String() },
+	},
+	{
+		name:       "BODY_READ_TIMEOUT",
+		usage:      "how long reading a page's body may take once its headers arrived, however steadily it arrives; 0 leaves only the overall timeout",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.BodyReadTimeout, err = parseNonNegativeDuration(v)
+			return err
+		},
+		get: func(c *Config) string { return c.BodyReadTimeout.String() },
+	},
+	{
+		name:       "HTML_STREAM_THRESHOLD",
+		usage:      "bytes of a page read into memory before the rest is parsed as it downloads; 0 parses every page as it downloads",
+		reloadable: true,
+		set: func(c *Config, v string) error {
+			n, err := parseNonNegativeInt(v)
+			c.HTMLStreamThreshold = int64(n)
+			return err
+		},
+		get: func(c *Config) string { return strconv.FormatInt(c.HTMLStreamThreshold, 10) },
+	},
+	{
+		name:       "MAX_META_CONTENT_BYTES",
+		usage:      "bytes of a <meta> tag's content kept; longer values are cut off",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.MaxMetaContentBytes, err = parsePositiveInt(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.MaxMetaContentBytes) },
+	},
+	{
+		name:       "DEFAULT_CHARSET",
+		usage:      "charset assumed for HTML pages that declare none, such as windows-1252 or shift_jis",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.DefaultCharset, err = parseCharset(v)
+			return err
+		},
+		get: func(c *Config) string { return c.DefaultCharset },
+	},
+	{
+		name:       "DETECT_CHARSET",
+		usage:      "read HTML pages that declare no charset as UTF-8 when they decode as it, before assuming DEFAULT_CHARSET",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.DetectCharset, err = parseBool(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.FormatBool(c.DetectCharset) },
+	},
+	{
+		name:       "METADATA_CACHE_TTL",
+		usage:      "how long an extraction result is reused for the same URL and options; 0 disables the cache",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.MetadataCacheTTL, err = parseNonNegativeDuration(v)
+			return err
+		},
+		get: func(c *Config) string { return c.MetadataCacheTTL.String() },
+	},
+	{
+		name:       "METADATA_CACHE_MAX_TTL",
+		usage:      "longest cache_ttl_ms a request can ask for",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.MetadataCacheMaxTTL, err = parseNonNegativeDuration(v)
+			return err
+		},
+		get: func(c *Config) string { return c.MetadataCacheMaxTTL.String() },
+	},
+	{
+		name:       "BREAKER_FAILURES",
+		usage:      "consecutive upstream failures that open a domain's circuit breaker; 0 disables the breaker",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.BreakerFailures, err = parseNonNegativeInt(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.BreakerFailures) },
+	},
+	{
+		name:       "BREAKER_WINDOW",
+		usage:      "time within which BREAKER_FAILURES failures must happen to open the breaker",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.BreakerWindow, err = parsePositiveDuration(v)
+			return err
+		},
+		get: func(c *Config) string { return c.BreakerWindow.String() },
+	},
+	{
+		name:       "BREAKER_COOLDOWN",
+		usage:      "how long an open breaker refuses requests before letting a probe through",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.BreakerCooldown, err = parsePositiveDuration(v)
+			return err
+		},
+		get: func(c *Config) string { return c.BreakerCooldown.String() },
+	},
+	{
+		name:       "USER_AGENTS",
+		usage:      "|-separated pool of User-Agent strings to rotate through",
+		list:       true,
+		reloadable: true,
+		set:        func(c *Config, v string) error { c.UserAgents = parseUserAgents(v); return nil },
+		get:        func(c *Config) string { return strings.Join(c.UserAgents, "|") },
+	},
+	{
+		name:       "USER_AGENTS_FILE",
+		usage:      "file with one User-Agent per line, added to the USER_AGENTS pool",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.UserAgentsFile, c.UserAgentFileEntries = v, nil
+			if v != "" {
+				c.UserAgentFileEntries, err = readUserAgentsFile(v)
+			}
+			return err
+		},
+		get:         func(c *Config) string { return c.UserAgentsFile },
+		fingerprint: func(c *Config) string { return c.UserAgentsFile + "\n" + strings.Join(c.UserAgentFileEntries, "\n") },
+	},
+	{
+		name:       "CONSENT_SIGNATURES_FILE",
+		usage:      "JSON file of cookie-consent wall signatures (script_hosts, markers, phrases) replacing the built-in list",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.ConsentFile, c.ConsentSignatures = v, defaultConsentSignatures
+			if v != "" {
+				c.ConsentSignatures, err = readSignatureFile(v)
+			}
+			return err
+		},
+		get: func(c *Config) string { return c.ConsentFile },
+		fingerprint: func(c *Config) string {
+			sigs, _ := json.Marshal(c.ConsentSignatures)
+			return c.ConsentFile + "\n" + string(sigs)
+		},
+	},
+	{
+		name:       "PARKING_SIGNATURES_FILE",
+		usage:      "JSON file of parked-domain signatures (script_hosts, markers, phrases, nameservers) replacing the built-in list",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.ParkingFile, c.ParkingSignatures = v, defaultParkingSignatures
+			if v != "" {
+				c.ParkingSignatures, err = readSignatureFile(v)
+			}
+			return err
+		},
+		get: func(c *Config) string { return c.ParkingFile },
+		fingerprint: func(c *Config) string {
+			sigs, _ := json.Marshal(c.ParkingSignatures)
+			return c.ParkingFile + "\n" + string(sigs)
+		},
+	},
+	{
+		name:       "PAYWALL_MARKERS",
+		usage:      "comma-separated substrings of the element IDs and classes of paywall containers",
+		list:       true,
+		reloadable: true,
+		set:        func(c *Config, v string) error { c.PaywallMarkers = parsePaywallMarkers(v); return nil },
+		get:        func(c *Config) string { return strings.Join(c.PaywallMarkers, ",") },
+	},
+	{
+		name:       "TRACKING_PARAMS",
+		usage:      "comma-separated query parameters clean_image_urls strips; a trailing * matches a prefix",
+		list:       true,
+		reloadable: true,
+		set:        func(c *Config, v string) error { c.TrackingParams = parseParamNames(v); return nil },
+		get:        func(c *Config) string { return strings.Join(c.TrackingParams, ",") },
+	},
+	{
+		name:       "PRESERVED_PARAMS",
+		usage:      "comma-separated query parameters clean_image_urls keeps even when TRACKING_PARAMS matches them",
+		list:       true,
+		reloadable: true,
+		set:        func(c *Config, v string) error { c.PreservedParams = parseParamNames(v); return nil },
+		get:        func(c *Config) string { return strings.Join(c.PreservedParams, ",") },
+	},
+	{
+		name:       "ASSET_URL_SCHEMES",
+		usage:      "comma-separated URL schemes image and favicon URLs may have; others are dropped",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.AssetURLSchemes, err = parseSchemes(v)
+			return err
+		},
+		get: func(c *Config) string { return strings.Join(c.AssetURLSchemes, ",") },
+	},
+	{
+		name:       "SOFT_404_PATTERNS",
+		usage:      "|-separated phrases that mark a page's title or description as an error page",
+		list:       true,
+		reloadable: true,
+		set:        func(c *Config, v string) error { c.Soft404Patterns = parseSoft404Patterns(v); return nil },
+		get:        func(c *Config) string { return strings.Join(c.Soft404Patterns, "|") },
+	},
+	{
+		name:       "USER_AGENT_PINS",
+		usage:      "|-separated host=User-Agent pairs; a host and its subdomains always get that User-Agent",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.UserAgentPins, err = parseUserAgentPins(v)
+			return err
+		},
+		get: func(c *Config) string { return formatUserAgentPins(c.UserAgentPins) },
+	},
+	{
+		name:       "USER_AGENT_STICKINESS",
+		usage:      "how long a host keeps the User-Agent picked for it from the pool",
+		reloadable: true,
+		set: func(c *Config, v string) (err error) {
+			c.UserAgentStickiness, err = parsePositiveDuration(v)
+			return err
+		},
+		get: func(c *Config) string { return c.UserAgentStickiness.String() },
+	},
+	{
+		name:   "IMAGE_PROXY_SECRET",
+		usage:  "secret used to sign /img URLs; the image proxy is disabled when empty",
+		secret: true,
+		set:    func(c *Config, v string) error { c.ImageProxySecret = v; return nil },
+		get:    func(c *Config) string { return c.ImageProxySecret },
+	},
+	{
+		name:  "IMAGE_PROXY_MAX_BYTES",
+		usage: "largest image the proxy will serve",
+		set: func(c *Config, v string) error {
+			n, err := parsePositiveInt(v)
+			c.ImageProxyMaxBytes = int64(n)
+			return err
+		},
+		get: func(c *Config) string { return strconv.FormatInt(c.ImageProxyMaxBytes, 10) },
+	},
+	{
+		name:  "PUBLIC_URL",
+		usage: "base URL of this service, prepended to emitted proxy and screenshot URLs",
+		set: func(c *Config, v string) error {
+			c.PublicURL = strings.TrimSuffix(v, "/")
+			return nil
+		},
+		get: func(c *Config) string { return c.PublicURL },
+	},
+	{
+		name:  "RENDERER_URL",
+		usage: "screenshot endpoint of a browserless-compatible headless browser service",
+		set:   func(c *Config, v string) error { c.RendererURL = v; return nil },
+		get:   func(c *Config) string { return c.RendererURL },
+	},
+	{
+		name:   "SCREENSHOT_SECRET",
+		usage:  "secret used to sign /screenshot URLs",
+		secret: true,
+		set:    func(c *Config, v string) error { c.ScreenshotSecret = v; return nil },
+		get:    func(c *Config) string { return c.ScreenshotSecret },
+	},
+	{
+		name:  "SCREENSHOT_CACHE_TTL",
+		usage: "how long rendered screenshots are cached",
+		set: func(c *Config, v string) (err error) {
+			c.ScreenshotCacheTTL, err = parsePositiveDuration(v)
+			return err
+		},
+		get: func(c *Config) string { return c.ScreenshotCacheTTL.String() },
+	},
+	{
+		name:  "SCREENSHOT_CACHE_BYTES",
+		usage: "memory budget for cached screenshots",
+		set: func(c *Config, v string) (err error) {
+			c.ScreenshotCacheBytes, err = parsePositiveInt(v)
+			return err
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.ScreenshotCacheBytes) },
+	},
+	{
+		name:   "ADMIN_TOKEN",
+		usage:  "bearer token for the /admin endpoints, which are disabled when empty",
+		secret: true,
+		set:    func(c *Config, v string) error { c.AdminToken = v; return nil },
+		get:    func(c *Config) string { return c.AdminToken },
+	},
+	{
+		name:       "GITHUB_TOKEN",
+		usage:      "token for GitHub's API, which enriches repository links; without it the lower anonymous rate limit applies",
+		secret:     true,
+		reloadable: true,
+		set:        func(c *Config, v string) error { c.GitHubToken = v; return nil },
+		get:        func(c *Config) string { return c.GitHubToken },
+	},
+	{
+		name:  "ASN_DB",
+		usage: "IP-to-ASN table (iptoasn.com TSV format) for the include_asn option",
+		set:   func(c *Config, v string) error { c.ASNDB = v; return nil },
+		get:   func(c *Config) string { return c.ASNDB },
+	},
+}
+
+// defaultConfig returns the configuration used when nothing is set
+func defaultConfig() *Config {
+	return &Config{
+		Port:                 "8080",
+		SocketMode:           defaultSocketMode,
+		AutocertCacheDir:     defaultAutocertCacheDir,
+		AllowedOrigin:        "*",
+		CompressResponses:    true,
+		CompressMinBytes:     defaultCompressMinBytes,
+		MaxRequestsPerHost:   defaultMaxRequestsPerHost,
+		BulkMaxURLs:          defaultBulkMaxURLs,
+		MaxIdleConns:         defaultMaxIdleConns,
+		MaxIdleConnsPerHost:  defaultMaxRequestsPerHost,
+		IdleConnTimeout:      defaultIdleConnTimeout,
+		ForceHTTP2:           true,
+		SSRFCacheTTL:         defaultSSRFCacheTTL,
+		DNSCacheTTL:          defaultDNSCacheTTL,
+		DNSNegativeTTL:       defaultDNSNegativeTTL,
+		DNSTimeout:           defaultDNSTimeout,
+		MetadataCacheMaxTTL:  defaultMetadataCacheMaxTTL,
+		BreakerFailures:      defaultBreakerFailures,
+		BreakerWindow:        defaultBreakerWindow,
+		BreakerCooldown:      defaultBreakerCooldown,
+		UserAgentStickiness:  defaultUserAgentStickiness,
+		ConsentSignatures:    defaultConsentSignatures,
+		ParkingSignatures:    defaultParkingSignatures,
+		PaywallMarkers:       defaultPaywallMarkers,
+		TrackingParams:       defaultTrackingParams,
+		PreservedParams:      defaultPreservedParams,
+		AssetURLSchemes:      defaultAssetURLSchemes,
+		Soft404Patterns:      defaultSoft404Patterns,
+		AllowedContentTypes:  []string{"text/html", "application/xhtml+xml"},
+		ResponseHeaders:      defaultResponseHeaders,
+		RedirectStripHeaders: defaultRedirectStripHeaders,
+		ImageProxyMaxBytes:   defaultProxyMaxBytes,
+		HTMLStreamThreshold:  defaultHTMLStreamThreshold,
+		MaxMetaContentBytes:  defaultMaxMetaContentBytes,
+		BodyReadTimeout:      defaultBodyReadTimeout,
+		DefaultCharset:       assumedCharset,
+		DetectCharset:        true,
+		ScreenshotCacheTTL:   defaultScreenshotCacheTTL,
+		ScreenshotCacheBytes: defaultScreenshotCacheBytes,
+	}
+}
+
+// loadConfig builds the configuration from, in increasing order of
+// precedence: defaults, the config file, the environment and flags. The
+// config file is given by -config or CONFIG_FILE.
+func loadConfig(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("metadata-api", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.name] = fs.String(flagName(s.name), "", s.usage+" (env "+s.name+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+	// Unless set, MAX_IDLE_CONNS_PER_HOST follows MAX_REQUESTS_PER_HOST
+	cfg.MaxIdleConnsPerHost = 0
+
+	if *configFile != "" {
+		if err := loadConfigFile(cfg, *configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		// An empty variable is ignored like an unset one, unless it clears a list
+		if v, ok := os.LookupEnv(s.name); ok && (v != "" || s.list) {
+			if err := s.set(cfg, v); err != nil {
+				return nil, fmt.Errorf("environment variable %s=%q: %v", s.name, v, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if err == nil && f.Name == flagName(s.name) {
+				if setErr := s.set(cfg, *flagValues[s.name]); setErr != nil {
+					err = fmt.Errorf("flag -%s=%q: %v", f.Name, f.Value, setErr)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxIdleConnsPerHost == 0 {
+		cfg.MaxIdleConnsPerHost = cfg.MaxRequestsPerHost
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validate checks the settings that depend on each other
+func (c *Config) validate() error {
+	if c.TLSCertFile != "" && c.TLSKeyFile == "" {
+		return fmt.Errorf("TLS_CERT_FILE is set but TLS_KEY_FILE is not; both are needed to serve HTTPS")
+	}
+	if c.TLSKeyFile != "" && c.TLSCertFile == "" {
+		return fmt.Errorf("TLS_KEY_FILE is set but TLS_CERT_FILE is not; both are needed to serve HTTPS")
+	}
+	if len(c.AutocertDomains) > 0 {
+		if c.TLSCertFile != "" {
+			return fmt.Errorf("AUTOCERT_DOMAINS cannot be combined with TLS_CERT_FILE and TLS_KEY_FILE; use one or the other")
+		}
+		if c.Listen != "" {
+			return fmt.Errorf("AUTOCERT_DOMAINS listens on :443 and :80 and cannot be combined with LISTEN")
+		}
+	}
+	if len(c.DNSServers) > 0 && c.DoHURL != "" {
+		return fmt.Errorf("DNS_SERVERS and DOH_URL cannot both be set; choose one resolver")
+	}
+	return nil
+}
+
+// configLine matches "key: value" (YAML) and "key = value" (TOML)
+var configLine = regexp.MustCompile(`^([A-Za-z0-9_-]+)\s*[:=]\s*(.*)$`)
+
+// loadConfigFile applies a flat YAML or TOML file of settings to cfg. Keys
+// are the setting names in any case, with dashes or underscores.
+func loadConfigFile(cfg *Config, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+
+		match := configLine.FindStringSubmatch(line)
+		if match == nil {
+			return fmt.Errorf("%s:%d: expected \"key: value\" or \"key = value\"", path, lineNo)
+		}
+		name := strings.ToUpper(strings.ReplaceAll(match[1], "-", "_"))
+		s, ok := findSetting(name)
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown setting %q", path, lineNo, match[1])
+		}
+		value := parseConfigValue(match[2])
+		if err := s.set(cfg, value); err != nil {
+			return fmt.Errorf("%s:%d: %s=%q: %v", path, lineNo, match[1], value, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("config file: %v", err)
+	}
+	return nil
+}
+
+// parseConfigValue strips comments and quotes from a config file value and
+// flattens ["a", "b"] lists into "a,b"
+func parseConfigValue(raw string) string {
+	raw = stripComment(strings.TrimSpace(raw))
+	if strings.HasPrefix(raw, "[") && strings.HasSuffix(raw, "]") {
+		items := strings.Split(raw[1:len(raw)-1], ",")
+		for i, item := range items {
+			items[i] = unquote(strings.TrimSpace(item))
+		}
+		return strings.Join(items, ",")
+	}
+	return unquote(raw)
+}
+
+// stripComment removes a trailing " # comment" that is not inside quotes
+func stripComment(s string) string {
+	var quote rune
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return strings.TrimSpace(s[:i])
+		}
+	}
+	return s
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func findSetting(name string) (setting, bool) {
+	for _, s := range settings {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+func flagName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+}
+
+// Redacted returns the configuration as NAME=value pairs with secrets hidden
+func (c *Config) Redacted() string {
+	var parts []string
+	for _, s := range settings {
+		v := s.get(c)
+		if s.secret && v != "" {
+			v = "[redacted]"
+		}
+		parts = append(parts, s.name+"="+v)
+	}
+	return strings.Join(parts, " ")
+}
+
+func parsePositiveInt(v string) (int, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("must be a positive integer")
+	}
+	return n, nil
+}
+
+func parseNonNegativeInt(v string) (int, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("must be a non-negative integer")
+	}
+	return n, nil
+}
+
+func parsePositiveDuration(v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("must be a duration such as 30s, 5m or 1h")
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be greater than zero")
+	}
+	return d, nil
+}
+
+func parseBool(v string) (bool, error) {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("must be true or false")
+	}
+	return b, nil
+}
+
+// parseNonNegativeDuration parses a duration where 0 means disabled
+func parseNonNegativeDuration(v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("must be a duration such as 30s, or 0 to disable")
+	}
+	return d, nil
+}
+
+// parseCIDRs parses a comma-separated list of CIDR ranges
+func parseCIDRs(v string) ([]*net.IPNet, error) {
+	var cidrs []*net.IPNet
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		_, cidr, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q (expected e.g. 10.0.0.0/8)", item)
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
+
+// parseHostList parses a comma-separated list of hostnames, lowercased and
+// without trailing dots
+func parseHostList(v string) []string {
+	var hosts []string
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(item)), ".")
+		if item != "" {
+			hosts = append(hosts, item)
+		}
+	}
+	return hosts
+}
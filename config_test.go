@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestLoadConfigEmptyEnv(t *testing.T) {
+	for _, s := range settings {
+		if s.list {
+			t.Setenv(s.name, "")
+		}
+	}
+	t.Setenv("PORT", "")
+
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != defaultConfig().Port {
+		t.Errorf("Port = %q, want the default for an empty PORT", cfg.Port)
+	}
+	for _, s := range settings {
+		if s.list && s.get(cfg) != "" {
+			t.Errorf("%s = %q, want it cleared", s.name, s.get(cfg))
+		}
+	}
+}
+
+func TestLoadConfigIdleConnsPerHost(t *testing.T) {
+	t.Setenv("MAX_REQUESTS_PER_HOST", "16")
+	t.Setenv("MAX_IDLE_CONNS_PER_HOST", "")
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxIdleConnsPerHost != 16 {
+		t.Errorf("MaxIdleConnsPerHost = %d, want MAX_REQUESTS_PER_HOST's 16", cfg.MaxIdleConnsPerHost)
+	}
+
+	t.Setenv("MAX_IDLE_CONNS_PER_HOST", "3")
+	cfg, err = loadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxIdleConnsPerHost != 3 {
+		t.Errorf("MaxIdleConnsPerHost = %d, want the 3 set", cfg.MaxIdleConnsPerHost)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// defaultConsentSignatures is used when CONSENT_SIGNATURES_FILE is not set
+var defaultConsentSignatures = &signatureList{
+	ScriptHosts: []string{
+		"cookielaw.org",
+		"onetrust.com",
+		"cookiebot.com",
+		"quantcast.com",
+		"consensu.org",
+		"trustarc.com",
+		"didomi.io",
+		"usercentrics.eu",
+		"sourcepoint.com",
+		"privacy-mgmt.com",
+		"cookiepro.com",
+		"iubenda.com",
+		"consentmanager.net",
+	},
+	Markers: []string{
+		"onetrust",
+		"qc-cmp2",
+		"cookiebot",
+		"didomi",
+		"sp_message_container",
+		"truste-consent",
+		"usercentrics",
+		"cmpbox",
+		"consent-wall",
+		"cookie-wall",
+	},
+	Phrases: []string{
+		"before you continue",
+		"we value your privacy",
+		"your privacy choices",
+		"cookie consent",
+		"bevor sie fortfahren",
+		"wir verwenden cookies",
+		"avant de continuer",
+		"nous utilisons des cookies",
+		"antes de continuar",
+		"utilizamos cookies",
+		"prima di continuare",
+		"utilizziamo i cookie",
+		"voordat je verdergaat",
+		"wij gebruiken cookies",
+	},
+}
+
+// consentMatch is what detectConsentWall found, along with the page's social
+// titles in case the real ones were hidden behind the wall
+type consentMatch struct {
+	signature         string
+	titleWall         bool
+	descriptionWall   bool
+	socialTitle       string
+	socialDescriptor  string
+	titleSource       string // The tag socialTitle came from
+	descriptionSource string
+}
+
+// detectConsentWall looks for signs that doc is a consent interstitial
+// rather than the page that was asked for: a wall phrase in its title or
+// description, or a consent platform's script or element on a page that
+// lacks metadata. Most pages load a platform for a dismissible banner, so
+// the script or element alone isn't enough.
+func detectConsentWall(doc *html.Node, metadata *MetadataResponse, sigs *signatureList, maxMetaContent int) *consentMatch {
+	match := &consentMatch{}
+	platform := ""
+	if phrase := matchPhrase(metadata.Title, sigs.Phrases); phrase != "" {
+		match.signature, match.titleWall = "title: "+phrase, true
+	}
+	if phrase := matchPhrase(metadata.Description, sigs.Phrases); phrase != "" {
+		match.descriptionWall = true
+		if match.signature == "" {
+			match.signature = "description: " + phrase
+		}
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Script:
+				if platform == "" {
+					if u, err := url.Parse(attrValue(n, "src")); err == nil && u.Host != "" {
+						for _, host := range sigs.ScriptHosts {
+							if matchesHost(u.Hostname(), []string{host}) {
+								platform = "script: " + host
+								break
+							}
+						}
+					}
+				}
+			case atom.Meta:
+				content := strings.TrimSpace(metaContent(n, maxMetaContent))
+				key := strings.ToLower(attrValue(n, "property") + attrValue(n, "name"))
+				switch key {
+				case "og:title", "twitter:title":
+					if match.socialTitle == "" && matchPhrase(content, sigs.Phrases) == "" {
+						match.socialTitle, match.titleSource = content, key
+					}
+				case "og:description", "twitter:description":
+					if match.socialDescriptor == "" && matchPhrase(content, sigs.Phrases) == "" {
+						match.socialDescriptor, match.descriptionSource = content, key
+					}
+				}
+			}
+			if platform == "" {
+				ids := strings.ToLower(attrValue(n, "id") + " " + attrValue(n, "class"))
+				for _, marker := range sigs.Markers {
+					if marker != "" && strings.Contains(ids, marker) {
+						platform = "element: " + marker
+						break
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	if match.signature == "" && platform != "" && lacksMetadata(metadata) {
+		match.signature = platform
+	}
+	if match.signature == "" {
+		return nil
+	}
+	return match
+}
+
+// matchPhrase returns the first of phrases found in s
+func matchPhrase(s string, phrases []string) string {
+	s = strings.ToLower(s)
+	for _, phrase := range phrases {
+		if phrase != "" && strings.Contains(s, phrase) {
+			return phrase
+		}
+	}
+	return ""
+}
+
+// applyConsentWall flags a detected wall and, when asked to, replaces a
+// title or description that belongs to the wall with the page's social one
+func applyConsentWall(metadata *MetadataResponse, match *consentMatch, skipTitles bool) {
+	metadata.ConsentWall = true
+	addWarning(metadata, warnConsentWall, fmt.Sprintf("page looks like a cookie-consent wall (%s)", match.signature))
+	if !skipTitles {
+		return
+	}
+	if match.titleWall && match.socialTitle != "" {
+		metadata.Title = match.socialTitle
+		setSource(metadata, "title", match.titleSource)
+	}
+	if match.descriptionWall && match.socialDescriptor != "" {
+		metadata.Description = match.socialDescriptor
+		setSource(metadata, "description", match.descriptionSource)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestDetectConsentWall(t *testing.T) {
+	const cmp = `<script src="https://cdn.cookielaw.org/scripttemplates/otSDKStub.js"></script>`
+	const banner = `<div id="onetrust-banner-sdk">We use cookies</div>`
+	tests := []struct {
+		name        string
+		title, desc string
+		images      []string
+		body        string
+		want        string
+	}{
+		{"banner on a full page", "News", "Today's news", []string{"https://example.com/a.jpg"}, cmp + banner, ""},
+		{"script on a page with a description", "News", "Today's news", nil, cmp, ""},
+		{"element on a page with images", "News", "", []string{"https://example.com/a.jpg"}, banner, ""},
+		{"script on a bare page", "News", "", nil, cmp, "script: cookielaw.org"},
+		{"element on a bare page", "", "", nil, banner, "element: onetrust"},
+		{"phrase in the title", "Before you continue to YouTube", "Videos", []string{"https://example.com/a.jpg"}, "", "title: before you continue"},
+		{"phrase in the description with a script", "News", "We value your privacy", []string{"https://example.com/a.jpg"}, cmp, "description: we value your privacy"},
+		{"nothing", "", "", nil, "<p>Hello</p>", ""},
+	}
+	for _, tt := range tests {
+		doc, err := html.Parse(strings.NewReader("<html><head></head><body>" + tt.body + "</body></html>"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		metadata := &MetadataResponse{Title: tt.title, Description: tt.desc, Images: tt.images}
+		match := detectConsentWall(doc, metadata, defaultConsentSignatures, defaultMaxMetaContentBytes)
+		got := ""
+		if match != nil {
+			got = match.signature
+		}
+		if got != tt.want {
+			t.Errorf("%s: signature %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
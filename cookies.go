@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// newCookieJar returns an empty jar for one extraction. The public suffix
+// list keeps a site from setting cookies for a whole TLD.
+func newCookieJar() http.CookieJar {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return jar
+}
+
+// cookieJarKey carries an extraction's cookie jar to fetchURL
+type cookieJarKey struct{}
+
+func withCookieJar(ctx context.Context, jar http.CookieJar) context.Context {
+	return context.WithValue(ctx, cookieJarKey{}, jar)
+}
+
+// cookieJarFromContext returns the jar stored by withCookieJar, or nil when
+// the fetch shouldn't keep cookies
+func cookieJarFromContext(ctx context.Context) http.CookieJar {
+	jar, _ := ctx.Value(cookieJarKey{}).(http.CookieJar)
+	return jar
+}
+
+// lacksMetadata reports whether a page looks like a placeholder served in
+// place of the real one: a title alone doesn't count, since cookie walls
+// usually have one
+func lacksMetadata(metadata *MetadataResponse) bool {
+	return metadata.Description == "" && len(metadata.Images) == 0
+}
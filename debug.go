@@ -0,0 +1,57 @@
+package main
+
+import "golang.org/x/net/html"
+
+// maxDebugMetaTags caps the meta tags listed in debug output
+const maxDebugMetaTags = 500
+
+// DebugInfo is attached to responses when the request has ?debug=1
+type DebugInfo struct {
+	DOMNodeCount int       `json:"dom_node_count,omitempty"`
+	DOMMaxDepth  int       `json:"dom_max_depth,omitempty"`
+	CacheTTLMs   int64     `json:"cache_ttl_ms"`
+	CacheHit     bool      `json:"cache_hit"`
+	Streamed     bool      `json:"streamed,omitempty"`
+	FetchMs      int64     `json:"fetch_ms,omitempty"` // Includes parsing when streamed
+	ParseMs      int64     `json:"parse_ms,omitempty"`
+	MetaTags     []MetaTag `json:"meta_tags,omitempty"`
+}
+
+// MetaTag is a <meta> element as the page declared it
+type MetaTag struct {
+	Name      string `json:"name,omitempty"`
+	Property  string `json:"property,omitempty"`
+	HTTPEquiv string `json:"http_equiv,omitempty"`
+	Itemprop  string `json:"itemprop,omitempty"`
+	Charset   string `json:"charset,omitempty"`
+	Content   string `json:"content,omitempty"`
+}
+
+// domStats is collected while walking the parsed document. Meta tags are
+// only kept when collectMeta is set, their content cut to maxMetaContent
+// bytes. The walk stops early, setting stopped, once done is closed.
+type domStats struct {
+	nodeCount      int
+	maxDepth       int
+	collectMeta    bool
+	maxMetaContent int
+	metaTags       []MetaTag
+	done           <-chan struct{}
+	stopped        bool
+}
+
+// addMetaTag records a <meta> element, if stats collects them
+func (stats *domStats) addMetaTag(n *html.Node) {
+	if !stats.collectMeta || len(stats.metaTags) >= maxDebugMetaTags {
+		return
+	}
+	content := metaContent(n, stats.maxMetaContent)
+	stats.metaTags = append(stats.metaTags, MetaTag{
+		Name:      attrValue(n, "name"),
+		Property:  attrValue(n, "property"),
+		HTTPEquiv: attrValue(n, "http-equiv"),
+		Itemprop:  attrValue(n, "itemprop"),
+		Charset:   attrValue(n, "charset"),
+		Content:   content,
+	})
+}
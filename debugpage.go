@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// debugPageTemplate renders GET /extract for browsers. It is self-contained,
+// with its styles inline, and html/template escapes everything the page
+// being debugged put in it.
+var debugPageTemplate = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>metadata.party debug</title>
+<style>
+body{font:14px/1.5 system-ui,sans-serif;margin:2em auto;max-width:72em;padding:0 1em;color:#1f2328}
+h1{font-size:1.4em}
+h2{font-size:1.2em;word-break:break-all;border-top:1px solid #d0d7de;padding-top:1em}
+h3{font-size:1em;margin-top:1.5em}
+table{border-collapse:collapse;width:100%}
+th,td{border:1px solid #d0d7de;padding:.3em .5em;text-align:left;vertical-align:top}
+th{background:#f6f8fa;white-space:nowrap}
+pre{margin:0;white-space:pre-wrap;word-break:break-all;font:12px/1.4 ui-monospace,monospace}
+.error{color:#cf222e}
+.muted{color:#656d76}
+</style>
+</head>
+<body>
+<h1>metadata.party debug</h1>
+<p class="muted">Request {{.RequestID}}. Send <code>Accept: application/json</code> for the JSON response.</p>
+{{range .Results}}
+<h2>{{.URL}}</h2>
+{{if .Error}}
+<p class="error">{{.Status}} {{.Code}}: {{.Error}}</p>
+{{else}}
+<h3>Timings</h3>
+<table>
+<tr><th>Total</th><td>{{.Metadata.Duration}} ms</td></tr>
+{{with .Metadata.Debug}}<tr><th>Fetch</th><td>{{.FetchMs}} ms{{if .Streamed}}, parsed while downloading{{end}}</td></tr>
+<tr><th>Parse</th><td>{{.ParseMs}} ms</td></tr>
+<tr><th>DOM</th><td>{{.DOMNodeCount}} nodes, {{.DOMMaxDepth}} deep</td></tr>{{end}}
+</table>
+<h3>Warnings</h3>
+{{if .Metadata.WarningDetails}}<ul>{{range .Metadata.WarningDetails}}<li><code>{{.Code}}</code> {{.Message}}</li>{{end}}</ul>{{else}}<p class="muted">None</p>{{end}}
+<h3>Fields</h3>
+<table>
+<tr><th>Field</th><th>Value</th><th>Source</th></tr>
+{{range .Fields}}<tr><th>{{.Name}}</th><td><pre>{{.Value}}</pre></td><td>{{.Source}}</td></tr>
+{{end}}</table>
+{{with .Metadata.RawMeta}}<h3>Title and description as declared</h3>
+<table>
+<tr><th>title</th><td><pre>{{.Title}}</pre></td></tr>
+<tr><th>description</th><td><pre>{{.Description}}</pre></td></tr>
+</table>{{end}}
+<h3>Meta tags</h3>
+{{if and .Metadata.Debug .Metadata.Debug.MetaTags}}<table>
+<tr><th>name</th><th>property</th><th>http-equiv</th><th>itemprop</th><th>charset</th><th>content</th></tr>
+{{range .Metadata.Debug.MetaTags}}<tr><td>{{.Name}}</td><td>{{.Property}}</td><td>{{.HTTPEquiv}}</td><td>{{.Itemprop}}</td><td>{{.Charset}}</td><td><pre>{{.Content}}</pre></td></tr>
+{{end}}</table>{{else}}<p class="muted">None</p>{{end}}
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// debugPage is what debugPageTemplate renders
+type debugPage struct {
+	RequestID string
+	Results   []debugPageResult
+}
+
+type debugPageResult struct {
+	URL      string
+	Status   int
+	Error    string
+	Code     string
+	Metadata *MetadataResponse
+	Fields   []debugPageField
+}
+
+// debugPageField is a field of the JSON response, with where it came from
+type debugPageField struct {
+	Name   string
+	Value  string
+	Source string
+}
+
+// prefersHTML reports whether an Accept header ranks text/html above JSON,
+// as browsers' do. Wildcards count for JSON, so clients that accept
+// anything, like curl, keep getting it.
+func prefersHTML(accept string) bool {
+	var htmlQ, jsonQ float64
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "text/html":
+			htmlQ = max(htmlQ, q)
+		case "application/json", "application/*", "*/*":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return htmlQ > jsonQ
+}
+
+// writeDebugPage renders extractions as the debug page, with the status
+// the JSON response would have had
+func writeDebugPage(w http.ResponseWriter, r *http.Request, urls []string, extractions []extraction, minSuccessRatio float64) {
+	page := debugPage{RequestID: requestID(r.Context())}
+	results := make([]MetadataResult, len(urls))
+	for i, res := range extractions {
+		results[i] = newMetadataResult(r, urls[i], res.metadata, res.err)
+		result := debugPageResult{
+			URL:    urls[i],
+			Status: results[i].Status,
+			Error:  results[i].Error,
+			Code:   results[i].Code,
+		}
+		if res.err == nil {
+			result.Metadata = res.metadata
+			result.Fields = debugPageFields(res.metadata)
+		}
+		page.Results = append(page.Results, result)
+	}
+
+	var body bytes.Buffer
+	if err := debugPageTemplate.Execute(&body, page); err != nil {
+		log.Printf("❌ Failed to render debug page: %v\n", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"error":      "failed to render debug page",
+			"code":       codeInternal,
+			"request_id": page.RequestID,
+		})
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(body.Len()))
+	// Nothing on the page is loaded from elsewhere or run
+	h.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(batchStatus(results, minSuccessRatio))
+	w.Write(body.Bytes())
+}
+
+// debugPageFields lists the fields of metadata's JSON by name, except those
+// the page shows on their own
+func debugPageFields(metadata *MetadataResponse) []debugPageField {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+	for _, name := range []string{"debug", "warnings", "warning_details", "sources", "raw_meta"} {
+		delete(fields, name)
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]debugPageField, 0, len(names))
+	for _, name := range names {
+		var value bytes.Buffer
+		json.Indent(&value, fields[name], "", "  ")
+		source := metadata.Sources[name]
+		if name == "images" {
+			source = metadata.Sources["image"]
+		}
+		list = append(list, debugPageField{Name: name, Value: value.String(), Source: source})
+	}
+	return list
+}
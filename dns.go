@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultDNSCacheTTL caps how long an answer is reused when DNS_CACHE_TTL
+	// is not set; answers with a shorter TTL expire sooner
+	defaultDNSCacheTTL = 60 * time.Second
+
+	// defaultDNSNegativeTTL is how long NXDOMAIN is remembered when
+	// DNS_NEGATIVE_TTL is not set
+	defaultDNSNegativeTTL = 10 * time.Second
+
+	// dnsTimeout bounds a single lookup, which may be shared by many callers
+	dnsTimeout = 10 * time.Second
+
+	// maxDNSCacheEntries bounds the cache; expired entries are swept when it
+	// fills up
+	maxDNSCacheEntries = 4096
+)
+
+// resolver looks up the addresses of a host. ttl is how long the answer may
+// be cached, or 0 when the resolver doesn't know.
+type resolver interface {
+	lookupIP(ctx context.Context, host string) (ips []net.IP, ttl time.Duration, err error)
+}
+
+// systemResolver uses the operating system's resolver, which hides TTLs
+type systemResolver struct{}
+
+func (systemResolver) lookupIP(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, 0, nil
+}
+
+func (systemResolver) lookupNS(ctx context.Context, domain string) ([]string, error) {
+	return nsHosts(net.DefaultResolver.LookupNS(ctx, domain))
+}
+
+// nsHosts lists the hosts of a nameserver lookup
+func nsHosts(records []*net.NS, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]string, len(records))
+	for i, ns := range records {
+		hosts[i] = ns.Host
+	}
+	return hosts, nil
+}
+
+// dnsCache is shared by SSRF validation and the transport's dialer so that
+// the addresses that were checked are the ones connected to
+var dnsCache = newDNSCache(systemResolver{})
+
+// resolverCache caches lookups from a resolver. Concurrent lookups of the
+// same host share one query.
+type resolverCache struct {
+	resolver resolver
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+
+	hits         atomic.Int64
+	misses       atomic.Int64
+	negativeHits atomic.Int64
+}
+
+type dnsEntry struct {
+	done    chan struct{}
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+func newDNSCache(r resolver) *resolverCache {
+	return &resolverCache{resolver: r, entries: make(map[string]*dnsEntry)}
+}
+
+// lookupIP returns the addresses of host, from the cache when possible.
+// Answers are kept for their TTL up to maxTTL, NXDOMAIN for negativeTTL, and
+// other failures not at all. A maxTTL of 0 disables caching.
+func (c *resolverCache) lookupIP(ctx context.Context, host string, maxTTL, negativeTTL time.Duration) ([]net.IP, error) {
+	if maxTTL <= 0 {
+		ips, _, err := c.resolver.lookupIP(ctx, host)
+		return ips, err
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	if ok && (entry.expires.IsZero() || time.Now().Before(entry.expires)) {
+		c.mu.Unlock()
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if entry.err != nil {
+			c.negativeHits.Add(1)
+		} else {
+			c.hits.Add(1)
+		}
+		return entry.ips, entry.err
+	}
+	c.misses.Add(1)
+	if len(c.entries) >= maxDNSCacheEntries {
+		c.sweep()
+	}
+	entry = &dnsEntry{done: make(chan struct{})}
+	c.entries[host] = entry
+	c.mu.Unlock()
+
+	// The query is shared, so it must not be cancelled by this caller leaving
+	go c.resolve(host, entry, maxTTL, negativeTTL)
+
+	select {
+	case <-entry.done:
+		return entry.ips, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *resolverCache) resolve(host string, entry *dnsEntry, maxTTL, negativeTTL time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+	ips, ttl, err := c.resolver.lookupIP(ctx, host)
+
+	var dnsErr *net.DNSError
+	c.mu.Lock()
+	entry.ips, entry.err = ips, err
+	switch {
+	case err == nil:
+		if ttl <= 0 || ttl > maxTTL {
+			ttl = maxTTL
+		}
+		entry.expires = time.Now().Add(ttl)
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound && negativeTTL > 0:
+		entry.expires = time.Now().Add(negativeTTL)
+	default:
+		delete(c.entries, host)
+	}
+	c.mu.Unlock()
+	close(entry.done)
+}
+
+// sweep drops expired entries. c.mu must be held.
+func (c *resolverCache) sweep() {
+	now := time.Now()
+	for host, entry := range c.entries {
+		if !entry.expires.IsZero() && now.After(entry.expires) {
+			delete(c.entries, host)
+		}
+	}
+}
+
+// DNSCacheStats reports how well the DNS cache is doing
+type DNSCacheStats struct {
+	Entries      int     `json:"entries"`
+	Hits         int64   `json:"hits"`
+	NegativeHits int64   `json:"negative_hits"`
+	Misses       int64   `json:"misses"`
+	HitRate      float64 `json:"hit_rate"`
+}
+
+func (c *resolverCache) stats() DNSCacheStats {
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+
+	s := DNSCacheStats{
+		Entries:      entries,
+		Hits:         c.hits.Load(),
+		NegativeHits: c.negativeHits.Load(),
+		Misses:       c.misses.Load(),
+	}
+	if total := s.Hits + s.NegativeHits + s.Misses; total > 0 {
+		s.HitRate = float64(s.Hits+s.NegativeHits) / float64(total)
+	}
+	return s
+}
+
+// resolveHost looks up host through the shared cache with cfg's TTLs
+func resolveHost(ctx context.Context, cfg *Config, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	return dnsCache.lookupIP(ctx, host, cfg.DNSCacheTTL, cfg.DNSNegativeTTL)
+}
+
+// checkIP returns an error when connecting to ip, an address of host, is
+// not allowed. Hosts in SSRF_ALLOW_HOSTS may be at private addresses, but
+// not in BLOCKED_CIDRS.
+func checkIP(cfg *Config, host string, ip net.IP) error {
+	if (isBlockedIP(ip) && !ssrfExempt(cfg, host)) || inCIDRs(ip, cfg.BlockedCIDRs) {
+		return codedErrorf(codeBlocked, "access to private/internal IP addresses is not allowed: %s", ip.String())
+	}
+	return nil
+}
+
+// dialer connects upstream requests to addresses from the shared DNS cache,
+// checking each one again so a record that changed since validation can't
+// point a connection at a blocked address
+var dialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+func dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	cfg := configFromContext(ctx)
+	ips, err := resolveHost(ctx, cfg, host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ip := range ips {
+		if err := checkIP(cfg, host, ip); err != nil {
+			return nil, err
+		}
+	}
+
+	var firstErr error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("no addresses found for %s", host)
+	}
+	return nil, firstErr
+}
+
+// configKey carries the request's configuration to the dialer
+type configKey struct{}
+
+func withConfig(ctx context.Context, cfg *Config) context.Context {
+	return context.WithValue(ctx, configKey{}, cfg)
+}
+
+// configFromContext returns the configuration stored by withConfig, or the
+// defaults for dials that didn't come through fetchURL
+func configFromContext(ctx context.Context) *Config {
+	if cfg, ok := ctx.Value(configKey{}).(*Config); ok {
+		return cfg
+	}
+	return defaultConfig()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// EffectiveConfig is what an extraction ran with once the request's options
+// and the server's configuration were merged, returned with ?echo_config=1
+// so that results can be reproduced and compared across deployments
+type EffectiveConfig struct {
+	TimeoutMs           int64             `json:"timeout_ms"`
+	BodyReadTimeoutMs   int64             `json:"body_read_timeout_ms"`
+	UserAgent           string            `json:"user_agent"`
+	Headers             map[string]string `json:"headers,omitempty"`
+	MaxRedirects        int               `json:"max_redirects"`
+	MaxPageBytes        int64             `json:"max_page_bytes"`
+	HTMLStreamThreshold int64             `json:"html_stream_threshold"`
+	AllowedContentTypes []string          `json:"allowed_content_types"`
+	AssetURLSchemes     []string          `json:"asset_url_schemes"`
+	DefaultCharset      string            `json:"default_charset"`
+	DetectCharset       bool              `json:"detect_charset"`
+	CacheTTLMs          int64             `json:"cache_ttl_ms"`
+	MaxImages           int               `json:"max_images"`
+	Mode                string            `json:"mode"`
+	Enabled             []string          `json:"enabled"` // The request's boolean options that are on
+}
+
+// effectiveConfig describes how metadata was extracted with cfg and opts.
+// The User-Agent is the one the result was fetched with.
+func effectiveConfig(cfg *Config, opts ExtractOptions, metadata *MetadataResponse) *EffectiveConfig {
+	timeout := fetchTimeout
+	if opts.TimeoutMs != nil && *opts.TimeoutMs < fetchTimeout.Milliseconds() {
+		timeout = time.Duration(*opts.TimeoutMs) * time.Millisecond
+	}
+	maxImages := opts.MaxImages
+	if maxImages <= 0 {
+		maxImages = defaultMaxImages
+	}
+	mode := opts.Mode
+	if mode == "" {
+		mode = modeFull
+	}
+	if opts.FaviconFallback == nil {
+		opts.FaviconFallback = new(bool)
+		*opts.FaviconFallback = true
+	}
+
+	return &EffectiveConfig{
+		TimeoutMs:           timeout.Milliseconds(),
+		BodyReadTimeoutMs:   cfg.BodyReadTimeout.Milliseconds(),
+		UserAgent:           metadata.UserAgent,
+		Headers:             opts.Headers,
+		MaxRedirects:        maxRedirects,
+		MaxPageBytes:        maxPageBytes,
+		HTMLStreamThreshold: cfg.HTMLStreamThreshold,
+		AllowedContentTypes: cfg.AllowedContentTypes,
+		AssetURLSchemes:     cfg.AssetURLSchemes,
+		DefaultCharset:      cfg.DefaultCharset,
+		DetectCharset:       cfg.DetectCharset,
+		CacheTTLMs:          metadataCacheTTL(cfg, opts).Milliseconds(),
+		MaxImages:           min(maxImages, maxImagesLimit),
+		Mode:                mode,
+		Enabled:             enabledOptions(opts),
+	}
+}
+
+// enabledOptions lists the JSON names of the boolean options that are on
+func enabledOptions(opts ExtractOptions) []string {
+	enabled := []string{}
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return enabled
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return enabled
+	}
+	for name, value := range fields {
+		if value == true {
+			enabled = append(enabled, name)
+		}
+	}
+	sort.Strings(enabled)
+	return enabled
+}
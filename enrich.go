@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// enrichmentBudget bounds the enrichment stage, within what is left of
+	// the extraction's own deadline
+	enrichmentBudget = 3 * time.Second
+
+	// maxEnrichmentFetches is how many enrichment sub-fetches run at once
+	maxEnrichmentFetches = 3
+)
+
+// enrichment is one sub-fetch of the enrichment stage. fetch runs alongside
+// the others, so it may only use what was copied into it when the stage
+// started; the function it returns records the result on the metadata once
+// every sub-fetch is done.
+type enrichment struct {
+	name  string
+	fetch func(ctx context.Context) func(*MetadataResponse)
+
+	// timeoutCode is the warning code used when fetch runs out of time,
+	// instead of enrichment_timeout
+	timeoutCode string
+}
+
+// enrichmentsFor lists the sub-fetches opts ask for that metadata has
+// something to fetch for
+func enrichmentsFor(cfg *Config, metadata *MetadataResponse, opts ExtractOptions) []enrichment {
+	var enrichments []enrichment
+
+	if opts.VerifyImages && len(metadata.ImageDetails) > 0 {
+		// Verified on a copy, which replaces the images once applied
+		images := &MetadataResponse{ImageDetails: append([]ImageInfo(nil), metadata.ImageDetails...)}
+		enrichments = append(enrichments, enrichment{name: "verify_images", fetch: func(ctx context.Context) func(*MetadataResponse) {
+			verifyImages(ctx, cfg, images, opts.MaxImages)
+			return func(metadata *MetadataResponse) {
+				metadata.ImageDetails, metadata.Images = images.ImageDetails, images.Images
+			}
+		}})
+	}
+
+	// Both color options read the primary image, which is downloaded once
+	if (opts.ExtractColors || opts.ProbeImageColor) && len(metadata.Images) > 0 {
+		imageURL := metadata.Images[0]
+		enrichments = append(enrichments, enrichment{name: "colors", fetch: func(ctx context.Context) func(*MetadataResponse) {
+			result := fetchColors(ctx, cfg, imageURL)
+			return func(metadata *MetadataResponse) {
+				if opts.ProbeImageColor {
+					applyColorProbe(metadata, result)
+				}
+				if opts.ExtractColors {
+					applyColors(metadata, result)
+				}
+			}
+		}})
+	}
+
+	if opts.FetchOEmbed && metadata.OEmbedURL != "" {
+		endpoint, format := metadata.OEmbedURL, metadata.OEmbedFormat
+		enrichments = append(enrichments, enrichment{name: "fetch_oembed", fetch: func(ctx context.Context) func(*MetadataResponse) {
+			oembed, err := fetchOEmbed(ctx, cfg, endpoint, format)
+			return func(metadata *MetadataResponse) { applyOEmbed(metadata, oembedResult{oembed: oembed, err: err}) }
+		}})
+	}
+
+	if opts.ProbeFavicon {
+		if iconURL := faviconProbeURL(metadata.Favicon); iconURL != nil {
+			enrichments = append(enrichments, enrichment{name: "probe_favicon", fetch: func(ctx context.Context) func(*MetadataResponse) {
+				sizes, err := fetchICOSizes(ctx, cfg, iconURL)
+				return func(metadata *MetadataResponse) {
+					applyFaviconProbe(metadata, faviconProbeResult{sizes: sizes, err: err})
+				}
+			}})
+		}
+	}
+
+	if opts.InlineFavicon && metadata.Favicon != "" {
+		iconURL := metadata.Favicon
+		enrichments = append(enrichments, enrichment{name: "inline_favicon", timeoutCode: warnFaviconNotInlined, fetch: func(ctx context.Context) func(*MetadataResponse) {
+			data, err := fetchFaviconData(ctx, cfg, iconURL)
+			return func(metadata *MetadataResponse) {
+				if err != nil {
+					addWarning(metadata, warnFaviconNotInlined, fmt.Sprintf("favicon not inlined: %v", err))
+					return
+				}
+				metadata.FaviconData = data
+			}
+		}})
+	}
+
+	return enrichments
+}
+
+// enrich runs the enrichment sub-fetches opts ask for, at most
+// maxEnrichmentFetches at a time and all within enrichmentBudget. Results
+// are applied in the order the sub-fetches are listed; those that didn't
+// finish in time are left out with a warning. Without any sub-fetch to
+// run, nothing is started.
+func enrich(ctx context.Context, cfg *Config, metadata *MetadataResponse, opts ExtractOptions) {
+	enrichments := enrichmentsFor(cfg, metadata, opts)
+	if len(enrichments) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, enrichmentBudget)
+	defer cancel()
+
+	applies := make([]func(*MetadataResponse), len(enrichments))
+	slots := make(chan struct{}, maxEnrichmentFetches)
+	var wg sync.WaitGroup
+	for i, e := range enrichments {
+		wg.Add(1)
+		go func(i int, e enrichment) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				return
+			}
+			apply := e.fetch(ctx)
+			// A sub-fetch that ran out of time only has a timeout to report
+			if ctx.Err() == nil {
+				applies[i] = apply
+			}
+		}(i, e)
+	}
+	wg.Wait()
+
+	for i, apply := range applies {
+		if apply == nil {
+			code := enrichments[i].timeoutCode
+			if code == "" {
+				code = warnEnrichmentTimeout
+			}
+			addWarning(metadata, code, fmt.Sprintf("%s did not finish within the enrichment budget", enrichments[i].name))
+			continue
+		}
+		apply(metadata)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// Error codes returned with failed extractions, so that clients can tell a
+// bad URL from a site that is down from a target we refuse to fetch
+const (
+	codeInvalidURL     = "invalid_url"
+	codeBlocked        = "blocked"
+	codeDNSFailure     = "dns_failure"
+	codeConnectTimeout = "connect_timeout"
+	codeConnectFailure = "connect_failure"
+	codeUpstream4xx    = "upstream_4xx"
+	codeUpstream5xx    = "upstream_5xx"
+	codeTooLarge       = "too_large"
+	codeParseFailure   = "parse_failure"
+	codeTimeout        = "timeout"
+	codeSlowBody       = "slow_body"
+	codeCircuitOpen    = "circuit_open"
+	codeOverloaded     = "overloaded"
+	codeInternal       = "internal"
+
+	// Codes for requests refused before any extraction, only returned by
+	// /v2 endpoints
+	codeInvalidRequest   = "invalid_request"
+	codeMethodNotAllowed = "method_not_allowed"
+)
+
+// errorStatuses maps error codes to the HTTP status of a single-URL
+// response: 400 for client mistakes, 422 for targets that can't be
+// extracted, 502 and 503 for upstream failures, 504 for timeouts and 500 for
+// our own bugs
+var errorStatuses = map[string]int{
+	codeInvalidURL:     http.StatusBadRequest,
+	codeBlocked:        http.StatusUnprocessableEntity,
+	codeDNSFailure:     http.StatusUnprocessableEntity,
+	codeTooLarge:       http.StatusUnprocessableEntity,
+	codeParseFailure:   http.StatusUnprocessableEntity,
+	codeConnectFailure: http.StatusBadGateway,
+	codeUpstream4xx:    http.StatusBadGateway,
+	codeUpstream5xx:    http.StatusBadGateway,
+	codeCircuitOpen:    http.StatusServiceUnavailable,
+	codeOverloaded:     http.StatusServiceUnavailable,
+	codeConnectTimeout: http.StatusGatewayTimeout,
+	codeTimeout:        http.StatusGatewayTimeout,
+	codeSlowBody:       http.StatusGatewayTimeout,
+	codeInternal:       http.StatusInternalServerError,
+
+	codeInvalidRequest:   http.StatusBadRequest,
+	codeMethodNotAllowed: http.StatusMethodNotAllowed,
+}
+
+// extractError is an error with one of the codes above
+type extractError struct {
+	code string
+	err  error
+}
+
+func (e *extractError) Error() string { return e.err.Error() }
+
+func (e *extractError) Unwrap() error { return e.err }
+
+// codedErrorf formats an error carrying code
+func codedErrorf(code, format string, args ...interface{}) error {
+	return &extractError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// errorCode returns the code of err, working it out from the error chain
+// when it wasn't given one
+func errorCode(err error) string {
+	var coded *extractError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	var circuitErr *circuitOpenError
+	if errors.As(err, &circuitErr) {
+		return codeCircuitOpen
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return codeDNSFailure
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		if opErr.Timeout() {
+			return codeConnectTimeout
+		}
+		return codeConnectFailure
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return codeTimeout
+	}
+	return codeInternal
+}
+
+// fetchError describes a failed upstream request. Errors that reached the
+// network but fit no other code are upstream connection failures.
+func fetchError(msg string, err error) error {
+	code := errorCode(err)
+	if code == codeInternal && !errors.Is(err, context.Canceled) {
+		code = codeConnectFailure
+	}
+	return &extractError{code: code, err: fmt.Errorf("%s: %w", msg, err)}
+}
+
+// batchStatus is the HTTP status of a batch response: 200 when at least
+// minSuccessRatio of the URLs, and at least one, succeeded, otherwise the
+// most common status among the failures. Of tied statuses, the one that
+// reached the count first wins.
+func batchStatus(results []MetadataResult, minSuccessRatio float64) int {
+	counts := make(map[int]int)
+	dominant := 0
+	succeeded := 0
+	for _, res := range results {
+		if res.Status == http.StatusOK {
+			succeeded++
+			continue
+		}
+		counts[res.Status]++
+		if dominant == 0 || counts[res.Status] > counts[dominant] {
+			dominant = res.Status
+		}
+	}
+	if dominant == 0 || (succeeded > 0 && float64(succeeded) >= minSuccessRatio*float64(len(results))) {
+		return http.StatusOK
+	}
+	return dominant
+}
+
+// writeExtractError responds to a failed single-URL extraction
+func writeExtractError(w http.ResponseWriter, r *http.Request, err error) {
+	code := errorCode(err)
+	setRetryAfter(w, err)
+	writeError(w, r, code, err.Error())
+}
+
+// setRetryAfter tells the client when to retry a URL whose circuit is open
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var circuitErr *circuitOpenError
+	if errors.As(err, &circuitErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(circuitErr.retryAfter.Seconds()))))
+	}
+}
+
+// writeError responds with an error in the shape of the API version r was
+// sent to
+func writeError(w http.ResponseWriter, r *http.Request, code, message string) {
+	if isV2Request(r) {
+		writeJSON(w, errorStatuses[code], newResponseV2(r, nil, []ErrorV2{{Code: code, Message: message}}, nil))
+		return
+	}
+	writeJSON(w, errorStatuses[code], map[string]string{
+		"error":      message,
+		"code":       code,
+		"request_id": requestID(r.Context()),
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatchStatus(t *testing.T) {
+	ok := MetadataResult{Status: http.StatusOK}
+	bad := MetadataResult{Status: http.StatusBadRequest, Code: codeInvalidURL}
+	gateway := MetadataResult{Status: http.StatusBadGateway, Code: codeUpstream4xx}
+	tests := []struct {
+		name     string
+		results  []MetadataResult
+		minRatio float64
+		want     int
+	}{
+		{"all succeeded", []MetadataResult{ok, ok}, 0, http.StatusOK},
+		{"all succeeded, ratio 1", []MetadataResult{ok, ok}, 1, http.StatusOK},
+		{"all failed", []MetadataResult{gateway, gateway}, 0, http.StatusBadGateway},
+		{"all failed, most common status", []MetadataResult{bad, gateway, gateway}, 0, http.StatusBadGateway},
+		{"all failed, tie goes to the first", []MetadataResult{bad, gateway, gateway, bad}, 0, http.StatusBadGateway},
+		{"mixed", []MetadataResult{ok, gateway, gateway}, 0, http.StatusOK},
+		{"mixed, ratio met", []MetadataResult{ok, gateway}, 0.5, http.StatusOK},
+		{"mixed, ratio missed", []MetadataResult{ok, gateway, bad}, 0.5, http.StatusBadGateway},
+		{"mixed, ratio 1", []MetadataResult{ok, ok, ok, bad}, 1, http.StatusBadRequest},
+		{"all failed, ratio 0", []MetadataResult{bad}, 0, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if got := batchStatus(tt.results, tt.minRatio); got != tt.want {
+			t.Errorf("%s: batchStatus = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExtractBatchResults(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/missing") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>Page</title></head></html>"))
+	}))
+	defer origin.Close()
+	cfg := testConfig()
+	cfg.BreakerFailures = 0
+
+	tests := []struct {
+		name     string
+		paths    []string
+		minRatio float64
+		status   int
+		// statuses of the results, in order
+		statuses          []int
+		succeeded, failed int
+	}{
+		{"all succeeded", []string{"/a", "/b"}, 0, http.StatusOK, []int{200, 200}, 2, 0},
+		{"all failed", []string{"/missing1", "/missing2"}, 0, http.StatusBadGateway, []int{502, 502}, 0, 2},
+		{"all failed, mixed errors", []string{"ftp:", "/missing3", "ftp:"}, 0, http.StatusBadRequest, []int{400, 502, 400}, 0, 3},
+		{"mixed", []string{"/c", "/missing4", "/missing5"}, 0, http.StatusOK, []int{200, 502, 502}, 1, 2},
+		{"mixed, min_success_ratio met", []string{"/d", "/e", "/missing6"}, 0.6, http.StatusOK, []int{200, 200, 502}, 2, 1},
+		{"mixed, min_success_ratio missed", []string{"/f", "/missing7", "/missing8"}, 0.5, http.StatusBadGateway, []int{200, 502, 502}, 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var urls []string
+			for _, path := range tt.paths {
+				if path == "ftp:" {
+					urls = append(urls, "ftp://example.com/file")
+				} else {
+					urls = append(urls, origin.URL+path)
+				}
+			}
+			body, _ := json.Marshal(map[string]any{"urls": urls, "min_success_ratio": tt.minRatio})
+
+			rec := httptest.NewRecorder()
+			serveExtract(cfg, rec, httptest.NewRequest(http.MethodPost, "/extract", strings.NewReader(string(body))))
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d", rec.Code, tt.status)
+			}
+			var batch BatchMetadataResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &batch); err != nil {
+				t.Fatal(err)
+			}
+			if batch.Total != len(urls) || batch.Succeeded != tt.succeeded || batch.Failed != tt.failed {
+				t.Errorf("total %d, succeeded %d, failed %d; want %d, %d, %d",
+					batch.Total, batch.Succeeded, batch.Failed, len(urls), tt.succeeded, tt.failed)
+			}
+			for i, res := range batch.Results {
+				if res.Status != tt.statuses[i] {
+					t.Errorf("result %d: status %d, want %d", i, res.Status, tt.statuses[i])
+				}
+				if (res.Status == http.StatusOK) != (res.Code == "" && res.Error == "") {
+					t.Errorf("result %d: status %d with code %q and error %q", i, res.Status, res.Code, res.Error)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// maxFaviconURLs caps the number of sites in one /favicons request
+	maxFaviconURLs = 1000
+
+	// faviconWorkers is the number of sites looked up concurrently for one request
+	faviconWorkers = 10
+
+	// faviconTimeout bounds the work for a single site
+	faviconTimeout = 10 * time.Second
+
+	// maxFaviconHeadBytes is how much of a page is read looking for <link> tags
+	maxFaviconHeadBytes = 256 * 1024
+
+	// maxInlineFaviconBytes caps the icon embedded by inline_favicon
+	maxInlineFaviconBytes = 64 * 1024
+
+	// inlineFaviconTimeout bounds inline_favicon
+	inlineFaviconTimeout = 5 * time.Second
+)
+
+// faviconTypes maps icon file extensions to media types, for links that
+// don't declare one
+var faviconTypes = map[string]string{
+	".ico":  "image/x-icon",
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".gif":  "image/gif",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".webp": "image/webp",
+}
+
+// IconLink is an icon a page declares with <link rel="icon"> or one of its
+// variants. Media is set for icons meant for one color scheme, e.g.
+// "(prefers-color-scheme: dark)".
+type IconLink struct {
+	URL   string `json:"url"`
+	Rel   string `json:"rel"`
+	Type  string `json:"type,omitempty"`
+	Sizes string `json:"sizes,omitempty"`
+	Media string `json:"media,omitempty"`
+}
+
+// isDarkMedia reports whether an icon's media query targets dark themes only
+func isDarkMedia(media string) bool {
+	media = strings.Join(strings.Fields(strings.ToLower(media)), "")
+	return strings.Contains(media, "prefers-color-scheme:dark")
+}
+
+// FaviconResult is one site's entry in a /favicons response
+type FaviconResult struct {
+	URL         string `json:"url"`
+	Favicon     string `json:"favicon"`
+	FaviconType string `json:"favicon_type"`
+	Error       string `json:"error,omitempty"`
+}
+
+type faviconRequest struct {
+	URLs []string `json:"urls"`
+}
+
+// faviconsHandler finds the best favicon for each of a list of sites,
+// reading no more of each page than its <head>
+func faviconsHandler(store *configStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveFavicons(store.Load(), w, r)
+	}
+}
+
+func serveFavicons(cfg *Config, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed. Use POST."})
+		return
+	}
+
+	var req faviconRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
+		return
+	}
+	if len(req.URLs) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "At least one URL or domain is required in 'urls'"})
+		return
+	}
+	if len(req.URLs) > maxFaviconURLs {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Maximum %d URLs allowed per request", maxFaviconURLs)})
+		return
+	}
+
+	// The server's WriteTimeout is shorter than a long list takes, so keep
+	// pushing the deadline out as sites finish
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(faviconTimeout + responseWriteMargin))
+
+	results := make([]FaviconResult, len(req.URLs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var deadlineMu sync.Mutex
+	for i := 0; i < faviconWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				results[idx] = findFavicon(r.Context(), cfg, req.URLs[idx])
+				deadlineMu.Lock()
+				rc.SetWriteDeadline(time.Now().Add(faviconTimeout + responseWriteMargin))
+				deadlineMu.Unlock()
+			}
+		}()
+	}
+	for i := range req.URLs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"results": results,
+		"total":   len(results),
+	})
+}
+
+// findFavicon fetches the start of a site's page and picks its best icon,
+// falling back to /favicon.ico
+func findFavicon(ctx context.Context, cfg *Config, site string) FaviconResult {
+	defer service.trackContext(ctx)()
+
+	result := FaviconResult{URL: site}
+	if !strings.Contains(site, "://") {
+		site = "https://" + site
+	}
+	parsedURL, err := url.Parse(site)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		result.Error = "invalid URL"
+		return result
+	}
+	if err := validateURLForSSRF(cfg, parsedURL); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, faviconTimeout)
+	defer cancel()
+
+	resp, release, err := fetchURL(ctx, cfg, parsedURL, http.Header{
+		"Accept": {"text/html,application/xhtml+xml;q=0.9,*/*;q=0.8"},
+	})
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to fetch URL: %v", err)
+		return result
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		result.Error = fmt.Sprintf("HTTP error: %d", resp.StatusCode)
+		return result
+	}
+
+	// Relative hrefs are relative to where the redirects ended up
+	baseURL := resp.Request.URL
+	href, linkType := bestIconLink(io.LimitReader(resp.Body, maxFaviconHeadBytes))
+	if href == "" {
+		href = "/favicon.ico"
+	}
+	result.Favicon = resolveURL(href, baseURL)
+	result.FaviconType = linkType
+	if result.FaviconType == "" {
+		if u, err := url.Parse(result.Favicon); err == nil {
+			result.FaviconType = faviconTypes[strings.ToLower(path.Ext(u.Path))]
+		}
+	}
+	return result
+}
+
+// bestIconLink scans <link> tags up to the end of <head> and returns the href
+// and declared type of the largest icon. Icons for dark themes are only
+// picked when there is nothing else.
+func bestIconLink(r io.Reader) (string, string) {
+	var bestHref, bestType string
+	bestScore := -1
+	var darkHref, darkType string
+	darkScore := -1
+	best := func() (string, string) {
+		if bestHref == "" {
+			return darkHref, darkType
+		}
+		return bestHref, bestType
+	}
+
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return best()
+		case html.EndTagToken:
+			if name, _ := z.TagName(); atom.Lookup(name) == atom.Head {
+				return best()
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			switch token.DataAtom {
+			case atom.Body:
+				return best()
+			case atom.Link:
+				var rel, href, linkType, sizes, media string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "rel":
+						rel = strings.ToLower(attr.Val)
+					case "href":
+						href = strings.TrimSpace(attr.Val)
+					case "type":
+						linkType = attr.Val
+					case "sizes":
+						sizes = strings.ToLower(attr.Val)
+					case "media":
+						media = attr.Val
+					}
+				}
+				score := iconScore(rel, sizes)
+				switch {
+				case href == "":
+				case isDarkMedia(media):
+					if score > darkScore {
+						darkHref, darkType, darkScore = href, linkType, score
+					}
+				case score > bestScore:
+					bestHref, bestType, bestScore = href, linkType, score
+				}
+			}
+		}
+	}
+}
+
+// iconScore ranks an icon link by its size, or -1 when it isn't a favicon.
+// Icons without sizes are assumed to be the usual size for their kind.
+func iconScore(rel, sizes string) int {
+	rels := strings.Fields(rel)
+	var icon, touch bool
+	for _, r := range rels {
+		switch r {
+		case "icon":
+			icon = true
+		case "apple-touch-icon", "apple-touch-icon-precomposed":
+			touch = true
+		}
+	}
+	if !icon && !touch {
+		return -1
+	}
+
+	best := 0
+	for _, size := range strings.Fields(sizes) {
+		if size == "any" {
+			// Scalable icons are as good as it gets
+			return 1 << 16
+		}
+		if w, _, ok := strings.Cut(size, "x"); ok {
+			if n, err := strconv.Atoi(w); err == nil && n > best {
+				best = n
+			}
+		}
+	}
+	if best > 0 {
+		return best
+	}
+	if touch {
+		return 180
+	}
+	return 16
+}
+
+// inlineFaviconTypes are the icon formats inline_favicon embeds. SVG is left
+// out since it can carry scripts.
+var inlineFaviconTypes = map[string]bool{
+	"image/x-icon":             true,
+	"image/vnd.microsoft.icon": true,
+	"image/png":                true,
+	"image/gif":                true,
+	"image/jpeg":               true,
+	"image/webp":               true,
+}
+
+// inlineFavicon downloads metadata.Favicon and sets FaviconData to it as a
+// data: URI. Icons that fail, are too large or aren't a raster image only
+// leave a warning.
+func inlineFavicon(ctx context.Context, cfg *Config, metadata *MetadataResponse) {
+	if metadata.Favicon == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, inlineFaviconTimeout)
+	defer cancel()
+
+	data, err := fetchFaviconData(ctx, cfg, metadata.Favicon)
+	if err != nil {
+		addWarning(metadata, warnFaviconNotInlined, fmt.Sprintf("favicon not inlined: %v", err))
+		return
+	}
+	metadata.FaviconData = data
+}
+
+// fetchFaviconData downloads an icon of at most maxInlineFaviconBytes,
+// validating it against SSRF rules first, and encodes it as a data: URI
+func fetchFaviconData(ctx context.Context, cfg *Config, iconURL string) (string, error) {
+	parsedURL, err := url.Parse(iconURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return "", fmt.Errorf("invalid favicon URL")
+	}
+	if err := validateURLForSSRF(cfg, parsedURL); err != nil {
+		return "", err
+	}
+
+	resp, release, err := fetchURL(ctx, cfg, parsedURL, http.Header{"Accept": {"image/*"}})
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP error: %d", resp.StatusCode)
+	}
+	if resp.ContentLength > maxInlineFaviconBytes {
+		return "", fmt.Errorf("icon too large: %d bytes", resp.ContentLength)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxInlineFaviconBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read icon: %v", err)
+	}
+	if len(body) > maxInlineFaviconBytes {
+		return "", fmt.Errorf("icon too large: over %d bytes", maxInlineFaviconBytes)
+	}
+
+	// Servers often send icons as application/octet-stream, so the bytes
+	// decide when the header doesn't name an image
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "image/") {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+	if !inlineFaviconTypes[mediaType] {
+		return "", fmt.Errorf("unsupported icon type: %s", mediaType)
+	}
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(body), nil
+}
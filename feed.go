@@ -0,0 +1,873 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/transform"
+)
+
+// feedContentTypes are the media types handled by the feed extractor
+var feedContentTypes = []string{
+	"application/xml",
+	"text/xml",
+	"application/rss+xml",
+	"application/atom+xml",
+}
+
+// parseContentTypes splits a comma-separated list of media types
+func parseContentTypes(list string) []string {
+	var types []string
+	for _, t := range strings.Split(list, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// responseMediaType returns the lowercased media type of resp without parameters
+func responseMediaType(resp *http.Response) string {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(mediaType)
+}
+
+// isAllowedContentType reports whether mediaType is one of the configured
+// content types. A missing Content-Type is treated as HTML.
+func isAllowedContentType(cfg *Config, mediaType string) bool {
+	if mediaType == "" {
+		return true
+	}
+	return contains(cfg.AllowedContentTypes, mediaType)
+}
+
+func isFeedContentType(mediaType string) bool {
+	return contains(feedContentTypes, mediaType)
+}
+
+// extractFromFeed pulls the channel title and description from an RSS or
+// Atom document. Only the first title and description/subtitle are used, which
+// for well-formed feeds belong to the channel rather than an item.
+func extractFromFeed(body []byte, metadata *MetadataResponse) error {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+	// Declared encodings are read as-is; ASCII-compatible feeds still parse
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	for metadata.Title == "" || metadata.Description == "" {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// Keep whatever was found before the document went bad
+			if metadata.Title != "" {
+				return nil
+			}
+			return err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		var text string
+		switch start.Name.Local {
+		case "title":
+			if metadata.Title != "" {
+				continue
+			}
+			if err := decoder.DecodeElement(&text, &start); err != nil {
+				return err
+			}
+			metadata.Title = strings.TrimSpace(text)
+			noteSource(metadata, "title", "feed")
+		case "description", "subtitle":
+			if metadata.Description != "" {
+				continue
+			}
+			if err := decoder.DecodeElement(&text, &start); err != nil {
+				return err
+			}
+			metadata.Description = strings.TrimSpace(text)
+			noteSource(metadata, "description", "feed")
+		}
+	}
+
+	return nil
+}
+
+const (
+	// maxFeedBytes is the largest feed /extract/feed reads
+	maxFeedBytes = 5 * 1024 * 1024
+
+	// defaultFeedItems and maxFeedItems bound the limit parameter
+	defaultFeedItems = 10
+	maxFeedItems     = 100
+
+	// maxFeedSummaryBytes is how much of an item's summary is returned
+	maxFeedSummaryBytes = 1000
+
+	// maxFeedDepth and maxFeedNodes bound the tree a feed is read into.
+	// Elements nested deeper count as text of the deepest one kept, and a
+	// feed with more elements is read as if it ended there.
+	maxFeedDepth = 64
+	maxFeedNodes = 100000
+)
+
+// Values of Feed.Type
+const (
+	feedRSS  = "rss"
+	feedAtom = "atom"
+	feedJSON = "json"
+)
+
+// feedAccept asks for any kind of feed, since servers that negotiate tend
+// to only know one
+const feedAccept = "application/rss+xml, application/atom+xml, application/feed+json, " +
+	"application/xml;q=0.9, text/xml;q=0.9, application/json;q=0.9, */*;q=0.1"
+
+// Feed is a feed's channel and its first items, as /extract/feed returns
+// them whatever the format. Links are absolute, dates RFC 3339 and text is
+// plain.
+type Feed struct {
+	URL         string     `json:"url"`
+	Type        string     `json:"type"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Link        string     `json:"link,omitempty"`
+	Image       string     `json:"image,omitempty"`
+	Language    string     `json:"language,omitempty"`
+	Updated     string     `json:"updated,omitempty"`
+	Items       []FeedItem `json:"items"`
+	TotalItems  int        `json:"total_items"`
+	Duration    int64      `json:"duration"`
+}
+
+// FeedItem is one entry of a feed. ID is the item's GUID, or its link or a
+// digest of its content when it has none.
+type FeedItem struct {
+	ID        string         `json:"id"`
+	Title     string         `json:"title"`
+	Link      string         `json:"link,omitempty"`
+	Published string         `json:"published,omitempty"`
+	Summary   string         `json:"summary,omitempty"`
+	Image     string         `json:"image,omitempty"`
+	Enclosure *FeedEnclosure `json:"enclosure,omitempty"`
+}
+
+// FeedEnclosure is the file attached to an item, such as a podcast episode
+type FeedEnclosure struct {
+	URL    string `json:"url"`
+	Type   string `json:"type,omitempty"`
+	Length int64  `json:"length,omitempty"`
+}
+
+func feedHandler(store *configStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveFeed(store.Load(), w, r)
+	}
+}
+
+func serveFeed(cfg *Config, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, codeMethodNotAllowed, "Method not allowed. Use GET.")
+		return
+	}
+
+	query := r.URL.Query()
+	feedURL := strings.TrimSpace(query.Get("url"))
+	if feedURL == "" {
+		writeError(w, r, codeInvalidRequest, "'url' parameter is required")
+		return
+	}
+	limit := defaultFeedItems
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxFeedItems {
+			writeError(w, r, codeInvalidRequest, fmt.Sprintf("'limit' must be between 1 and %d", maxFeedItems))
+			return
+		}
+		limit = n
+	}
+
+	feed, err := extractFeed(r.Context(), cfg, feedURL, limit)
+	if err != nil {
+		writeExtractError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, feed)
+}
+
+// extractFeed fetches the RSS, Atom or JSON feed at feedURL, with the same
+// SSRF checks, host limits and deadline as a page, and returns its channel
+// and first limit items
+func extractFeed(ctx context.Context, cfg *Config, feedURL string, limit int) (*Feed, error) {
+	startTime := time.Now()
+	defer service.trackContext(ctx)()
+
+	parsedURL, err := url.Parse(feedURL)
+	if err != nil {
+		return nil, codedErrorf(codeInvalidURL, "invalid URL: %v", err)
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return nil, codedErrorf(codeInvalidURL, "invalid URL scheme: only http and https are supported")
+	}
+	if err := validateURLForSSRF(cfg, parsedURL); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	log.Printf("📰 Fetching feed %s\n", feedURL)
+	data, resp, err := fetchFeed(ctx, cfg, parsedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Relative links are resolved against where the feed ended up
+	baseURL := resp.Request.URL
+	var feed *Feed
+	switch feedFormat(data, responseMediaType(resp)) {
+	case feedJSON:
+		feed, err = parseJSONFeed(data, baseURL, limit)
+	case feedRSS:
+		feed, err = parseXMLFeed(data, resp.Header.Get("Content-Type"), baseURL, limit)
+	default:
+		err = errors.New("not an RSS, Atom or JSON feed")
+	}
+	if err != nil {
+		return nil, codedErrorf(codeParseFailure, "failed to parse feed: %v", err)
+	}
+
+	feed.URL = feedURL
+	feed.Duration = time.Since(startTime).Milliseconds()
+	return feed, nil
+}
+
+// fetchFeed reads the feed at target, up to maxFeedBytes
+func fetchFeed(ctx context.Context, cfg *Config, target *url.URL) ([]byte, *http.Response, error) {
+	resp, release, err := fetchURL(ctx, cfg, target, http.Header{"Accept": {feedAccept}})
+	if err != nil {
+		return nil, nil, fetchError("failed to fetch URL", err)
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		code := codeUpstream4xx
+		if resp.StatusCode >= 500 {
+			code = codeUpstream5xx
+		}
+		return nil, nil, codedErrorf(code, "HTTP error: %d", resp.StatusCode)
+	}
+	if resp.ContentLength > maxFeedBytes {
+		return nil, nil, codedErrorf(codeTooLarge, "feed is %d bytes, more than the %d byte limit", resp.ContentLength, maxFeedBytes)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedBytes+1))
+	if err != nil {
+		return nil, nil, fetchError("failed to read response body", err)
+	}
+	if len(data) > maxFeedBytes {
+		return nil, nil, codedErrorf(codeTooLarge, "feed is over the %d byte limit", maxFeedBytes)
+	}
+	return data, resp, nil
+}
+
+// feedFormat tells a JSON feed from an XML one, rss standing for RSS and
+// Atom alike. A body that plainly starts as one or the other decides, since
+// feeds are often served as text/plain or text/html; otherwise the media
+// type does.
+func feedFormat(data []byte, mediaType string) string {
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	switch {
+	case bytes.HasPrefix(data, []byte("{")):
+		return feedJSON
+	case bytes.HasPrefix(data, []byte("<")):
+		return feedRSS
+	case mediaType == "application/feed+json", mediaType == "application/json":
+		return feedJSON
+	case isFeedContentType(mediaType):
+		return feedRSS
+	}
+	return ""
+}
+
+// jsonFeed is a JSON Feed (https://jsonfeed.org), version 1 or 1.1
+type jsonFeed struct {
+	Version     string `json:"version"`
+	Title       string `json:"title"`
+	HomePageURL string `json:"home_page_url"`
+	Description string `json:"description"`
+	Icon        string `json:"icon"`
+	Favicon     string `json:"favicon"`
+	Language    string `json:"language"`
+	Items       []struct {
+		// Some feeds number their items, though the spec says strings
+		ID            interface{} `json:"id"`
+		URL           string      `json:"url"`
+		ExternalURL   string      `json:"external_url"`
+		Title         string      `json:"title"`
+		Summary       string      `json:"summary"`
+		ContentText   string      `json:"content_text"`
+		ContentHTML   string      `json:"content_html"`
+		Image         string      `json:"image"`
+		BannerImage   string      `json:"banner_image"`
+		DatePublished string      `json:"date_published"`
+		DateModified  string      `json:"date_modified"`
+		Attachments   []struct {
+			URL         string `json:"url"`
+			MimeType    string `json:"mime_type"`
+			SizeInBytes int64  `json:"size_in_bytes"`
+		} `json:"attachments"`
+	} `json:"items"`
+}
+
+// parseJSONFeed normalizes a JSON Feed and its first limit items
+func parseJSONFeed(data []byte, baseURL *url.URL, limit int) (*Feed, error) {
+	var doc jsonFeed
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if !strings.Contains(doc.Version, "jsonfeed.org") && doc.Items == nil {
+		return nil, errors.New("not a JSON feed")
+	}
+
+	feed := &Feed{
+		Type:        feedJSON,
+		Title:       sanitizeText(doc.Title),
+		Description: sanitizeText(doc.Description),
+		Link:        resolveFeedURL(doc.HomePageURL, baseURL),
+		Image:       resolveFeedURL(firstNonEmpty(doc.Icon, doc.Favicon), baseURL),
+		Language:    doc.Language,
+		TotalItems:  len(doc.Items),
+	}
+	entries := doc.Items[:min(limit, len(doc.Items))]
+	feed.Items = make([]FeedItem, 0, len(entries))
+	for _, entry := range entries {
+		item := FeedItem{
+			Title:     sanitizeText(entry.Title),
+			Link:      resolveFeedURL(firstNonEmpty(entry.URL, entry.ExternalURL), baseURL),
+			Published: parseFeedDate(firstNonEmpty(entry.DatePublished, entry.DateModified)),
+			Summary:   feedSummary(firstNonEmpty(entry.Summary, entry.ContentText, entry.ContentHTML)),
+			Image:     resolveFeedURL(firstNonEmpty(entry.Image, entry.BannerImage, firstHTMLImage(entry.ContentHTML)), baseURL),
+		}
+		if entry.ID != nil {
+			item.ID = strings.TrimSpace(fmt.Sprint(entry.ID))
+		}
+		if len(entry.Attachments) > 0 {
+			if attachmentURL := resolveFeedURL(entry.Attachments[0].URL, baseURL); attachmentURL != "" {
+				attachment := entry.Attachments[0]
+				item.Enclosure = &FeedEnclosure{
+					URL:    attachmentURL,
+					Type:   attachment.MimeType,
+					Length: attachment.SizeInBytes,
+				}
+			}
+		}
+		feed.Items = append(feed.Items, finishFeedItem(item))
+	}
+	return feed, nil
+}
+
+// feedNamespaces maps the namespaces of feed extensions to the prefixes
+// they are conventionally bound to, without trailing slashes. Elements in
+// other namespaces, such as RSS 1.0's own, count as unprefixed.
+var feedNamespaces = map[string]string{
+	"http://www.w3.org/2005/atom":                 "atom",
+	"http://search.yahoo.com/mrss":                "media",
+	"http://purl.org/dc/elements/1.1":             "dc",
+	"http://purl.org/rss/1.0/modules/content":     "content",
+	"http://www.itunes.com/dtds/podcast-1.0.dtd":  "itunes",
+	"http://www.w3.org/1999/02/22-rdf-syntax-ns#": "rdf",
+}
+
+// feedAutoClose are the HTML void elements that turn up unclosed in feeds'
+// markup. Unlike xml.HTMLAutoClose it leaves out link and source, which are
+// also RSS elements with content.
+var feedAutoClose = []string{"area", "br", "col", "hr", "img", "input", "meta", "wbr"}
+
+// feedNode is an element of an XML feed. text is the character data
+// directly within it; each child records where in it the child started, so
+// that textContent can put the text of the whole element back together.
+type feedNode struct {
+	name     string // The local name, prefixed as in feedNamespaces
+	attr     []xml.Attr
+	text     strings.Builder
+	children []*feedNode
+	offset   int // Length of the parent's text when the element started
+}
+
+// feedName is name as feedNode keeps it. Prefixes that were never bound to
+// a namespace are kept as they are.
+func feedName(name xml.Name) string {
+	space := strings.TrimSuffix(strings.ToLower(name.Space), "/")
+	prefix, known := feedNamespaces[space]
+	if !known && !strings.Contains(space, ":") {
+		prefix = space
+	}
+	if prefix == "" {
+		return strings.ToLower(name.Local)
+	}
+	return prefix + ":" + strings.ToLower(name.Local)
+}
+
+// child returns the first child named any of names, or nil
+func (n *feedNode) child(names ...string) *feedNode {
+	for _, c := range n.children {
+		if contains(names, c.name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// all returns the children named name
+func (n *feedNode) all(name string) []*feedNode {
+	var nodes []*feedNode
+	for _, c := range n.children {
+		if c.name == name {
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes
+}
+
+// textContent returns the character data within n, that of its
+// descendants included, in document order
+func (n *feedNode) textContent() string {
+	if len(n.children) == 0 {
+		return n.text.String()
+	}
+	var b strings.Builder
+	n.writeText(&b)
+	return b.String()
+}
+
+func (n *feedNode) writeText(b *strings.Builder) {
+	text, pos := n.text.String(), 0
+	for _, c := range n.children {
+		b.WriteString(text[pos:c.offset])
+		pos = c.offset
+		c.writeText(b)
+	}
+	b.WriteString(text[pos:])
+}
+
+// value returns the trimmed text of the first child named any of names
+// that has some
+func (n *feedNode) value(names ...string) string {
+	for _, c := range n.children {
+		if contains(names, c.name) {
+			if text := strings.TrimSpace(c.textContent()); text != "" {
+				return text
+			}
+		}
+	}
+	return ""
+}
+
+// attrValue returns the attribute with the local name key
+func (n *feedNode) attrValue(key string) string {
+	for _, a := range n.attr {
+		if strings.EqualFold(a.Name.Local, key) {
+			return strings.TrimSpace(a.Value)
+		}
+	}
+	return ""
+}
+
+// parseFeedTree reads an XML feed into a tree, forgiving what feeds in the
+// wild get wrong: HTML entities, unclosed tags, stray ampersands, and
+// bodies that don't match their declared encoding. A document that breaks
+// off keeps what was read until then. Text is kept once, on the innermost
+// element, and the tree is cut to maxFeedDepth and maxFeedNodes, so that
+// deeply nested elements can't multiply the memory a feed takes.
+func parseFeedTree(data []byte, contentType string) (*feedNode, error) {
+	decoder := xml.NewDecoder(feedXMLReader(data, contentType))
+	decoder.Strict = false
+	decoder.AutoClose = feedAutoClose
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = charset.NewReaderLabel
+
+	var root *feedNode
+	var stack []*feedNode
+	nodes, skipped := 0, 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if root != nil {
+				return root, nil
+			}
+			if err == io.EOF {
+				err = errors.New("document is empty")
+			}
+			return nil, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if len(stack) >= maxFeedDepth {
+				skipped++
+				continue
+			}
+			if nodes >= maxFeedNodes {
+				return root, nil
+			}
+			nodes++
+			node := &feedNode{name: feedName(token.Name), attr: token.Attr}
+			if len(stack) == 0 {
+				if root != nil {
+					// Only the first root element is the feed
+					return root, nil
+				}
+				root = node
+			} else {
+				parent := stack[len(stack)-1]
+				node.offset = parent.text.Len()
+				parent.children = append(parent.children, node)
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			switch {
+			case skipped > 0:
+				skipped--
+			case len(stack) > 0:
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(token)
+			}
+		}
+	}
+}
+
+// feedXMLReader returns data for the XML decoder. A body that is not UTF-8
+// and doesn't say what it is in its XML declaration is decoded with the
+// charset of its Content-Type, or else as windows-1252.
+func feedXMLReader(data []byte, contentType string) io.Reader {
+	if utf8.Valid(data) {
+		return bytes.NewReader(data)
+	}
+	if prolog, _, ok := bytes.Cut(data, []byte("?>")); ok && bytes.HasPrefix(bytes.TrimSpace(prolog), []byte("<?xml")) &&
+		bytes.Contains(prolog, []byte("encoding")) {
+		return bytes.NewReader(data)
+	}
+	label := assumedCharset
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+		label = params["charset"]
+	}
+	enc, _ := charset.Lookup(label)
+	if enc == nil {
+		enc, _ = charset.Lookup(assumedCharset)
+	}
+	return transform.NewReader(bytes.NewReader(data), enc.NewDecoder())
+}
+
+// parseXMLFeed normalizes an RSS 0.9x, 1.0 or 2.0 feed or an Atom feed, and
+// its first limit items
+func parseXMLFeed(data []byte, contentType string, baseURL *url.URL, limit int) (*Feed, error) {
+	root, err := parseFeedTree(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	switch root.name {
+	case "rss", "rdf:rdf", "rdf":
+		return rssFeed(root, baseURL, limit), nil
+	case "atom:feed", "feed":
+		return atomFeed(root, baseURL, limit), nil
+	}
+	return nil, fmt.Errorf("root element is <%s>, not <rss> or <feed>", root.name)
+}
+
+// rssFeed normalizes an RSS feed. RSS 1.0 puts items beside the channel
+// rather than in it.
+func rssFeed(root *feedNode, baseURL *url.URL, limit int) *Feed {
+	channel := root.child("channel")
+	if channel == nil {
+		channel = &feedNode{}
+	}
+	items := channel.all("item")
+	if len(items) == 0 {
+		items = root.all("item")
+	}
+
+	feed := &Feed{
+		Type:        feedRSS,
+		Title:       sanitizeText(channel.value("title")),
+		Description: sanitizeText(channel.value("description", "itunes:summary")),
+		Link:        resolveFeedURL(firstNonEmpty(channel.value("link"), atomLink(channel, "alternate")), baseURL),
+		Language:    channel.value("language", "dc:language"),
+		Updated:     parseFeedDate(channel.value("lastbuilddate", "pubdate", "dc:date")),
+		TotalItems:  len(items),
+	}
+	items = items[:min(limit, len(items))]
+	feed.Items = make([]FeedItem, 0, len(items))
+	if image := channel.child("image"); image != nil {
+		feed.Image = resolveFeedURL(firstNonEmpty(image.value("url"), image.attrValue("resource")), baseURL)
+	}
+	if feed.Image == "" {
+		if image := channel.child("itunes:image"); image != nil {
+			feed.Image = resolveFeedURL(image.attrValue("href"), baseURL)
+		}
+	}
+
+	for _, node := range items {
+		description := node.value("description")
+		content := node.value("content:encoded")
+		item := FeedItem{
+			ID:        node.value("guid", "dc:identifier"),
+			Title:     sanitizeText(node.value("title", "dc:title")),
+			Link:      resolveFeedURL(firstNonEmpty(node.value("link"), atomLink(node, "alternate"), node.attrValue("about")), baseURL),
+			Published: parseFeedDate(node.value("pubdate", "dc:date", "atom:published", "atom:updated")),
+			Summary:   feedSummary(firstNonEmpty(description, content, node.value("itunes:summary", "media:description"))),
+			Image:     resolveFeedURL(firstNonEmpty(mediaImage(node), firstHTMLImage(description), firstHTMLImage(content)), baseURL),
+		}
+		// A GUID that is a permalink is the item's link when it has no other
+		if guid := node.child("guid"); item.Link == "" && guid != nil && guid.attrValue("ispermalink") != "false" && validAssetURL(item.ID, feedURLSchemes) {
+			item.Link = item.ID
+		}
+		if enclosure := node.child("enclosure"); enclosure != nil {
+			if enclosureURL := resolveFeedURL(enclosure.attrValue("url"), baseURL); enclosureURL != "" {
+				length, _ := strconv.ParseInt(enclosure.attrValue("length"), 10, 64)
+				item.Enclosure = &FeedEnclosure{
+					URL:    enclosureURL,
+					Type:   enclosure.attrValue("type"),
+					Length: max(length, 0),
+				}
+				if item.Image == "" && strings.HasPrefix(item.Enclosure.Type, "image/") {
+					item.Image = item.Enclosure.URL
+				}
+			}
+		}
+		feed.Items = append(feed.Items, finishFeedItem(item))
+	}
+	return feed
+}
+
+// atomFeed normalizes an Atom feed, whose elements may also be unprefixed
+// when the feed forgot its namespace
+func atomFeed(root *feedNode, baseURL *url.URL, limit int) *Feed {
+	entries := append(root.all("atom:entry"), root.all("entry")...)
+	feed := &Feed{
+		Type:        feedAtom,
+		Title:       sanitizeText(root.value("atom:title", "title")),
+		Description: sanitizeText(root.value("atom:subtitle", "subtitle")),
+		Link:        resolveFeedURL(atomLink(root, "alternate"), baseURL),
+		Image:       resolveFeedURL(root.value("atom:logo", "logo", "atom:icon", "icon"), baseURL),
+		Language:    firstNonEmpty(root.attrValue("lang"), root.value("dc:language")),
+		Updated:     parseFeedDate(root.value("atom:updated", "updated")),
+		TotalItems:  len(entries),
+	}
+	entries = entries[:min(limit, len(entries))]
+	feed.Items = make([]FeedItem, 0, len(entries))
+
+	for _, node := range entries {
+		content := node.value("atom:content", "content", "content:encoded")
+		item := FeedItem{
+			ID:        node.value("atom:id", "id"),
+			Title:     sanitizeText(node.value("atom:title", "title")),
+			Link:      resolveFeedURL(atomLink(node, "alternate"), baseURL),
+			Published: parseFeedDate(node.value("atom:published", "published", "atom:updated", "updated", "dc:date")),
+			Summary:   feedSummary(firstNonEmpty(node.value("atom:summary", "summary"), content)),
+			Image:     resolveFeedURL(firstNonEmpty(mediaImage(node), firstHTMLImage(content)), baseURL),
+		}
+		for _, link := range append(node.all("atom:link"), node.all("link")...) {
+			href := resolveFeedURL(link.attrValue("href"), baseURL)
+			if link.attrValue("rel") != "enclosure" || href == "" {
+				continue
+			}
+			length, _ := strconv.ParseInt(link.attrValue("length"), 10, 64)
+			item.Enclosure = &FeedEnclosure{
+				URL:    href,
+				Type:   link.attrValue("type"),
+				Length: max(length, 0),
+			}
+			if item.Image == "" && strings.HasPrefix(item.Enclosure.Type, "image/") {
+				item.Image = item.Enclosure.URL
+			}
+			break
+		}
+		feed.Items = append(feed.Items, finishFeedItem(item))
+	}
+	return feed
+}
+
+// atomLink returns the href of n's first Atom link with rel, a link
+// without a rel counting as alternate
+func atomLink(n *feedNode, rel string) string {
+	for _, c := range n.children {
+		if c.name != "atom:link" && c.name != "link" {
+			continue
+		}
+		linkRel := c.attrValue("rel")
+		if linkRel == "" {
+			linkRel = "alternate"
+		}
+		if href := c.attrValue("href"); href != "" && linkRel == rel {
+			return href
+		}
+	}
+	return ""
+}
+
+// mediaImage returns an item's Media RSS or iTunes image: a thumbnail, else
+// content that is an image, looking inside a media:group too
+func mediaImage(n *feedNode) string {
+	for _, group := range append([]*feedNode{n}, n.all("media:group")...) {
+		if thumbnail := group.child("media:thumbnail"); thumbnail != nil && thumbnail.attrValue("url") != "" {
+			return thumbnail.attrValue("url")
+		}
+		for _, content := range group.all("media:content") {
+			medium, mediaType := content.attrValue("medium"), content.attrValue("type")
+			if medium == "image" || strings.HasPrefix(mediaType, "image/") || (medium == "" && mediaType == "") {
+				if imageURL := content.attrValue("url"); imageURL != "" {
+					return imageURL
+				}
+			}
+		}
+	}
+	if image := n.child("itunes:image"); image != nil {
+		return image.attrValue("href")
+	}
+	return ""
+}
+
+// firstHTMLImage returns the src of the first <img> in an HTML fragment
+func firstHTMLImage(fragment string) string {
+	if !strings.Contains(fragment, "<") {
+		return ""
+	}
+	z := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "img" {
+				continue
+			}
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if string(key) == "src" && !strings.HasPrefix(string(val), "data:") {
+					return strings.TrimSpace(string(val))
+				}
+			}
+		}
+	}
+}
+
+// finishFeedItem gives an item without a GUID its link as an ID, or a
+// digest of its title and summary when it has no link either
+func finishFeedItem(item FeedItem) FeedItem {
+	if item.ID == "" {
+		item.ID = item.Link
+	}
+	if item.ID == "" {
+		sum := sha256.Sum256([]byte(item.Title + "\n" + item.Published + "\n" + item.Summary))
+		item.ID = "sha256:" + hex.EncodeToString(sum[:16])
+	}
+	return item
+}
+
+// feedSummary makes an item's description plain text of at most
+// maxFeedSummaryBytes
+func feedSummary(s string) string {
+	s = sanitizeText(s)
+	if len(s) > maxFeedSummaryBytes {
+		s = strings.TrimSpace(truncateUTF8(s, maxFeedSummaryBytes-len("…"))) + "…"
+	}
+	return s
+}
+
+// feedURLSchemes are the schemes of links kept from a feed. Clients render
+// them, so javascript: and data: URLs from a hostile feed are dropped.
+var feedURLSchemes = []string{"http", "https"}
+
+// resolveFeedURL resolves a link found in a feed against baseURL, leaving
+// out empty ones and those that aren't http(s) with a host
+func resolveFeedURL(href string, baseURL *url.URL) string {
+	href = strings.TrimSpace(href)
+	if href == "" {
+		return ""
+	}
+	resolved := resolveURL(href, baseURL)
+	if !validAssetURL(resolved, feedURLSchemes) {
+		return ""
+	}
+	return resolved
+}
+
+// feedDateLayouts are the date formats found in feeds, RFC 822 in its many
+// variations for RSS and RFC 3339 for Atom and JSON Feed. Weekdays are
+// removed before these are tried, since feeds often get them wrong.
+var feedDateLayouts = []string{
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04 -0700",
+	"2 Jan 2006 15:04 MST",
+	"2 January 2006 15:04:05 -0700",
+	"2 January 2006 15:04:05 MST",
+	"2 Jan 06 15:04:05 -0700",
+	"2 Jan 06 15:04:05 MST",
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseFeedDate returns a feed's date in RFC 3339 and UTC, or "" when it
+// can't be read
+func parseFeedDate(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if i := strings.Index(s, ","); i >= 0 && i <= len("Wednesday") {
+		s = strings.TrimSpace(s[i+1:])
+	}
+	for _, layout := range feedDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+	return ""
+}
+
+// firstNonEmpty returns the first of values that isn't empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
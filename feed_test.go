@@ -0,0 +1,228 @@
+package main
+
+import (
+	"net/url"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestParseXMLFeedRSSQuirks(t *testing.T) {
+	feed := `<?xml version="1.0" encoding="ISO-8859-1"?>
+<rss version="2.0" xmlns:media="http://search.yahoo.com/mrss/" xmlns:atom="http://www.w3.org/2005/Atom">
+<channel>
+<title><![CDATA[My <b>Blog</b> caf` + "\xe9" + `]]></title>
+<atom:link href="/feed" rel="self"/>
+<link>/</link>
+<description>Tom & Jerry&nbsp;news</description>
+<item><title>First &lt;em&gt;post&lt;/em&gt;</title><link>/p/1</link><pubDate>Tue, 5 Mar 2024 10:00:00 GMT</pubDate>
+<description><![CDATA[<p>Hello <img src="/i/1.jpg"> world</p>]]></description></item>
+<item><title>Second</title><guid>https://example.com/p/2</guid><pubDate>Wed, 06 Mar 2024 10:00:00 +0100</pubDate>
+<media:thumbnail url="/t/2.jpg"/><enclosure url="/a.mp3" type="audio/mpeg" length="123"/></item>
+<item><title>No link</title><description>just text<br></description></item>
+</channel></rss>`
+
+	got, err := parseXMLFeed([]byte(feed), "text/xml", mustParseURL(t, "https://example.com/feed"), maxFeedItems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != feedRSS || got.Title != "My Blog café" || got.Description != "Tom & Jerry news" || got.Link != "https://example.com/" {
+		t.Errorf("channel = %+v", got)
+	}
+	if len(got.Items) != 3 {
+		t.Fatalf("got %d items, want 3", len(got.Items))
+	}
+
+	first := got.Items[0]
+	if first.Title != "First post" || first.Link != "https://example.com/p/1" || first.ID != first.Link ||
+		first.Published != "2024-03-05T10:00:00Z" || first.Summary != "Hello world" || first.Image != "https://example.com/i/1.jpg" {
+		t.Errorf("first item = %+v", first)
+	}
+	second := got.Items[1]
+	if second.Link != "https://example.com/p/2" || second.Published != "2024-03-06T09:00:00Z" || second.Image != "https://example.com/t/2.jpg" ||
+		second.Enclosure == nil || second.Enclosure.URL != "https://example.com/a.mp3" || second.Enclosure.Length != 123 {
+		t.Errorf("second item = %+v", second)
+	}
+	third := got.Items[2]
+	if third.Link != "" || !strings.HasPrefix(third.ID, "sha256:") || third.Summary != "just text" {
+		t.Errorf("third item = %+v", third)
+	}
+}
+
+func TestParseXMLFeedAtom(t *testing.T) {
+	feed := `<feed xmlns="http://www.w3.org/2005/Atom" xml:lang="en"><title>Atom</title><subtitle>Sub</subtitle>
+<link href="/"/><link rel="self" href="/atom"/>
+<entry><title type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml">X <b>one</b> more</div></title><id>tag:1</id>
+<link href="e/1"/><published>2024-03-01T10:00:00+02:00</published><content type="html">&lt;p&gt;body&lt;/p&gt;</content></entry>
+</feed>`
+
+	got, err := parseXMLFeed([]byte(feed), "application/atom+xml", mustParseURL(t, "https://example.com/blog/atom"), maxFeedItems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != feedAtom || got.Title != "Atom" || got.Description != "Sub" || got.Language != "en" || got.Link != "https://example.com/" {
+		t.Errorf("channel = %+v", got)
+	}
+	if len(got.Items) != 1 {
+		t.Fatalf("got %d items, want 1", len(got.Items))
+	}
+	item := got.Items[0]
+	if item.Title != "X one more" || item.ID != "tag:1" || item.Link != "https://example.com/blog/e/1" ||
+		item.Published != "2024-03-01T08:00:00Z" || item.Summary != "body" {
+		t.Errorf("item = %+v", item)
+	}
+}
+
+func TestParseJSONFeed(t *testing.T) {
+	feed := `{"version":"https://jsonfeed.org/version/1.1","title":"JF","home_page_url":"/","items":[
+{"id":42,"url":"/j/1","title":"J <i>1</i>","content_html":"<p>x <img src='/j.png'></p>","date_published":"2024-03-01T10:00:00Z",
+"attachments":[{"url":"/f.mp3","mime_type":"audio/mpeg"}]}]}`
+
+	got, err := parseJSONFeed([]byte(feed), mustParseURL(t, "https://example.com/feed.json"), maxFeedItems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != feedJSON || got.Title != "JF" || len(got.Items) != 1 {
+		t.Fatalf("feed = %+v", got)
+	}
+	item := got.Items[0]
+	if item.ID != "42" || item.Title != "J 1" || item.Link != "https://example.com/j/1" || item.Image != "https://example.com/j.png" ||
+		item.Enclosure == nil || item.Enclosure.URL != "https://example.com/f.mp3" {
+		t.Errorf("item = %+v", item)
+	}
+}
+
+func TestFeedFormat(t *testing.T) {
+	tests := []struct {
+		body, mediaType, want string
+	}{
+		{`{"version":""}`, "text/plain", feedJSON},
+		{"\xef\xbb\xbf  <rss/>", "text/html", feedRSS},
+		{"", "application/feed+json", feedJSON},
+		{"", "application/atom+xml", feedRSS},
+		{"hello", "text/plain", ""},
+	}
+	for _, tt := range tests {
+		if got := feedFormat([]byte(tt.body), tt.mediaType); got != tt.want {
+			t.Errorf("feedFormat(%q, %q) = %q, want %q", tt.body, tt.mediaType, got, tt.want)
+		}
+	}
+}
+
+// A feed nesting thousands of elements around a long text used to copy the
+// text into every ancestor, taking gigabytes for a few hundred kilobytes
+func TestParseFeedTreeDeepNesting(t *testing.T) {
+	const depth = 20000
+	var b strings.Builder
+	b.WriteString("<rss><channel><title>Deep</title><item><title>")
+	b.WriteString(strings.Repeat("<x>", depth))
+	b.WriteString(strings.Repeat("a", 200*1024))
+	b.WriteString(strings.Repeat("</x>", depth))
+	b.WriteString("</title></item></channel></rss>")
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	feed, err := parseXMLFeed([]byte(b.String()), "text/xml", mustParseURL(t, "https://example.com/feed"), maxFeedItems)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Errorf("parsing allocated %d MB, want under 64 MB", allocated>>20)
+	}
+	if feed.Title != "Deep" || len(feed.Items) != 1 || len(feed.Items[0].Title) != 200*1024 {
+		t.Errorf("got title %q and %d items", feed.Title, len(feed.Items))
+	}
+}
+
+func TestParseFeedTreeNodeLimit(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("<rss><channel><title>Many</title>")
+	for i := 0; i < maxFeedNodes; i++ {
+		b.WriteString("<item><title>t</title></item>")
+	}
+	b.WriteString("</channel></rss>")
+
+	feed, err := parseXMLFeed([]byte(b.String()), "text/xml", mustParseURL(t, "https://example.com/feed"), maxFeedItems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rss, channel and title take three of the nodes and each item two,
+	// but for the last, which is cut off before its title
+	if want := (maxFeedNodes-3)/2 + 1; feed.TotalItems != want {
+		t.Errorf("got %d items, want %d", feed.TotalItems, want)
+	}
+}
+
+func TestFeedDropsNonHTTPURLs(t *testing.T) {
+	feed := `<rss><channel><title>Hostile</title><link>javascript:alert(1)</link>
+<image><url>data:image/svg+xml,&lt;svg/&gt;</url></image>
+<item><title>One</title><link>javascript:alert(document.cookie)</link><guid>javascript:alert(2)</guid>
+<description><![CDATA[<img src="javascript:alert(3)">]]></description>
+<enclosure url="data:audio/mpeg;base64,AAAA" type="audio/mpeg"/></item>
+<item><title>Two</title><link>http:///no-host</link><media:thumbnail xmlns:media="http://search.yahoo.com/mrss/" url="vbscript:x"/></item>
+</channel></rss>`
+
+	got, err := parseXMLFeed([]byte(feed), "text/xml", mustParseURL(t, "https://example.com/feed"), maxFeedItems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Link != "" || got.Image != "" {
+		t.Errorf("channel link %q and image %q, want both dropped", got.Link, got.Image)
+	}
+	for _, item := range got.Items {
+		if item.Link != "" || item.Image != "" || item.Enclosure != nil {
+			t.Errorf("item %q kept link %q, image %q or enclosure %+v", item.Title, item.Link, item.Image, item.Enclosure)
+		}
+	}
+
+	jsonFeed := `{"version":"https://jsonfeed.org/version/1.1","home_page_url":"javascript:x","items":[
+{"url":"data:text/html,x","image":"javascript:y","attachments":[{"url":"javascript:z"}]}]}`
+	jf, err := parseJSONFeed([]byte(jsonFeed), mustParseURL(t, "https://example.com/feed.json"), maxFeedItems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item := jf.Items[0]; jf.Link != "" || item.Link != "" || item.Image != "" || item.Enclosure != nil {
+		t.Errorf("JSON feed kept %q or item %+v", jf.Link, item)
+	}
+}
+
+func TestFeedLimit(t *testing.T) {
+	var rss, atom strings.Builder
+	rss.WriteString("<rss><channel>")
+	atom.WriteString(`<feed xmlns="http://www.w3.org/2005/Atom">`)
+	for i := 0; i < 30; i++ {
+		rss.WriteString("<item><title>t</title></item>")
+		atom.WriteString("<entry><title>t</title></entry>")
+	}
+	rss.WriteString("</channel></rss>")
+	atom.WriteString("</feed>")
+	jsonFeed := `{"version":"https://jsonfeed.org/version/1.1","items":[` + strings.Repeat(`{"title":"t"},`, 29) + `{"title":"t"}]}`
+
+	base := mustParseURL(t, "https://example.com/feed")
+	feeds := map[string]func() (*Feed, error){
+		"rss":  func() (*Feed, error) { return parseXMLFeed([]byte(rss.String()), "text/xml", base, 5) },
+		"atom": func() (*Feed, error) { return parseXMLFeed([]byte(atom.String()), "text/xml", base, 5) },
+		"json": func() (*Feed, error) { return parseJSONFeed([]byte(jsonFeed), base, 5) },
+	}
+	for name, parse := range feeds {
+		feed, err := parse()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(feed.Items) != 5 || feed.TotalItems != 30 {
+			t.Errorf("%s: %d items of %d, want 5 of 30", name, len(feed.Items), feed.TotalItems)
+		}
+	}
+}
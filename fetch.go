@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// userAgent is sent with upstream requests when no USER_AGENTS pool is configured
+	userAgent = "metadata.party/1.0 (+https://github.com/yourusername/metadata.party)"
+
+	// fetchTimeout bounds all upstream work done for a single URL
+	fetchTimeout = 30 * time.Second
+
+	// responseWriteMargin is the time left to write a response once the
+	// upstream work for it has used its whole budget
+	responseWriteMargin = 5 * time.Second
+
+	// maxPageBytes is how much of a page is read for extraction
+	maxPageBytes = 10 * 1024 * 1024
+
+	// defaultHTMLStreamThreshold is used when HTML_STREAM_THRESHOLD is not set
+	defaultHTMLStreamThreshold = 1024 * 1024
+
+	// defaultMaxMetaContentBytes is used when MAX_META_CONTENT_BYTES is not set
+	defaultMaxMetaContentBytes = 8 * 1024
+
+	// defaultBodyReadTimeout is used when BODY_READ_TIMEOUT is not set
+	defaultBodyReadTimeout = 10 * time.Second
+
+	// defaultMaxRequestsPerHost is used when MAX_REQUESTS_PER_HOST is not set
+	defaultMaxRequestsPerHost = 4
+
+	// defaultMaxIdleConns is used when MAX_IDLE_CONNS is not set
+	defaultMaxIdleConns = 100
+
+	// defaultIdleConnTimeout is used when IDLE_CONN_TIMEOUT is not set
+	defaultIdleConnTimeout = 90 * time.Second
+
+	// maxRedirects is how many redirects a fetch follows
+	maxRedirects = 10
+)
+
+// defaultRedirectStripHeaders is used when REDIRECT_STRIP_HEADERS is not set
+var defaultRedirectStripHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// transport is shared by every upstream fetch so connections are pooled. It
+// dials through the shared DNS cache.
+var transport = newTransport()
+
+// hedgeTransport carries hedged requests. Its separate pool means a hedge
+// never shares the stalled connection of the request it is racing.
+var hedgeTransport = newTransport()
+
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = dialContext
+	return t
+}
+
+// http1Transport carries the retry of a page whose body came back empty. It
+// only speaks HTTP/1.1 and closes every connection after its response, the
+// way browsers end up reading some servers' malformed chunked bodies.
+var http1Transport = newHTTP1Transport()
+
+func newHTTP1Transport() *http.Transport {
+	t := newTransport()
+	t.ForceAttemptHTTP2 = false
+	t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	t.DisableKeepAlives = true
+	return t
+}
+
+// forceHTTP1Key marks a fetch that must go through http1Transport
+type forceHTTP1Key struct{}
+
+func withForcedHTTP1(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceHTTP1Key{}, true)
+}
+
+// newHTTPClient returns a client for upstream fetches. Timeouts come from the
+// request context so that sub-fetches share the caller's deadline.
+func newHTTPClient(cfg *Config) *http.Client {
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// Limit redirects to prevent infinite loops
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("too many redirects")
+			}
+			// Credentials meant for the original site don't follow it elsewhere
+			if !sameOrigin(req.URL, via[0].URL) {
+				for _, name := range cfg.RedirectStripHeaders {
+					req.Header.Del(name)
+				}
+			}
+			// Redirect targets must pass the same SSRF checks as the original URL
+			return validateURLForSSRF(cfg, req.URL)
+		},
+	}
+}
+
+// sameOrigin reports whether a and b share scheme, host and port
+func sameOrigin(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Hostname(), b.Hostname()) && effectivePort(a) == effectivePort(b)
+}
+
+// effectivePort returns u's port, or the default port of its scheme
+func effectivePort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if strings.EqualFold(u.Scheme, "https") {
+		return "443"
+	}
+	return "80"
+}
+
+// tlsVersions maps MIN_TLS_VERSION values to crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func parseTLSVersion(v string) (uint16, error) {
+	version, ok := tlsVersions[v]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q (use 1.0, 1.1, 1.2 or 1.3)", v)
+	}
+	return version, nil
+}
+
+// configureTransport applies the upstream connection settings in cfg.
+// http1Transport keeps its own protocol and keep-alive settings, which are
+// the point of it.
+func configureTransport(cfg *Config) {
+	var version uint16
+	if cfg.MinTLSVersion != "" {
+		version, _ = parseTLSVersion(cfg.MinTLSVersion)
+	}
+	for _, t := range []*http.Transport{transport, hedgeTransport, http1Transport} {
+		t.MaxIdleConns = cfg.MaxIdleConns
+		t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+		t.MaxConnsPerHost = cfg.MaxConnsPerHost
+		t.IdleConnTimeout = cfg.IdleConnTimeout
+		if version != 0 {
+			if t.TLSClientConfig == nil {
+				t.TLSClientConfig = &tls.Config{}
+			}
+			t.TLSClientConfig.MinVersion = version
+		}
+	}
+	for _, t := range []*http.Transport{transport, hedgeTransport} {
+		t.DisableKeepAlives = cfg.DisableKeepAlives
+		if !cfg.ForceHTTP2 {
+			t.ForceAttemptHTTP2 = false
+			t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+	}
+}
+
+// hostLimits caps the number of concurrent upstream requests per host
+var hostLimits = newHostLimiter()
+
+// fetchURL waits for a per-host slot and issues a GET for target. The
+// User-Agent comes from the pool unless header sets one. The caller must
+// invoke release once it is done reading the body; release is safe to call
+// more than once.
+func fetchURL(ctx context.Context, cfg *Config, target *url.URL, header http.Header) (*http.Response, func(), error) {
+	// Domains that keep failing are refused before they can tie up a slot
+	recordOutcome, err := breakers.allow(cfg, target.Hostname())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	release, err := hostLimits.acquire(ctx, target.Hostname(), cfg.MaxRequestsPerHost)
+	if err != nil {
+		recordOutcome(outcomeIgnored)
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(withConfig(ctx, cfg), http.MethodGet, target.String(), nil)
+	if err != nil {
+		recordOutcome(outcomeIgnored)
+		release()
+		return nil, nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("User-Agent", userAgents.pick(cfg, target.Hostname(), ""))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	client := newHTTPClient(cfg)
+	if jar := cookieJarFromContext(ctx); jar != nil {
+		client.Jar = jar
+	}
+	var resp *http.Response
+	if forced, _ := ctx.Value(forceHTTP1Key{}).(bool); forced {
+		// A hedge would race it over the connections that failed
+		client.Transport = http1Transport
+		resp, err = client.Do(req)
+	} else {
+		resp, release, err = sendHedged(cfg, client, req, release)
+	}
+	if err != nil {
+		recordOutcome(classifyOutcome(ctx, 0, err))
+		release()
+		return nil, nil, err
+	}
+	recordOutcome(classifyOutcome(ctx, resp.StatusCode, nil))
+
+	return resp, release, nil
+}
+
+// hostLimiter is a set of per-host semaphores whose size can change at
+// runtime. Entries are removed once no request for the host is waiting or in
+// flight.
+type hostLimiter struct {
+	mu    sync.Mutex
+	hosts map[string]*hostSlots
+}
+
+// hostSlots tracks the requests to one host. Waiters are handed a slot
+// directly by release, highest priority first, after aging, and in arrival
+// order within a priority.
+type hostSlots struct {
+	limit   int
+	active  int
+	waiters []*hostWaiter
+}
+
+// hostWaiter is a request queued for a host slot
+type hostWaiter struct {
+	ready    chan struct{}
+	priority priority
+	since    time.Time
+}
+
+// next returns the index of the waiter to hand a slot to at now
+func (s *hostSlots) next(now time.Time) int {
+	best, bestPriority := 0, priority(-1)
+	for i, w := range s.waiters {
+		// Waiting counts for more the longer it lasts
+		p := w.priority + priority(now.Sub(w.since)/priorityAging)
+		if p > bestPriority {
+			best, bestPriority = i, p
+		}
+	}
+	return best
+}
+
+func newHostLimiter() *hostLimiter {
+	return &hostLimiter{hosts: make(map[string]*hostSlots)}
+}
+
+// acquire blocks until one of limit slots for host is free or ctx is done.
+// Queued requests are admitted by the priority in ctx.
+func (l *hostLimiter) acquire(ctx context.Context, host string, limit int) (func(), error) {
+	p := priorityFromContext(ctx)
+	l.mu.Lock()
+	slots, ok := l.hosts[host]
+	if !ok {
+		slots = &hostSlots{}
+		l.hosts[host] = slots
+	}
+	slots.limit = limit
+
+	if slots.active < slots.limit {
+		slots.active++
+		l.mu.Unlock()
+		fetchQueue.admit(p, 0)
+		return l.releaseFunc(host), nil
+	}
+
+	waiter := &hostWaiter{ready: make(chan struct{}), priority: p, since: time.Now()}
+	slots.waiters = append(slots.waiters, waiter)
+	l.mu.Unlock()
+	fetchQueue.classes[p].queued.Add(1)
+	defer fetchQueue.classes[p].queued.Add(-1)
+
+	select {
+	case <-waiter.ready:
+		fetchQueue.admit(p, time.Since(waiter.since))
+		return l.releaseFunc(host), nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		for i, w := range slots.waiters {
+			if w == waiter {
+				slots.waiters = append(slots.waiters[:i], slots.waiters[i+1:]...)
+				l.mu.Unlock()
+				return nil, ctx.Err()
+			}
+		}
+		l.mu.Unlock()
+		// A slot was handed over just as we gave up; pass it on
+		l.release(host)
+		return nil, ctx.Err()
+	}
+}
+
+// tryAcquire takes a slot for host only if one is free right away and nobody
+// is queued for it
+func (l *hostLimiter) tryAcquire(host string, limit int) (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slots, ok := l.hosts[host]
+	if !ok {
+		slots = &hostSlots{}
+		l.hosts[host] = slots
+	}
+	slots.limit = limit
+	if slots.active >= slots.limit || len(slots.waiters) > 0 {
+		if slots.active == 0 && len(slots.waiters) == 0 {
+			delete(l.hosts, host)
+		}
+		return nil, false
+	}
+	slots.active++
+	return l.releaseFunc(host), true
+}
+
+func (l *hostLimiter) releaseFunc(host string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { l.release(host) })
+	}
+}
+
+func (l *hostLimiter) release(host string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slots := l.hosts[host]
+	// Hand the slot straight to the next waiter unless the limit was lowered
+	if len(slots.waiters) > 0 && slots.active <= slots.limit {
+		i := slots.next(time.Now())
+		next := slots.waiters[i]
+		slots.waiters = append(slots.waiters[:i], slots.waiters[i+1:]...)
+		close(next.ready)
+		return
+	}
+
+	slots.active--
+	if slots.active == 0 && len(slots.waiters) == 0 {
+		delete(l.hosts, host)
+	}
+}
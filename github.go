@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// githubAPI is the base URL of GitHub's REST API
+	githubAPI = "https://api.github.com"
+
+	// maxGitHubAPIBytes caps the download of a GitHub API response
+	maxGitHubAPIBytes = 1024 * 1024
+)
+
+// githubReservedOwners are first path segments of github.com that are site
+// pages rather than users or organizations
+var githubReservedOwners = map[string]bool{
+	"about": true, "apps": true, "collections": true, "contact": true,
+	"customer-stories": true, "enterprise": true, "events": true,
+	"explore": true, "features": true, "issues": true, "login": true,
+	"marketplace": true, "new": true, "notifications": true, "orgs": true,
+	"organizations": true, "pricing": true, "pulls": true, "search": true,
+	"security": true, "settings": true, "site": true, "sponsors": true,
+	"topics": true, "trending": true,
+}
+
+// GitHubRepo is what GitHub's API adds about a repository
+type GitHubRepo struct {
+	Stars         int      `json:"stars"`
+	Language      string   `json:"language,omitempty"`
+	License       string   `json:"license,omitempty"`
+	Topics        []string `json:"topics,omitempty"`
+	DefaultBranch string   `json:"default_branch"`
+}
+
+var githubProvider = provider{
+	name: "github",
+	match: func(pageURL *url.URL) bool {
+		_, _, ok := githubRepoPath(pageURL)
+		return ok
+	},
+	fetch: func(ctx context.Context, cfg *Config, pageURL *url.URL) (interface{}, error) {
+		owner, repo, _ := githubRepoPath(pageURL)
+		return fetchGitHubRepo(ctx, cfg, owner, repo)
+	},
+}
+
+// githubRepoPath returns the owner and name of the repository a github.com
+// URL is the home page of
+func githubRepoPath(u *url.URL) (owner, repo string, ok bool) {
+	host := strings.ToLower(u.Hostname())
+	if host != "github.com" && host != "www.github.com" {
+		return "", "", false
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || githubReservedOwners[strings.ToLower(parts[0])] {
+		return "", "", false
+	}
+	return parts[0], strings.TrimSuffix(parts[1], ".git"), true
+}
+
+// fetchGitHubRepo calls GitHub's repository API, with GITHUB_TOKEN when set
+// for its higher rate limit
+func fetchGitHubRepo(ctx context.Context, cfg *Config, owner, repo string) (*GitHubRepo, error) {
+	apiURL, err := url.Parse(fmt.Sprintf("%s/repos/%s/%s", githubAPI, url.PathEscape(owner), url.PathEscape(repo)))
+	if err != nil {
+		return nil, err
+	}
+	if err := validateURLForSSRF(cfg, apiURL); err != nil {
+		return nil, err
+	}
+
+	header := http.Header{
+		"Accept":               {"application/vnd.github+json"},
+		"X-GitHub-Api-Version": {"2022-11-28"},
+	}
+	if cfg.GitHubToken != "" {
+		header.Set("Authorization", "Bearer "+cfg.GitHubToken)
+	}
+	resp, release, err := fetchURL(ctx, cfg, apiURL, header)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP error: %d", resp.StatusCode)
+	}
+
+	var payload struct {
+		StargazersCount int      `json:"stargazers_count"`
+		Language        string   `json:"language"`
+		Topics          []string `json:"topics"`
+		DefaultBranch   string   `json:"default_branch"`
+		License         *struct {
+			SPDXID string `json:"spdx_id"`
+			Name   string `json:"name"`
+		} `json:"license"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxGitHubAPIBytes)).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("invalid API response: %v", err)
+	}
+
+	info := &GitHubRepo{
+		Stars:         payload.StargazersCount,
+		Language:      payload.Language,
+		Topics:        payload.Topics,
+		DefaultBranch: payload.DefaultBranch,
+	}
+	// GitHub reports licenses it can't identify as NOASSERTION
+	if payload.License != nil {
+		info.License = payload.License.SPDXID
+		if info.License == "" || info.License == "NOASSERTION" {
+			info.License = payload.License.Name
+		}
+	}
+	return info, nil
+}
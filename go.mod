@@ -3,3 +3,8 @@ module metadata.party
 go 1.21
 
 require golang.org/x/net v0.30.0
+
+require (
+	golang.org/x/crypto v0.28.0
+	golang.org/x/text v0.19.0
+)
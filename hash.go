@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// hashedMetadata is what metadata_hash covers: the fields a page declares
+// about itself, without anything that depends on the fetch or the request's
+// options
+type hashedMetadata struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	SiteName    []string `json:"sitename"`
+	Images      []string `json:"images"`
+	Canonical   string   `json:"canonical"`
+	Favicon     string   `json:"favicon"`
+	Video       *Video   `json:"video"`
+	OEmbedURL   string   `json:"oembed_url"`
+}
+
+// addHashes sets the SHA-256 of the page's body, as read after decompression,
+// and of its normalized metadata. It runs before anything that depends on
+// the request's options, such as image verification or the favicon fallback,
+// touches the metadata.
+func addHashes(metadata *MetadataResponse, page *fetchedPage) {
+	metadata.ContentHash = hex.EncodeToString(page.bodySHA256)
+	metadata.ContentHashPartial = page.transfer.Truncated
+
+	fields := hashedMetadata{
+		Title:       normalizeHashText(metadata.Title),
+		Description: normalizeHashText(metadata.Description),
+		SiteName:    make([]string, 0, len(metadata.SiteName)),
+		Images:      metadata.Images,
+		Canonical:   metadata.Canonical,
+		Favicon:     metadata.Favicon,
+		Video:       metadata.Video,
+		OEmbedURL:   metadata.OEmbedURL,
+	}
+	// A site name guessed for a page without og:site_name isn't declared
+	if metadata.Sources["sitename"] == "og:site_name" {
+		for _, name := range metadata.SiteName {
+			fields.SiteName = append(fields.SiteName, normalizeHashText(name))
+		}
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+	sum := sha256.Sum256(data)
+	metadata.MetadataHash = hex.EncodeToString(sum[:])
+}
+
+// normalizeHashText collapses whitespace so that reflowed text hashes the same
+func normalizeHashText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxResponseHeaderBytes caps each value returned in response_headers
+const maxResponseHeaderBytes = 1024
+
+// defaultResponseHeaders is used when RESPONSE_HEADERS is not set
+var defaultResponseHeaders = []string{
+	"Content-Type",
+	"Content-Language",
+	"Last-Modified",
+	"Server",
+	"X-Robots-Tag",
+	"Link",
+}
+
+// responseHeaderLinkRels are the Link header relations reported in
+// response_headers; others say nothing about the page itself
+var responseHeaderLinkRels = []string{"canonical", "icon"}
+
+// parseHSTS returns whether resp enables Strict-Transport-Security and its
+// max-age. Browsers ignore the header on plain HTTP, so we do too.
+func parseHSTS(resp *http.Response) (bool, int64) {
+	if resp.TLS == nil {
+		return false, 0
+	}
+	header := resp.Header.Get("Strict-Transport-Security")
+	if header == "" {
+		return false, 0
+	}
+
+	for _, directive := range strings.Split(header, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		maxAge, err := strconv.ParseInt(strings.Trim(strings.TrimSpace(value), `"`), 10, 64)
+		if err != nil || maxAge <= 0 {
+			// max-age=0 tells the browser to forget the host
+			return false, 0
+		}
+		return true, maxAge
+	}
+	return false, 0
+}
+
+// parseHeaderNames parses a comma-separated list of header names into their
+// canonical form
+func parseHeaderNames(list string) []string {
+	names := []string{}
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	return names
+}
+
+// selectResponseHeaders returns the values of the headers named in names,
+// several values of one header joined as they would be on the wire. Only
+// canonical and icon Link headers are kept.
+func selectResponseHeaders(header http.Header, names []string) map[string]string {
+	selected := make(map[string]string)
+	for _, name := range names {
+		values := header.Values(name)
+		if name == "Link" {
+			values = nil
+			for _, link := range parseLinkHeader(header.Values("Link")) {
+				if hasRel(link.rel, responseHeaderLinkRels...) {
+					values = append(values, link.raw)
+				}
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		value := strings.Join(values, ", ")
+		if len(value) > maxResponseHeaderBytes {
+			value = truncateUTF8(value, maxResponseHeaderBytes)
+		}
+		selected[name] = value
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+	return selected
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	for n > 0 && n < len(s) && s[n]&0xc0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
+
+// linkHeader is one link of a Link header (RFC 8288)
+type linkHeader struct {
+	url string
+	rel string
+	raw string
+}
+
+// parseLinkHeader splits Link header values into their links. URLs may
+// contain commas, so links are split on the commas between them.
+func parseLinkHeader(values []string) []linkHeader {
+	var links []linkHeader
+	for _, value := range values {
+		for {
+			start := strings.IndexByte(value, '<')
+			if start < 0 {
+				break
+			}
+			end := strings.IndexByte(value[start:], '>')
+			if end < 0 {
+				break
+			}
+			end += start
+			link := linkHeader{url: strings.TrimSpace(value[start+1 : end])}
+
+			// Parameters run to the next comma outside quotes
+			params := value[end+1:]
+			next, inQuotes := len(params), false
+			for i := 0; i < len(params); i++ {
+				if params[i] == '"' {
+					inQuotes = !inQuotes
+				} else if params[i] == ',' && !inQuotes {
+					next = i
+					break
+				}
+			}
+			for _, param := range strings.Split(params[:next], ";") {
+				key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if strings.EqualFold(strings.TrimSpace(key), "rel") {
+					link.rel = strings.ToLower(strings.Trim(strings.TrimSpace(val), `"`))
+				}
+			}
+			link.raw = strings.TrimSpace(value[start : end+1+next])
+			links = append(links, link)
+
+			if next == len(params) {
+				break
+			}
+			value = params[next+1:]
+		}
+	}
+	return links
+}
+
+// hasRel reports whether the space-separated relation list rel contains one
+// of want
+func hasRel(rel string, want ...string) bool {
+	for _, r := range strings.Fields(rel) {
+		for _, w := range want {
+			if r == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyLinkHeaders fills the canonical URL, icons and preconnect hosts from
+// Link headers, for sites that only send them that way. Links in the HTML
+// take precedence.
+func applyLinkHeaders(header http.Header, metadata *MetadataResponse, baseURL *url.URL) {
+	for _, link := range parseLinkHeader(header.Values("Link")) {
+		if link.url == "" {
+			continue
+		}
+		switch {
+		case hasRel(link.rel, "canonical"):
+			if metadata.Canonical == "" {
+				metadata.Canonical = resolveURL(link.url, baseURL)
+				noteSource(metadata, "canonical", "Link header")
+			}
+		case hasRel(link.rel, "preconnect", "dns-prefetch"):
+			addPreconnectHost(metadata, link.url, baseURL)
+		case hasRel(link.rel, "icon", "apple-touch-icon"):
+			icon := IconLink{URL: resolveURL(link.url, baseURL), Rel: link.rel}
+			metadata.Icons = append(metadata.Icons, icon)
+			if metadata.Favicon == "" {
+				metadata.Favicon = icon.URL
+				noteSource(metadata, "favicon", "Link header")
+			}
+		}
+	}
+}
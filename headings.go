@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// maxHeadings caps the outline returned by extract_headings
+	maxHeadings = 100
+
+	// maxHeadingBytes caps the text of each heading
+	maxHeadingBytes = 200
+
+	// maxHeadingNodes caps how much of the document is walked looking for
+	// headings
+	maxHeadingNodes = 100000
+)
+
+// Heading is one entry of a page's outline. Level is 1 to 3, for h1 to h3.
+type Heading struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+}
+
+// headingLevels maps the outlined heading elements to their level
+var headingLevels = map[atom.Atom]int{atom.H1: 1, atom.H2: 2, atom.H3: 3}
+
+// extractHeadings returns the h1 to h3 headings of doc in document order,
+// leaving out those in navigation, footers and asides, which aren't part of
+// the page's own structure
+func extractHeadings(doc *html.Node) []Heading {
+	var headings []Heading
+	visited := 0
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if len(headings) >= maxHeadings || visited >= maxHeadingNodes {
+			return
+		}
+		visited++
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Nav, atom.Footer, atom.Aside, atom.Script, atom.Style, atom.Template:
+				return
+			}
+			if level, ok := headingLevels[n.DataAtom]; ok {
+				if text := headingText(n); text != "" {
+					headings = append(headings, Heading{Level: level, Text: text})
+				}
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return headings
+}
+
+// headingText returns the text of a heading with whitespace collapsed. Text
+// nodes are joined as they are, since the markup between them is usually
+// inline; only <br> separates words.
+func headingText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			b.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.DataAtom == atom.Br:
+			b.WriteString(" ")
+		case n.Type == html.ElementNode && (n.DataAtom == atom.Script || n.DataAtom == atom.Style):
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	text := strings.Join(strings.Fields(b.String()), " ")
+	if len(text) > maxHeadingBytes {
+		text = truncateUTF8(text, maxHeadingBytes)
+	}
+	return text
+}
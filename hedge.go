@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// hedges counts hedged requests for /admin/stats
+var hedges hedgeCounters
+
+type hedgeCounters struct {
+	launched atomic.Int64
+	won      atomic.Int64
+}
+
+// HedgeStats reports how often hedged requests were sent and how often they
+// answered before the original
+type HedgeStats struct {
+	Launched int64 `json:"launched"`
+	Won      int64 `json:"won"`
+}
+
+func (c *hedgeCounters) stats() HedgeStats {
+	return HedgeStats{Launched: c.launched.Load(), Won: c.won.Load()}
+}
+
+// hedgeAttempt is the outcome of one copy of a hedged request; index is 0
+// for the original and 1 for the hedge
+type hedgeAttempt struct {
+	index   int
+	resp    *http.Response
+	err     error
+	release func()
+}
+
+// sendHedged sends req, holding the host slot released by release. When
+// HEDGE_AFTER passes without response headers and another slot for the host
+// is free, a second copy is sent over a new connection and whichever answers
+// first is used; the other is cancelled. The returned release frees the slot
+// held by the response, and must be called even when err is non-nil.
+func sendHedged(cfg *Config, client *http.Client, req *http.Request, release func()) (*http.Response, func(), error) {
+	if cfg.HedgeAfter <= 0 {
+		resp, err := client.Do(req)
+		return resp, release, err
+	}
+
+	results := make(chan hedgeAttempt, 2)
+	var cancels [2]context.CancelFunc
+	hedgeClient := *client
+	hedgeClient.Transport = hedgeTransport
+	clients := [2]*http.Client{client, &hedgeClient}
+	send := func(index int, release func()) {
+		ctx, cancel := context.WithCancel(req.Context())
+		cancels[index] = cancel
+		go func() {
+			resp, err := clients[index].Do(req.Clone(ctx))
+			results <- hedgeAttempt{index: index, resp: resp, err: err, release: release}
+		}()
+	}
+	send(0, release)
+
+	timer := time.NewTimer(cfg.HedgeAfter)
+	defer timer.Stop()
+
+	host := req.URL.Hostname()
+	pending := 1
+	for {
+		select {
+		case <-timer.C:
+			// A hedge counts against the host's limit like any request, and
+			// isn't sent when that would mean waiting for a slot
+			hedgeRelease, ok := hostLimits.tryAcquire(host, cfg.MaxRequestsPerHost)
+			if !ok {
+				continue
+			}
+			hedges.launched.Add(1)
+			log.Printf("🐢 No response from %s after %s, sending a hedged request\n", host, cfg.HedgeAfter)
+			pending++
+			send(1, hedgeRelease)
+
+		case a := <-results:
+			pending--
+			if a.err != nil {
+				cancels[a.index]()
+				if pending > 0 {
+					// The other copy may still succeed
+					a.release()
+					continue
+				}
+				return nil, a.release, a.err
+			}
+
+			if pending > 0 {
+				// Cancel the loser, and free its slot once it has returned
+				cancels[1-a.index]()
+				go func() {
+					loser := <-results
+					if loser.resp != nil {
+						loser.resp.Body.Close()
+					}
+					loser.release()
+				}()
+			}
+			if a.index == 1 {
+				hedges.won.Add(1)
+			}
+			a.resp.Body = &cancelOnClose{ReadCloser: a.resp.Body, cancel: cancels[a.index]}
+			return a.resp, a.release, nil
+		}
+	}
+}
+
+// cancelOnClose ends a hedged attempt's context once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
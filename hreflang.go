@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// How follow_hreflang matched the requested language to an alternate
+const (
+	hreflangExact    = "exact"     // The same tag, such as de-AT for de-AT
+	hreflangLanguage = "language"  // The same language, such as de-DE for de
+	hreflangDefault  = "x-default" // The page's fallback for other languages
+)
+
+// Why follow_hreflang returned the page as it was
+const (
+	hreflangNoMatch  = "no_match" // No alternate matches the language, nor is there an x-default
+	hreflangSameURL  = "same_url" // The page is itself the matching alternate
+	hreflangUnusable = "unusable" // The alternate looks like an error or parked page, or was cut short
+	hreflangFailed   = "failed"   // The alternate couldn't be extracted
+)
+
+// maxHreflangLinks caps the number of language editions taken from a page
+const maxHreflangLinks = 200
+
+// hreflangURLSchemes are the schemes a language edition's URL may have
+var hreflangURLSchemes = []string{"http", "https"}
+
+// langTagPattern accepts language tags such as de, de-AT and zh-Hant-TW,
+// also written with underscores
+var langTagPattern = regexp.MustCompile(`^[A-Za-z]{2,8}([-_][A-Za-z0-9]{1,8})*$`)
+
+// HreflangLink is a language edition of the page, from
+// <link rel="alternate" hreflang="...">
+type HreflangLink struct {
+	Lang string `json:"lang"`
+	URL  string `json:"url"`
+}
+
+// HreflangFollow records what follow_hreflang did: the URL asked for, and
+// either the alternate extracted instead and how it matched, or why the
+// page was returned as it was
+type HreflangFollow struct {
+	RequestedURL string `json:"requested_url"`
+	Lang         string `json:"lang"`
+	Matched      string `json:"matched,omitempty"`
+	MatchedLang  string `json:"matched_lang,omitempty"`
+	URL          string `json:"url,omitempty"`
+	Followed     bool   `json:"followed"`
+	Skipped      string `json:"skipped,omitempty"`
+}
+
+// normalizeLangTag lowercases a language tag and accepts _ for -
+func normalizeLangTag(tag string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+}
+
+// addHreflangLink records a language edition the page links to. Only the
+// first link for a language is kept, as only it can be matched, and links
+// that aren't http(s) are dropped.
+func addHreflangLink(metadata *MetadataResponse, lang, href string, baseURL *url.URL) {
+	lang = normalizeLangTag(lang)
+	if lang == "" || href == "" || len(metadata.Hreflang) >= maxHreflangLinks {
+		return
+	}
+	for _, link := range metadata.Hreflang {
+		if link.Lang == lang {
+			return
+		}
+	}
+	resolved := resolveURL(href, baseURL)
+	if !validAssetURL(resolved, hreflangURLSchemes) {
+		return
+	}
+	metadata.Hreflang = append(metadata.Hreflang, HreflangLink{Lang: lang, URL: resolved})
+}
+
+// matchHreflang picks the alternate for lang: one with the same tag, then
+// one in the same language, preferring the language on its own over a
+// regional edition, then x-default. The first of equals wins.
+func matchHreflang(links []HreflangLink, lang string) (HreflangLink, string, bool) {
+	lang = normalizeLangTag(lang)
+	primary, _, _ := strings.Cut(lang, "-")
+
+	var language, fallback *HreflangLink
+	for i := range links {
+		link := &links[i]
+		linkPrimary, _, _ := strings.Cut(link.Lang, "-")
+		switch {
+		case link.Lang == lang:
+			return *link, hreflangExact, true
+		case linkPrimary == primary && (language == nil || (link.Lang == primary && language.Lang != primary)):
+			language = link
+		case link.Lang == hreflangDefault && fallback == nil:
+			fallback = link
+		}
+	}
+	switch {
+	case language != nil:
+		return *language, hreflangLanguage, true
+	case fallback != nil:
+		return *fallback, hreflangDefault, true
+	}
+	return HreflangLink{}, "", false
+}
+
+// followHreflang extracts the edition of the page in opts.Lang and returns
+// it in place of metadata, or metadata itself, noted, when there is none
+// or it can't be had. Like follow_canonical it goes one level deep.
+func followHreflang(ctx context.Context, cfg *Config, metadata *MetadataResponse, opts ExtractOptions) *MetadataResponse {
+	start := time.Now()
+	follow := &HreflangFollow{RequestedURL: metadata.URL, Lang: normalizeLangTag(opts.Lang)}
+	link, matched, ok := matchHreflang(metadata.Hreflang, opts.Lang)
+	if !ok {
+		follow.Skipped = hreflangNoMatch
+		metadata.HreflangFollow = follow
+		return metadata
+	}
+	follow.Matched, follow.MatchedLang, follow.URL = matched, link.Lang, link.URL
+	if sameResource(cfg, metadata.URL, link.URL) {
+		follow.Skipped = hreflangSameURL
+		metadata.HreflangFollow = follow
+		return metadata
+	}
+
+	// The alternate is extracted like any other, SSRF checks, host limits
+	// and cache included, within what is left of this one's time
+	alternateOpts := opts
+	alternateOpts.FollowHreflang = false
+	alternate, err := cachedMetadata(ctx, cfg, link.URL, alternateOpts)
+	if err != nil {
+		follow.Skipped = hreflangFailed
+		metadata.HreflangFollow = follow
+		addWarning(metadata, warnHreflangNotFollowed, fmt.Sprintf("%s alternate %.200q not followed: %v", link.Lang, link.URL, err))
+		return metadata
+	}
+	if alternate.Soft404 || alternate.Parked || alternate.Partial {
+		follow.Skipped = hreflangUnusable
+		metadata.HreflangFollow = follow
+		return metadata
+	}
+	follow.Followed = true
+	alternate.HreflangFollow = follow
+	alternate.Duration = metadata.Duration + time.Since(start).Milliseconds()
+	return alternate
+}
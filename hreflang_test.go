@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAddHreflangLink(t *testing.T) {
+	base := mustParseURL(t, "https://example.com/en/page")
+	metadata := &MetadataResponse{}
+	addHreflangLink(metadata, "de_AT", "/de-at/page", base)
+	addHreflangLink(metadata, "de-at", "/other/page", base)
+	addHreflangLink(metadata, "fr", "javascript:alert(1)", base)
+	addHreflangLink(metadata, "es", "ftp://example.com/es", base)
+	addHreflangLink(metadata, "x-default", "https://example.com/", base)
+
+	want := []HreflangLink{
+		{Lang: "de-at", URL: "https://example.com/de-at/page"},
+		{Lang: "x-default", URL: "https://example.com/"},
+	}
+	if len(metadata.Hreflang) != len(want) {
+		t.Fatalf("hreflang = %v, want %v", metadata.Hreflang, want)
+	}
+	for i := range want {
+		if metadata.Hreflang[i] != want[i] {
+			t.Errorf("hreflang[%d] = %v, want %v", i, metadata.Hreflang[i], want[i])
+		}
+	}
+
+	metadata = &MetadataResponse{}
+	for i := 0; i < maxHreflangLinks+10; i++ {
+		addHreflangLink(metadata, fmt.Sprintf("x%d", i), fmt.Sprintf("/%d", i), base)
+	}
+	if len(metadata.Hreflang) != maxHreflangLinks {
+		t.Errorf("got %d links, want the cap of %d", len(metadata.Hreflang), maxHreflangLinks)
+	}
+}
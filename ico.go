@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	// icoHeaderBytes is the size of an ICO file's header, and
+	// icoEntryBytes of each of its directory entries
+	icoHeaderBytes = 6
+	icoEntryBytes  = 16
+)
+
+// faviconProbeResult is the outcome of reading an ICO favicon's directory
+type faviconProbeResult struct {
+	sizes []string
+	err   error
+}
+
+// faviconProbeURL is the URL of the favicon to probe, or nil for icons that
+// aren't ICO files, whose single size is up to the page to declare
+func faviconProbeURL(iconURL string) *url.URL {
+	u, err := url.Parse(iconURL)
+	if err != nil || !strings.EqualFold(path.Ext(u.Path), ".ico") {
+		return nil
+	}
+	return u
+}
+
+// applyFaviconProbe sets the probed sizes, or a warning when the probe failed
+func applyFaviconProbe(metadata *MetadataResponse, result faviconProbeResult) {
+	if result.err != nil {
+		addWarning(metadata, warnFaviconNotProbed, fmt.Sprintf("favicon not probed: %v", result.err))
+		return
+	}
+	metadata.FaviconSizes = result.sizes
+}
+
+// fetchICOSizes downloads just enough of an ICO file to read its directory,
+// validating the URL against SSRF rules first
+func fetchICOSizes(ctx context.Context, cfg *Config, iconURL *url.URL) ([]string, error) {
+	if err := validateURLForSSRF(cfg, iconURL); err != nil {
+		return nil, err
+	}
+
+	resp, release, err := fetchURL(ctx, cfg, iconURL, http.Header{"Accept": {"image/*"}})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP error: %d", resp.StatusCode)
+	}
+
+	header := make([]byte, icoHeaderBytes)
+	if _, err := io.ReadFull(resp.Body, header); err != nil {
+		return nil, fmt.Errorf("not an ICO file")
+	}
+	// Reserved 0, then type 1 for icons
+	if binary.LittleEndian.Uint16(header[0:]) != 0 || binary.LittleEndian.Uint16(header[2:]) != 1 {
+		return nil, fmt.Errorf("not an ICO file")
+	}
+	count := int(binary.LittleEndian.Uint16(header[4:]))
+	if count == 0 {
+		return nil, fmt.Errorf("ICO file has no images")
+	}
+
+	entries := make([]byte, count*icoEntryBytes)
+	if _, err := io.ReadFull(resp.Body, entries); err != nil {
+		return nil, fmt.Errorf("ICO directory cut short")
+	}
+	return icoSizes(entries), nil
+}
+
+// icoSizes lists the distinct sizes, such as "16x16", of an ICO directory's
+// entries in the order the file has them
+func icoSizes(entries []byte) []string {
+	var sizes []string
+	seen := make(map[string]bool)
+	for i := 0; i+icoEntryBytes <= len(entries); i += icoEntryBytes {
+		// A width or height of 0 stands for 256
+		width, height := int(entries[i]), int(entries[i+1])
+		if width == 0 {
+			width = 256
+		}
+		if height == 0 {
+			height = 256
+		}
+		size := fmt.Sprintf("%dx%d", width, height)
+		if !seen[size] {
+			seen[size] = true
+			sizes = append(sizes, size)
+		}
+	}
+	return sizes
+}
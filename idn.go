@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// normalizeDomain returns host, which may carry a port, in its lowercase
+// ASCII (punycode) form and in its Unicode form. Hosts that aren't valid
+// IDNs, such as IP addresses, are only lowercased.
+func normalizeDomain(host string) (ascii, unicode string) {
+	hostname, port := host, ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		hostname, port = h, p
+	}
+	hostname = strings.ToLower(hostname)
+
+	ascii, unicode = hostname, hostname
+	if !isIPHost(hostname) {
+		if a, err := idna.Lookup.ToASCII(hostname); err == nil {
+			ascii = a
+		}
+		if u, err := idna.Lookup.ToUnicode(ascii); err == nil {
+			unicode = u
+		}
+	}
+	if port != "" {
+		return net.JoinHostPort(ascii, port), net.JoinHostPort(unicode, port)
+	}
+	return ascii, unicode
+}
+
+// isIPHost reports whether host, without brackets or port, is an IP literal
+// rather than a name
+func isIPHost(host string) bool {
+	return net.ParseIP(host) != nil
+}
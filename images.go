@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultMaxImages is the number of candidates verified when max_images is unset
+	defaultMaxImages = 3
+
+	// maxImagesLimit caps max_images
+	maxImagesLimit = 10
+
+	// imageProbeBytes is how much of an image we read to find its dimensions
+	imageProbeBytes = 64 * 1024
+)
+
+// ImageInfo describes a single image candidate. Width, Height and Type are what
+// the page declared; the Verified fields are filled in by verify_images.
+type ImageInfo struct {
+	URL            string `json:"url"`
+	Width          int    `json:"width,omitempty"`
+	Height         int    `json:"height,omitempty"`
+	Type           string `json:"type,omitempty"`
+	Alt            string `json:"alt,omitempty"`
+	VerifiedWidth  int    `json:"verified_width,omitempty"`
+	VerifiedHeight int    `json:"verified_height,omitempty"`
+	VerifiedType   string `json:"verified_type,omitempty"`
+	VerifiedBytes  int64  `json:"verified_bytes,omitempty"`
+	DominantColor  string `json:"dominant_color,omitempty"`
+	ProxyURL       string `json:"proxy_url,omitempty"`
+	Insecure       bool   `json:"insecure,omitempty"` // Served over HTTP to an HTTPS page
+	Source         string `json:"source,omitempty"`   // Where the page declared it, with include_sources
+}
+
+// errNotImage marks a candidate that should be dropped from the results
+var errNotImage = errors.New("not an image")
+
+// addImage records an image candidate in both the flat and detailed lists,
+// along with the tag it came from
+func addImage(metadata *MetadataResponse, imageURL, source string) {
+	metadata.Images = append(metadata.Images, imageURL)
+	metadata.ImageDetails = append(metadata.ImageDetails, ImageInfo{URL: imageURL, Source: source})
+}
+
+// markMixedContent flags the images of an HTTPS page that are served over
+// HTTP, which browsers block
+func markMixedContent(metadata *MetadataResponse) {
+	if metadata.FinalScheme != "https" {
+		return
+	}
+	for i := range metadata.ImageDetails {
+		if u, err := url.Parse(metadata.ImageDetails[i].URL); err == nil && u.Scheme == "http" {
+			metadata.ImageDetails[i].Insecure = true
+			metadata.HasMixedContent = true
+		}
+	}
+}
+
+// prependImage makes img the primary image, removing any other candidate
+// with its URL
+func prependImage(metadata *MetadataResponse, img ImageInfo) {
+	images := []string{img.URL}
+	details := []ImageInfo{img}
+	for _, other := range metadata.ImageDetails {
+		if other.URL != img.URL {
+			images = append(images, other.URL)
+			details = append(details, other)
+		}
+	}
+	metadata.Images, metadata.ImageDetails = images, details
+}
+
+// lastImage returns the most recently added candidate, which og:image:*
+// structured properties apply to
+func lastImage(metadata *MetadataResponse) *ImageInfo {
+	if len(metadata.ImageDetails) == 0 {
+		return nil
+	}
+	return &metadata.ImageDetails[len(metadata.ImageDetails)-1]
+}
+
+// verifyImages probes up to max image candidates concurrently and annotates
+// them with their real dimensions. Candidates that 404 or turn out not to be
+// images are dropped; candidates that could not be checked are kept as-is.
+func verifyImages(ctx context.Context, cfg *Config, metadata *MetadataResponse, max int) {
+	if max <= 0 {
+		max = defaultMaxImages
+	}
+	if max > maxImagesLimit {
+		max = maxImagesLimit
+	}
+	if max > len(metadata.ImageDetails) {
+		max = len(metadata.ImageDetails)
+	}
+
+	drop := make([]bool, max)
+	var wg sync.WaitGroup
+	for i := 0; i < max; i++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			img := &metadata.ImageDetails[idx]
+			if err := probeImage(ctx, cfg, img); errors.Is(err, errNotImage) {
+				drop[idx] = true
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	details := metadata.ImageDetails[:0]
+	images := []string{}
+	for i, img := range metadata.ImageDetails {
+		if i < len(drop) && drop[i] {
+			continue
+		}
+		details = append(details, img)
+		images = append(images, img.URL)
+	}
+	metadata.ImageDetails = details
+	metadata.Images = images
+}
+
+// probeImage fetches the first bytes of an image and decodes its header
+func probeImage(ctx context.Context, cfg *Config, img *ImageInfo) error {
+	parsedURL, err := url.Parse(img.URL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return errNotImage
+	}
+	if err := validateURLForSSRF(cfg, parsedURL); err != nil {
+		return err
+	}
+
+	resp, release, err := fetchURL(ctx, cfg, parsedURL, http.Header{
+		"Accept": {"image/*"},
+		"Range":  {fmt.Sprintf("bytes=0-%d", imageProbeBytes-1)},
+	})
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return errNotImage
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent:
+		return fmt.Errorf("HTTP error: %d", resp.StatusCode)
+	}
+
+	head, err := io.ReadAll(io.LimitReader(resp.Body, imageProbeBytes))
+	if err != nil && len(head) == 0 {
+		return err
+	}
+
+	img.VerifiedBytes = imageSize(resp)
+
+	if width, height, format, ok := decodeImageHeader(head); ok {
+		img.VerifiedWidth = width
+		img.VerifiedHeight = height
+		img.VerifiedType = "image/" + format
+		return nil
+	}
+
+	// Formats we can't decode (SVG, AVIF, ...) are still images if the origin says so
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "image/") {
+		return errNotImage
+	}
+	img.VerifiedType = mediaType
+	return nil
+}
+
+// imageSize returns the full size of the image from Content-Range or
+// Content-Length, or 0 when the origin didn't say
+func imageSize(resp *http.Response) int64 {
+	if cr := resp.Header.Get("Content-Range"); cr != "" {
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			if n, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				return n
+			}
+		}
+	}
+	if resp.StatusCode == http.StatusOK && resp.ContentLength > 0 {
+		return resp.ContentLength
+	}
+	return 0
+}
+
+// decodeImageHeader returns the dimensions and format of a JPEG, PNG, GIF or
+// WebP image from its leading bytes
+func decodeImageHeader(head []byte) (int, int, string, bool) {
+	if width, height, ok := decodeWebPHeader(head); ok {
+		return width, height, "webp", true
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(head))
+	if err != nil {
+		return 0, 0, "", false
+	}
+	return cfg.Width, cfg.Height, format, true
+}
+
+// decodeWebPHeader reads the canvas size from the first chunk of a WebP file
+func decodeWebPHeader(b []byte) (int, int, bool) {
+	if len(b) < 30 || string(b[0:4]) != "RIFF" || string(b[8:12]) != "WEBP" {
+		return 0, 0, false
+	}
+
+	switch string(b[12:16]) {
+	case "VP8 ":
+		// Lossy: frame tag, start code, then 14-bit dimensions
+		if b[23] != 0x9d || b[24] != 0x01 || b[25] != 0x2a {
+			return 0, 0, false
+		}
+		width := int(binary.LittleEndian.Uint16(b[26:28]) & 0x3fff)
+		height := int(binary.LittleEndian.Uint16(b[28:30]) & 0x3fff)
+		return width, height, true
+	case "VP8L":
+		// Lossless: signature byte, then 14-bit width-1 and height-1
+		if b[20] != 0x2f {
+			return 0, 0, false
+		}
+		bits := binary.LittleEndian.Uint32(b[21:25])
+		return int(bits&0x3fff) + 1, int((bits>>14)&0x3fff) + 1, true
+	case "VP8X":
+		// Extended: 24-bit canvas width-1 and height-1
+		width := int(b[24]) | int(b[25])<<8 | int(b[26])<<16
+		height := int(b[27]) | int(b[28])<<8 | int(b[29])<<16
+		return width + 1, height + 1, true
+	}
+
+	return 0, 0, false
+}
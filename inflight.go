@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// backpressureRetryAfter is the Retry-After, in seconds, sent once the
+// number of requests in flight passes INFLIGHT_SOFT_LIMIT or INFLIGHT_LIMIT
+const backpressureRetryAfter = "1"
+
+// inflight counts the requests being served
+var inflight inflightCounter
+
+type inflightCounter struct {
+	current  atomic.Int64
+	rejected atomic.Int64
+}
+
+// InflightStats reports the requests being served and those turned away at
+// INFLIGHT_LIMIT
+type InflightStats struct {
+	Current  int64 `json:"current"`
+	Rejected int64 `json:"rejected"`
+}
+
+func (c *inflightCounter) stats() InflightStats {
+	return InflightStats{Current: c.current.Load(), Rejected: c.rejected.Load()}
+}
+
+// inflightMiddleware reports the server's load on every response so clients
+// can slow down before they are refused: X-Inflight always, X-RateLimit-Limit
+// and X-RateLimit-Remaining with INFLIGHT_LIMIT, and Retry-After on
+// successful responses past INFLIGHT_SOFT_LIMIT. Requests past INFLIGHT_LIMIT
+// get a 503. Health checks and preflights are not counted.
+func inflightMiddleware(store *configStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cfg := store.Load()
+		n := inflight.current.Add(1)
+		defer inflight.current.Add(-1)
+
+		h := w.Header()
+		h.Set("X-Inflight", strconv.FormatInt(n, 10))
+		if limit := int64(cfg.InflightLimit); limit > 0 {
+			h.Set("X-RateLimit-Limit", strconv.FormatInt(limit, 10))
+			h.Set("X-RateLimit-Remaining", strconv.FormatInt(max(limit-n, 0), 10))
+			if n > limit {
+				inflight.rejected.Add(1)
+				h.Set("Retry-After", backpressureRetryAfter)
+				writeError(w, r, codeOverloaded, "server is at capacity, retry later")
+				return
+			}
+		}
+		if soft := int64(cfg.InflightSoftLimit); soft > 0 && n > soft {
+			h.Set("Retry-After", backpressureRetryAfter)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
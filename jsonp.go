@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+)
+
+// maxCallbackLength caps the name of a JSONP callback
+const maxCallbackLength = 128
+
+// validCallback is the only shape of JSONP callback accepted: a name or a
+// dotted path to one, which can't close the call or start another statement
+var validCallback = regexp.MustCompile(`^[A-Za-z0-9_$.]+$`)
+
+// jsonpWriter marks a response that writeJSON wraps in a call to callback,
+// for clients that can only load scripts, as asked for with ?callback= when
+// ENABLE_JSONP is set
+type jsonpWriter struct {
+	http.ResponseWriter
+	callback string
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w jsonpWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// checkCallback validates a requested JSONP callback
+func checkCallback(cfg *Config, callback string) error {
+	if !cfg.EnableJSONP {
+		return errors.New("'callback' is not supported; JSONP is disabled")
+	}
+	if len(callback) > maxCallbackLength || !validCallback.MatchString(callback) {
+		return errors.New("'callback' must only contain letters, digits, '_', '$' and '.'")
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
+)
+
+// LinkStats counts the links on a page. Internal links point at the page's
+// own registrable domain, including its other subdomains.
+type LinkStats struct {
+	Internal int `json:"internal"`
+	External int `json:"external"`
+	Total    int `json:"total"`
+}
+
+// registrableDomain returns the registrable domain of host (example.co.uk for
+// www.example.co.uk), or host itself for IPs and the like. IPs are checked
+// first: the public suffix list would take the last byte of an IPv4 address
+// for a TLD.
+func registrableDomain(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if isIPHost(host) {
+		return host
+	}
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return domain
+	}
+	return host
+}
+
+// countLink adds an <a> element to stats. Only links to http(s) URLs are
+// counted; mailto:, javascript: and the like are not links to pages.
+func countLink(n *html.Node, stats *LinkStats, baseURL *url.URL) {
+	var href string
+	for _, attr := range n.Attr {
+		if attr.Key == "href" {
+			href = strings.TrimSpace(attr.Val)
+			break
+		}
+	}
+	if href == "" {
+		return
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return
+	}
+	target := baseURL.ResolveReference(ref)
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return
+	}
+
+	stats.Total++
+	if registrableDomain(target.Hostname()) == registrableDomain(baseURL.Hostname()) {
+		stats.Internal++
+	} else {
+		stats.External++
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -11,72 +15,223 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"golang.org/x/net/html"
+	"golang.org/x/text/transform"
 )
 
 type MetadataResponse struct {
-	Title       string   `json:"title"`
-	Description string   `json:"description"`
-	Images      []string `json:"images"`
-	SiteName    []string `json:"sitename"`
-	Favicon     string   `json:"favicon"`
-	Duration    int64    `json:"duration"`
-	Domain      string   `json:"domain"`
-	URL         string   `json:"url"`
+	Title                string                 `json:"title"`
+	TitleClean           string                 `json:"title_clean,omitempty"`
+	TitleCandidates      []TitleCandidate       `json:"title_candidates,omitempty"`
+	Headings             []Heading              `json:"headings,omitempty"`
+	Description          string                 `json:"description"`
+	RawMeta              *RawMeta               `json:"raw_meta,omitempty"`
+	Images               []string               `json:"images"`
+	ImageDetails         []ImageInfo            `json:"image_details,omitempty"`
+	HasMixedContent      bool                   `json:"has_mixed_content,omitempty"`
+	SiteName             []string               `json:"sitename"`
+	Favicon              string                 `json:"favicon"`
+	FaviconData          string                 `json:"favicon_data,omitempty"`
+	FaviconSizes         []string               `json:"favicon_sizes,omitempty"`
+	Icons                []IconLink             `json:"icons,omitempty"`
+	PreconnectHosts      []string               `json:"preconnect_hosts,omitempty"`
+	Duration             int64                  `json:"duration"`
+	Cached               bool                   `json:"cached,omitempty"`
+	CachedAt             string                 `json:"cached_at,omitempty"`
+	Age                  *int64                 `json:"age,omitempty"`
+	Domain               string                 `json:"domain"`
+	DomainUnicode        string                 `json:"domain_unicode"`
+	IsIP                 bool                   `json:"is_ip,omitempty"`
+	URL                  string                 `json:"url"`
+	Canonical            string                 `json:"canonical,omitempty"`
+	CanonicalFollow      *CanonicalFollow       `json:"canonical_follow,omitempty"`
+	Hreflang             []HreflangLink         `json:"hreflang,omitempty"`
+	HreflangFollow       *HreflangFollow        `json:"hreflang_follow,omitempty"`
+	ResponseHeaders      map[string]string      `json:"response_headers,omitempty"`
+	ResolvedIPs          []string               `json:"resolved_ips,omitempty"`
+	ASN                  []ASNInfo              `json:"asn,omitempty"`
+	HSTS                 bool                   `json:"hsts"`
+	HSTSMaxAge           int64                  `json:"hsts_max_age,omitempty"`
+	TLS                  *TLSInfo               `json:"tls,omitempty"`
+	Transfer             *Transfer              `json:"transfer,omitempty"`
+	Charset              string                 `json:"charset,omitempty"`
+	CharsetSource        string                 `json:"charset_source,omitempty"`
+	DecodingReplacements int                    `json:"decoding_replacements,omitempty"`
+	UserAgent            string                 `json:"user_agent"`
+	ContentHash          string                 `json:"content_hash,omitempty"`
+	ContentHashPartial   bool                   `json:"content_hash_partial,omitempty"`
+	MetadataHash         string                 `json:"metadata_hash,omitempty"`
+	FinalScheme          string                 `json:"final_scheme"`
+	Downgraded           bool                   `json:"downgraded"`
+	Security             *SecurityInfo          `json:"security,omitempty"`
+	CookiesReplayed      bool                   `json:"cookies_replayed,omitempty"`
+	ConsentWall          bool                   `json:"consent_wall_detected,omitempty"`
+	Parked               bool                   `json:"parked,omitempty"`
+	ParkedSignal         string                 `json:"parked_signal,omitempty"`
+	Soft404              bool                   `json:"soft_404,omitempty"`
+	Partial              bool                   `json:"partial,omitempty"`
+	ContentRating        *ContentRating         `json:"content_rating,omitempty"`
+	Paywalled            string                 `json:"paywalled"`
+	PaywallSource        string                 `json:"paywall_source,omitempty"`
+	PaywallHeuristic     bool                   `json:"paywall_heuristic,omitempty"`
+	Screenshot           string                 `json:"screenshot,omitempty"`
+	OEmbedURL            string                 `json:"oembed_url,omitempty"`
+	OEmbedFormat         string                 `json:"oembed_format,omitempty"`
+	OEmbed               *OEmbed                `json:"oembed,omitempty"`
+	Video                *Video                 `json:"video,omitempty"`
+	Quality              *Quality               `json:"quality,omitempty"`
+	Publisher            *Publisher             `json:"publisher,omitempty"`
+	AppLinks             *AppLinks              `json:"app_links,omitempty"`
+	ProviderData         map[string]interface{} `json:"provider_data,omitempty"`
+	Colors               *ImageColors           `json:"colors,omitempty"`
+	LinkStats            *LinkStats             `json:"link_stats,omitempty"`
+	Warnings             []string               `json:"warnings,omitempty"`
+	WarningDetails       []Warning              `json:"warning_details,omitempty"`
+	Sources              map[string]string      `json:"sources,omitempty"`
+	EffectiveConfig      *EffectiveConfig       `json:"effective_config,omitempty"`
+	Debug                *DebugInfo             `json:"debug,omitempty"`
 }
 
 type MetadataRequest struct {
-	URL  string   `json:"url,omitempty"`  // Single URL (deprecated, use URLs)
-	URLs []string `json:"urls,omitempty"` // Batch URLs (up to 5)
+	URL  string     `json:"url,omitempty"`  // Single URL (deprecated, use URLs)
+	URLs []BatchURL `json:"urls,omitempty"` // Batch URLs (up to 5), each with its own options if needed
+
+	// MinSuccessRatio is the share of a batch's URLs that must succeed for
+	// it to be answered with 200
+	MinSuccessRatio float64 `json:"min_success_ratio,omitempty"`
+	ExtractOptions
+}
+
+// ExtractOptions holds the optional, per-request extraction settings.
+type ExtractOptions struct {
+	VerifyImages           bool              `json:"verify_images,omitempty"`            // Probe image candidates for real dimensions
+	MaxImages              int               `json:"max_images,omitempty"`               // Number of candidates to verify (default 3, max 10)
+	ExtractColors          bool              `json:"extract_colors,omitempty"`           // Compute the primary image's dominant color and palette
+	ProbeImageColor        bool              `json:"probe_image_color,omitempty"`        // Add the primary image's dominant color to image_details
+	ScreenshotFallback     bool              `json:"screenshot_fallback,omitempty"`      // Reference a screenshot URL when no image was found
+	BodyImages             bool              `json:"body_images,omitempty"`              // Add <img> elements from the page body as image candidates
+	UserAgent              string            `json:"user_agent,omitempty"`               // User-Agent for fetching the page, instead of one from the pool
+	Headers                map[string]string `json:"headers,omitempty"`                  // Extra request headers for fetching the page
+	TimeoutMs              *int64            `json:"timeout_ms,omitempty"`               // Time allowed for the extraction, instead of the whole fetch budget
+	IncludeTitleCandidates bool              `json:"include_title_candidates,omitempty"` // Also return every title the page declares
+	CleanTitle             bool              `json:"clean_title,omitempty"`              // Also return the title without a trailing site name
+	FollowCanonical        bool              `json:"follow_canonical,omitempty"`         // Take the content fields from the page's canonical URL
+	FollowHreflang         bool              `json:"follow_hreflang,omitempty"`          // Extract the page's edition in Lang instead
+	Lang                   string            `json:"lang,omitempty"`                     // Language tag for follow_hreflang, such as de or de-AT
+	IncludeRawMeta         bool              `json:"include_raw_meta,omitempty"`         // Also return the title and description before sanitizing
+	IncludeSources         bool              `json:"include_sources,omitempty"`          // Also return where each field came from
+	SecurityInfo           bool              `json:"security_info,omitempty"`            // Summarize the page's security headers
+	ExtractHeadings        bool              `json:"extract_headings,omitempty"`         // Return the page's h1 to h3 outline
+	CleanImageURLs         bool              `json:"clean_image_urls,omitempty"`         // Strip tracking parameters from image URLs
+	ReplayCookies          bool              `json:"replay_cookies,omitempty"`           // Refetch with the cookies the page set when it has no metadata
+	IncludeLinkStats       bool              `json:"include_link_stats,omitempty"`       // Count the page's internal and external links
+	FaviconFallback        *bool             `json:"favicon_fallback,omitempty"`         // Guess /favicon.ico when the page declares no icon (default true)
+	InlineFavicon          bool              `json:"inline_favicon,omitempty"`           // Also return the favicon as a data: URI
+	ProbeFavicon           bool              `json:"probe_favicon,omitempty"`            // List the sizes bundled in an ICO favicon
+	SkipConsentTitles      bool              `json:"skip_consent_titles,omitempty"`      // Prefer og/twitter titles over a consent wall's own
+	FetchOEmbed            bool              `json:"fetch_oembed,omitempty"`             // Fetch the oEmbed endpoint the page declares
+	IncludeASN             bool              `json:"include_asn,omitempty"`              // Look up the network of each resolved address in ASN_DB
+	CacheTTLMs             *int64            `json:"cache_ttl_ms,omitempty"`             // How long the result may be reused, instead of METADATA_CACHE_TTL
+	Mode                   string            `json:"mode,omitempty"`                     // "simple" for only what a link unfurl needs, skipping everything else
+	Debug                  bool              `json:"-"`                                  // Set from the ?debug=1 query parameter
+	EchoConfig             bool              `json:"-"`                                  // Set from the ?echo_config=1 query parameter
 }
 
 type BatchMetadataResponse struct {
-	Results []MetadataResult `json:"results"`
-	Total   int              `json:"total"`
+	Results   []MetadataResult `json:"results"`
+	Total     int              `json:"total"`
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
 }
 
+// MetadataResult is one URL's entry in a batch or bulk response. Status is
+// the HTTP status the URL would have got on its own.
 type MetadataResult struct {
 	*MetadataResponse
-	Error string `json:"error,omitempty"`
+	Status    int    `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Code      string `json:"code,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
 }
 
-func main() {
-	// Get port from environment variable or use default
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+// newMetadataResult builds the batch entry for one extraction of targetURL
+func newMetadataResult(r *http.Request, targetURL string, metadata *MetadataResponse, err error) MetadataResult {
+	if err == nil {
+		return MetadataResult{MetadataResponse: metadata, Status: http.StatusOK}
 	}
+	code := errorCode(err)
+	return MetadataResult{
+		MetadataResponse: &MetadataResponse{URL: targetURL},
+		Status:           errorStatuses[code],
+		Error:            err.Error(),
+		Code:             code,
+		RequestID:        requestID(r.Context()),
+	}
+}
 
-	// Setup routes with middleware
-	mux := http.NewServeMux()
-	mux.HandleFunc("/extract", extractMetadataHandler)
-	mux.HandleFunc("/health", healthCheckHandler)
-	mux.HandleFunc("/", rootHandler)
-
-	// Wrap with logging and CORS middleware
-	handler := loggingMiddleware(corsMiddleware(mux))
+func main() {
+	cfg, err := loadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v\n", err)
+	}
+	log.Printf("⚙️  Configuration: %s\n", cfg.Redacted())
+
+	store := newConfigStore(cfg, os.Args[1:])
+	configureTransport(cfg)
+	dnsCache = newDNSCache(newResolver(cfg))
+	screenshots = newScreenshotCache(cfg.ScreenshotCacheTTL, cfg.ScreenshotCacheBytes)
+	if cfg.ASNDB != "" {
+		if asnDB, err = loadASNDatabase(cfg.ASNDB); err != nil {
+			log.Fatalf("Invalid configuration: ASN_DB: %v\n", err)
+		}
+		log.Printf("🌐 Loaded %d ASN ranges from %s\n", len(asnDB.ranges), cfg.ASNDB)
+	}
 
-	// Create server with timeouts
-	server := &http.Server{
-		Addr:         ":" + port,
-		Handler:      handler,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	server := newServer(store)
+	redirect, err := configureTLS(cfg, server)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v\n", err)
+	}
+	ln, err := listen(cfg)
+	if err != nil {
+		log.Fatalf("Error starting server: %v\n", err)
 	}
 
 	// Start server in a goroutine
 	go func() {
-		log.Printf("🚀 Metadata extraction API running on http://localhost:%s\n", port)
+		log.Printf("🚀 Metadata extraction API running on %s\n", serverURL(cfg))
 		log.Println("📝 Usage: POST /extract with JSON body: {\"url\": \"https://example.com\"}")
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := serve(server, ln); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Error starting server: %v\n", err)
 		}
 	}()
+	if redirect != nil {
+		go func() {
+			log.Println("↪️  Redirecting http://:80 to HTTPS and answering ACME challenges")
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Error starting redirect server: %v\n", err)
+			}
+		}()
+	}
+
+	// Reload the reloadable settings on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadConfig(store)
+		}
+	}()
 
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
@@ -88,44 +243,83 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	// Cancel extractions first so handlers can answer and return promptly
+	completed, cancelled, drainErr := service.drain(ctx)
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+	if redirect != nil {
+		if err := redirect.Shutdown(ctx); err != nil {
+			log.Fatalf("Redirect server forced to shutdown: %v", err)
+		}
+	}
+	if drainErr != nil {
+		log.Printf("⚠️  Gave up waiting for extractions: %v\n", drainErr)
+	}
+	log.Printf("🧹 Drained extractions: %d completed, %d cancelled\n", completed, cancelled)
 
 	log.Println("✅ Server exited gracefully")
 }
 
+// newServer routes the API's endpoints through its middleware in a server
+// with the API's timeouts
+func newServer(store *configStore) *http.Server {
+	// Setup routes with middleware
+	mux := http.NewServeMux()
+	mux.HandleFunc("/extract", extractMetadataHandler(store))
+	mux.HandleFunc("/v2/extract", extractV2Handler(store))
+	mux.HandleFunc("/extract/bulk", bulkExtractHandler(store))
+	mux.HandleFunc("/extract/feed", feedHandler(store))
+	mux.HandleFunc("/favicons", faviconsHandler(store))
+	mux.HandleFunc("/img", imageProxyHandler(store))
+	mux.HandleFunc("/screenshot", screenshotHandler(store))
+	mux.HandleFunc("/admin/reload", adminReloadHandler(store))
+	mux.HandleFunc("/admin/stats", adminStatsHandler(store))
+	mux.HandleFunc("/admin/breakers/reset", adminBreakerResetHandler(store))
+	mux.HandleFunc("/health", healthCheckHandler)
+	mux.HandleFunc("/", rootHandler)
+
+	// Wrap with logging and CORS middleware
+	handler := requestIDMiddleware(loggingMiddleware(corsMiddleware(store.Load().AllowedOrigin, compressMiddleware(store, prettyMiddleware(inflightMiddleware(store, mux))))))
+
+	// Create server with timeouts. Request contexts derive from the service
+	// context so that shutdown cancels running extractions.
+	return &http.Server{
+		Handler:      handler,
+		BaseContext:  func(net.Listener) context.Context { return service.Context() },
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+}
+
 // Middleware for logging requests
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		
+
 		// Call the next handler
 		next.ServeHTTP(w, r)
-		
+
 		// Log the request
 		log.Printf(
-			"%s %s %s %s",
+			"%s %s %s %s %s",
 			r.Method,
 			r.RequestURI,
 			r.RemoteAddr,
 			time.Since(start),
+			requestID(r.Context()),
 		)
 	})
 }
 
 // Middleware for CORS
-func corsMiddleware(next http.Handler) http.Handler {
+func corsMiddleware(allowedOrigin string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get allowed origins from env or use default
-		allowedOrigin := os.Getenv("ALLOWED_ORIGIN")
-		if allowedOrigin == "" {
-			allowedOrigin = "*"
-		}
-
 		w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
 		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Inflight, X-RateLimit-Limit, X-RateLimit-Remaining, Retry-After")
 
 		// Handle preflight requests
 		if r.Method == "OPTIONS" {
@@ -143,218 +337,720 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"name":    "metadata.party",
 		"version": "1.0.0",
 		"endpoints": map[string]string{
-			"POST /extract": "Extract metadata from 1-5 URLs (use 'url' for single or 'urls' for batch)",
-			"GET /health":   "Health check endpoint",
+			"GET, POST /extract":    "Extract metadata from 1-5 URLs (use 'url' for single or 'urls' for batch); browsers get a debug page",
+			"GET, POST /v2/extract": "Extract metadata from 1-5 URLs, answered in the v2 envelope",
+			"POST /extract/bulk":    "Extract metadata from an uploaded file of URLs, streamed as NDJSON",
+			"GET /extract/feed":     "Read the channel and latest items of an RSS, Atom or JSON feed",
+			"POST /favicons":        "Find the best favicon for each of a list of sites",
+			"GET /img":              "Proxy a signed image URL from an extraction response",
+			"GET /screenshot":       "Render a signed page URL to an image",
+			"GET /health":           "Health check endpoint",
+		},
+		"versions": map[string]interface{}{
+			"v1": map[string]string{
+				"extract":  "GET, POST /extract",
+				"response": "The metadata object, or {results, total, succeeded, failed} for batches",
+				"errors":   "{error, code, request_id}",
+				"fields":   "sitename is a list; images is a list of URLs with details in image_details; favicon is a URL with favicon_data and favicon_sizes beside it",
+			},
+			"v2": map[string]string{
+				"extract":  "GET, POST /v2/extract",
+				"response": "{data, errors, warnings, request_id}: data is the metadata object, or a list of them with null for failed URLs in batches",
+				"errors":   "[{code, message, url, index}], with index only in batches",
+				"fields":   "site_name is a string; images is a list of {url, width, height, type, alt, ...}; favicon is {url, data, sizes} or null; warnings are in the envelope",
+			},
 		},
 		"docs": "https://github.com/yourusername/metadata.party",
 	})
 }
 
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
-func extractMetadataHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed. Use POST."})
-		return
+func extractMetadataHandler(store *configStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveExtract(store.Load(), w, r)
 	}
+}
 
+func serveExtract(cfg *Config, w http.ResponseWriter, r *http.Request) {
 	var req MetadataRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON body"})
+	var debugPage bool
+	switch r.Method {
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
+			return
+		}
+	case http.MethodGet:
+		// Browsers get the debug page, everything else the usual JSON
+		w.Header().Set("Vary", "Accept")
+		callback := r.URL.Query().Get("callback")
+		if callback != "" {
+			if err := checkCallback(cfg, callback); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			w = jsonpWriter{ResponseWriter: w, callback: callback}
+		}
+		var err error
+		if req, err = requestFromQuery(r.URL, "format", "callback"); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		debugPage = callback == "" && prefersHTML(r.Header.Get("Accept"))
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed. Use GET or POST."})
 		return
 	}
+	req.Debug = r.URL.Query().Get("debug") == "1"
+	req.EchoConfig = r.URL.Query().Get("echo_config") == "1"
+	if mode := r.URL.Query().Get("mode"); mode != "" {
+		req.Mode = mode
+	}
 
-	// Support both single URL and batch URLs
-	var urls []string
-	if req.URL != "" {
-		urls = append(urls, req.URL)
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "card" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "'format' must be card"})
+		return
 	}
-	if len(req.URLs) > 0 {
-		urls = append(urls, req.URLs...)
+	if format != "" && req.Mode == modeSimple {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "'format' can't be used with mode=simple"})
+		return
 	}
 
-	if len(urls) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "At least one URL is required (use 'url' or 'urls' field)"})
-		return
+	if debugPage {
+		// The page shows everything that explains a result, freshly extracted
+		req.Debug, req.IncludeSources, req.IncludeRawMeta = true, true, true
+		if req.CacheTTLMs == nil {
+			req.CacheTTLMs = new(int64)
+		}
 	}
 
-	if len(urls) > 5 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Maximum 5 URLs allowed per request"})
+	urls, opts, err := requestURLs(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	extractions := extractURLs(w, r, cfg, urls, opts)
+	if debugPage {
+		writeDebugPage(w, r, urls, extractions, req.MinSuccessRatio)
 		return
 	}
 
 	// Single URL: return simple response
 	if len(urls) == 1 {
-		metadata, err := extractMetadata(urls[0])
+		metadata, err := extractions[0].metadata, extractions[0].err
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			writeExtractError(w, r, err)
+			return
+		}
+		if format == "card" {
+			writeJSON(w, http.StatusOK, newCard(metadata))
 			return
 		}
-		json.NewEncoder(w).Encode(metadata)
+		if req.Mode == modeSimple {
+			writeJSON(w, http.StatusOK, newSimpleMetadata(metadata))
+			return
+		}
+		writeJSON(w, http.StatusOK, metadata)
 		return
 	}
 
 	// Multiple URLs: return batch response
-	type result struct {
-		index int
-		data  *MetadataResponse
-		err   error
+	metadataResults := make([]MetadataResult, len(urls))
+	for i, res := range extractions {
+		metadataResults[i] = newMetadataResult(r, urls[i], res.metadata, res.err)
 	}
 
-	results := make(chan result, len(urls))
-	
-	for i, url := range urls {
-		go func(idx int, targetURL string) {
-			metadata, err := extractMetadata(targetURL)
-			results <- result{index: idx, data: metadata, err: err}
-		}(i, url)
+	response := BatchMetadataResponse{
+		Results: metadataResults,
+		Total:   len(metadataResults),
 	}
-
-	// Collect results in order
-	metadataResults := make([]MetadataResult, len(urls))
-	for i := 0; i < len(urls); i++ {
-		res := <-results
-		if res.err != nil {
-			metadataResults[res.index] = MetadataResult{
-				MetadataResponse: &MetadataResponse{URL: urls[res.index]},
-				Error:            res.err.Error(),
-			}
+	for _, res := range metadataResults {
+		if res.Error == "" {
+			response.Succeeded++
 		} else {
-			metadataResults[res.index] = MetadataResult{
-				MetadataResponse: res.data,
-			}
+			response.Failed++
 		}
 	}
 
-	response := BatchMetadataResponse{
-		Results: metadataResults,
-		Total:   len(metadataResults),
+	// The batch is only a failure when every URL, or more than
+	// min_success_ratio allows, failed
+	status := batchStatus(metadataResults, req.MinSuccessRatio)
+	if format == "card" {
+		writeJSON(w, status, newBatchCardResponse(response))
+		return
+	}
+	if req.Mode == modeSimple {
+		writeJSON(w, status, newBatchSimpleResponse(response))
+		return
+	}
+	writeJSON(w, status, response)
+}
+
+// requestURLs validates an extract request and returns the URLs it asks
+// for, with the options to extract each with
+func requestURLs(req *MetadataRequest) ([]string, []ExtractOptions, error) {
+	if req.CacheTTLMs != nil && *req.CacheTTLMs < 0 {
+		return nil, nil, errors.New("'cache_ttl_ms' must not be negative")
+	}
+	if req.MinSuccessRatio < 0 || req.MinSuccessRatio > 1 {
+		return nil, nil, errors.New("'min_success_ratio' must be between 0 and 1")
+	}
+	if req.Mode != "" && req.Mode != modeFull && req.Mode != modeSimple {
+		return nil, nil, errors.New("'mode' must be full or simple")
+	}
+	if req.Lang != "" && !langTagPattern.MatchString(req.Lang) {
+		return nil, nil, errors.New("'lang' must be a language tag such as de or de-AT")
+	}
+	if req.FollowHreflang && req.Lang == "" {
+		return nil, nil, errors.New("'follow_hreflang' needs 'lang'")
+	}
+
+	// Support both single URL and batch URLs
+	var urls []string
+	var opts []ExtractOptions
+	if req.URL != "" {
+		urls = append(urls, req.URL)
+		opts = append(opts, req.ExtractOptions)
+	}
+	for _, entry := range req.URLs {
+		urls = append(urls, entry.URL)
+		opts = append(opts, entry.options(req.ExtractOptions))
 	}
 
-	json.NewEncoder(w).Encode(response)
+	if len(urls) == 0 {
+		return nil, nil, errors.New("At least one URL is required (use 'url' or 'urls' field)")
+	}
+	if len(urls) > 5 {
+		return nil, nil, errors.New("Maximum 5 URLs allowed per request")
+	}
+	for i := range opts {
+		if err := checkExtractOptions(opts[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return urls, opts, nil
+}
+
+// extraction is the outcome of extracting one URL of a request
+type extraction struct {
+	metadata *MetadataResponse
+	err      error
+}
+
+// extractURLs extracts urls concurrently, each with its opts, in the
+// pipeline every version of the API answers from. Extractions can outlast
+// the server's WriteTimeout, so the response gets the whole fetch budget
+// plus time to be written. Every URL shares the budget, which timeout_ms
+// can only shorten.
+func extractURLs(w http.ResponseWriter, r *http.Request, cfg *Config, urls []string, opts []ExtractOptions) []extraction {
+	ctx, cancel := context.WithTimeout(r.Context(), fetchTimeout)
+	defer cancel()
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(fetchTimeout + responseWriteMargin))
+
+	extractions := make([]extraction, len(urls))
+	var wg sync.WaitGroup
+	for i, targetURL := range urls {
+		wg.Add(1)
+		go func(i int, targetURL string) {
+			defer wg.Done()
+			ctx := ctx
+			if timeout := opts[i].TimeoutMs; timeout != nil && *timeout < fetchTimeout.Milliseconds() {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, time.Duration(*timeout)*time.Millisecond)
+				defer cancel()
+			}
+			metadata, err := extractMetadata(ctx, cfg, targetURL, opts[i])
+			if err == nil && opts[i].EchoConfig {
+				metadata.EffectiveConfig = effectiveConfig(cfg, opts[i], metadata)
+			}
+			extractions[i] = extraction{metadata: metadata, err: err}
+		}(i, targetURL)
+	}
+	wg.Wait()
+	return extractions
 }
 
-func extractMetadata(targetURL string) (*MetadataResponse, error) {
+// extractFreshMetadata fetches targetURL and extracts its metadata
+func extractFreshMetadata(ctx context.Context, cfg *Config, targetURL string, opts ExtractOptions) (*MetadataResponse, error) {
 	startTime := time.Now()
+	defer service.trackContext(ctx)()
 
 	// Parse URL to extract domain
 	parsedURL, err := url.Parse(targetURL)
 	if err != nil {
-		return nil, fmt.Errorf("invalid URL: %v", err)
+		return nil, codedErrorf(codeInvalidURL, "invalid URL: %v", err)
 	}
 
 	// Validate URL scheme
 	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-		return nil, fmt.Errorf("invalid URL scheme: only http and https are supported")
+		return nil, codedErrorf(codeInvalidURL, "invalid URL scheme: only http and https are supported")
 	}
 
 	// SSRF Protection: Check if the target is a blocked address
-	if err := validateURLForSSRF(parsedURL); err != nil {
+	if err := validateURLForSSRF(cfg, parsedURL); err != nil {
 		return nil, err
 	}
 
-	// Fetch the URL with custom user agent
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			// Limit redirects to prevent infinite loops
-			if len(via) >= 10 {
-				return fmt.Errorf("too many redirects")
-			}
-			return nil
-		},
+	// Every fetch made for this URL, including image probes, shares one deadline
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	ua := userAgents.pick(cfg, parsedURL.Hostname(), opts.UserAgent)
+	log.Printf("🕵️  Fetching %s as %q\n", targetURL, ua)
+
+	// Cookies only live as long as this extraction
+	var jar http.CookieJar
+	if opts.ReplayCookies {
+		jar = newCookieJar()
 	}
 
-	req, err := http.NewRequest("GET", targetURL, nil)
+	fetchStart := time.Now()
+	page, err := fetchPage(ctx, cfg, parsedURL, ua, opts.Headers, jar)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		return nil, err
+	}
+	// Go's transport reads some servers' malformed chunked encoding as an
+	// empty body where browsers get the page
+	if page.transfer.BytesDecoded == 0 {
+		log.Printf("🩹 Empty body from %s, retrying once over HTTP/1.1 with Connection: close\n", targetURL)
+		if retryPage, err := fetchPage(withForcedHTTP1(ctx), cfg, parsedURL, ua, opts.Headers, jar); err != nil {
+			log.Printf("⚠️  HTTP/1.1 retry of %s failed: %v\n", targetURL, err)
+		} else {
+			page = retryPage
+		}
+	}
+	parseStart := time.Now()
+	metadata, err := parsePage(ctx, page, parsedURL, cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Debug != nil {
+		metadata.Debug.FetchMs = parseStart.Sub(fetchStart).Milliseconds()
+		metadata.Debug.ParseMs = time.Since(parseStart).Milliseconds()
 	}
 
-	// Set a realistic user agent
-	req.Header.Set("User-Agent", "metadata.party/1.0 (+https://github.com/yourusername/metadata.party)")
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	// Some sites only serve their metadata, or get past their consent wall,
+	// once the cookie they set on the first response is sent back
+	if jar != nil && !metadata.Partial && (lacksMetadata(metadata) || metadata.ConsentWall) {
+		if cookies := jar.Cookies(page.finalURL); len(cookies) > 0 {
+			log.Printf("🍪 Retrying %s with %d cookie(s)\n", targetURL, len(cookies))
+			if retryPage, err := fetchPage(ctx, cfg, parsedURL, ua, opts.Headers, jar); err != nil {
+				log.Printf("⚠️  Cookie retry of %s failed: %v\n", targetURL, err)
+			} else if retried, err := parsePage(ctx, retryPage, parsedURL, cfg, opts); err == nil {
+				page, metadata = retryPage, retried
+				metadata.CookiesReplayed = true
+			}
+		}
+	}
+
+	sanitizeMetadataText(metadata, opts.IncludeRawMeta)
+	if opts.Mode == modeSimple {
+		return finishSimpleMetadata(metadata, parsedURL, targetURL, opts, startTime), nil
+	}
+	if metadata.Partial {
+		return finishPartialMetadata(metadata, page, parsedURL, targetURL, ua, cfg, opts, startTime), nil
+	}
+	addHashes(metadata, page)
+	providerData := startProviderFetch(ctx, cfg, page.finalURL)
+	metadata.URL = targetURL
+	metadata.Domain, metadata.DomainUnicode = normalizeDomain(parsedURL.Host)
+	metadata.IsIP = isIPHost(parsedURL.Hostname())
+	metadata.UserAgent = ua
+	metadata.ResponseHeaders = selectResponseHeaders(page.header, cfg.ResponseHeaders)
+	addNetworkInfo(ctx, cfg, metadata, page.finalURL.Hostname(), opts.IncludeASN)
+	checkParkedNameservers(ctx, metadata, page.finalURL.Hostname(), cfg.ParkingSignatures)
+
+	// Providers can replace what the page declares, so their data is in
+	// before anything below uses it
+	if providerData != nil {
+		applyProviderData(metadata, <-providerData)
+	}
+
+	if opts.CleanTitle {
+		cleanTitle(metadata, page.finalURL.Hostname())
+	}
+
+	// If no favicon found, try default location
+	if metadata.Favicon == "" && (opts.FaviconFallback == nil || *opts.FaviconFallback) {
+		metadata.Favicon = fmt.Sprintf("%s://%s/favicon.ico", parsedURL.Scheme, parsedURL.Host)
+		noteSource(metadata, "favicon", "fallback")
+	}
+
+	// Image verification, colors, oEmbed and the favicon probe are fetched
+	// together, within a budget of their own
+	enrich(ctx, cfg, metadata, opts)
+
+	addProxyURLs(cfg, metadata)
+
+	// Pages without any image can point at a rendered preview instead
+	if opts.ScreenshotFallback && len(metadata.Images) == 0 && screenshotsEnabled(cfg) {
+		metadata.Screenshot = screenshotURL(cfg, targetURL)
+	}
+
+	markMixedContent(metadata)
+	scoreQuality(metadata)
+	finishSources(metadata, opts.IncludeSources)
+	metadata.Duration = time.Since(startTime).Milliseconds()
+
+	return metadata, nil
+}
 
-	resp, err := client.Do(req)
+// finishPartialMetadata completes an extraction that ran out of time while
+// parsing. Nothing more is fetched, so only what the response and the page
+// already say is added.
+func finishPartialMetadata(metadata *MetadataResponse, page *fetchedPage, parsedURL *url.URL, targetURL, ua string, cfg *Config, opts ExtractOptions, startTime time.Time) *MetadataResponse {
+	addHashes(metadata, page)
+	metadata.URL = targetURL
+	metadata.Domain, metadata.DomainUnicode = normalizeDomain(parsedURL.Host)
+	metadata.IsIP = isIPHost(parsedURL.Hostname())
+	metadata.UserAgent = ua
+	metadata.ResponseHeaders = selectResponseHeaders(page.header, cfg.ResponseHeaders)
+	if metadata.Favicon == "" && (opts.FaviconFallback == nil || *opts.FaviconFallback) {
+		metadata.Favicon = fmt.Sprintf("%s://%s/favicon.ico", parsedURL.Scheme, parsedURL.Host)
+		noteSource(metadata, "favicon", "fallback")
+	}
+	addProxyURLs(cfg, metadata)
+	scoreQuality(metadata)
+	finishSources(metadata, opts.IncludeSources)
+	metadata.Duration = time.Since(startTime).Milliseconds()
+	return metadata
+}
+
+// fetchedPage is a page's body along with what extraction needs to know
+// about the response it came in. finalURL is where the redirects ended up.
+type fetchedPage struct {
+	body       []byte
+	doc        *html.Node // Parsed while downloading, instead of body, for large HTML pages
+	bodySHA256 []byte
+	mediaType  string
+	finalURL   *url.URL
+	header     http.Header
+	hsts       bool
+	hstsMaxAge int64
+	tls        *TLSInfo
+	transfer   *Transfer
+	decoding   *pageDecoding // How an HTML body was decoded to UTF-8
+	undecoded  bool          // The body's Content-Encoding is unknown, so it was read as sent
+}
+
+// fetchPage fetches and reads the page at target, with the request's extra
+// headers and using jar for cookies when it is non-nil
+func fetchPage(ctx context.Context, cfg *Config, target *url.URL, ua string, headers map[string]string, jar http.CookieJar) (*fetchedPage, error) {
+	if jar != nil {
+		ctx = withCookieJar(ctx, jar)
+	}
+	ctx, trace := withConnTrace(ctx)
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
+	header := http.Header{
+		"Accept":          {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+		"Accept-Encoding": {"gzip"},
+		"User-Agent":      {ua},
+	}
+	for name, value := range headers {
+		header.Set(name, value)
+	}
+	resp, release, err := fetchURL(ctx, cfg, target, header)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch URL: %v", err)
+		return nil, fetchError("failed to fetch URL", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP error: %d", resp.StatusCode)
+		release()
+		code := codeUpstream4xx
+		if resp.StatusCode >= 500 {
+			code = codeUpstream5xx
+		}
+		return nil, codedErrorf(code, "HTTP error: %d", resp.StatusCode)
+	}
+
+	// Only parse content types we know how to handle
+	mediaType := responseMediaType(resp)
+	if !isAllowedContentType(cfg, mediaType) {
+		release()
+		return nil, codedErrorf(codeParseFailure, "unsupported content type: %s", mediaType)
 	}
 
-	// Limit body size to prevent memory issues (10MB max)
-	limitedBody := io.LimitReader(resp.Body, 10*1024*1024)
-	body, err := io.ReadAll(limitedBody)
+	// Limit body size to prevent memory issues. Longer pages are cut off,
+	// since metadata lives near the top, but one that says up front it is
+	// over the limit is unlikely to be a page at all.
+	if resp.ContentLength > maxPageBytes {
+		release()
+		return nil, codedErrorf(codeTooLarge, "page is %d bytes, more than the %d byte limit", resp.ContentLength, maxPageBytes)
+	}
+	page := &fetchedPage{
+		mediaType: mediaType,
+		// resp.Request is the last request of the redirect chain
+		finalURL: resp.Request.URL,
+		header:   resp.Header,
+		transfer: &Transfer{
+			ContentEncoding: resp.Header.Get("Content-Encoding"),
+			Protocol:        protocolVersion(resp),
+			ConnReused:      trace.reused.Load(),
+		},
+	}
+	connections.record(page.transfer)
+
+	// A body that drips in can't hold the read for the whole fetch budget
+	var slow atomic.Bool
+	if cfg.BodyReadTimeout > 0 {
+		timer := time.AfterFunc(cfg.BodyReadTimeout, func() {
+			slow.Store(true)
+			abort()
+		})
+		defer timer.Stop()
+	}
+	err = readPageBody(cfg, resp, page)
+	release()
+	if err != nil && slow.Load() {
+		return nil, codedErrorf(codeSlowBody, "page body took longer than %s to read", cfg.BodyReadTimeout)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return nil, fetchError("failed to read response body", err)
 	}
+	page.hsts, page.hstsMaxAge = parseHSTS(resp)
+	page.tls = tlsInfo(resp)
+	return page, nil
+}
 
-	// Parse HTML
-	doc, err := html.Parse(strings.NewReader(string(body)))
+// readPageBody reads up to maxPageBytes of the decompressed response into
+// page, hashing and counting it on the way, and decodes HTML to UTF-8. HTML
+// pages larger than HTML_STREAM_THRESHOLD are parsed as they download rather
+// than held in memory twice, as bytes and as a tree.
+func readPageBody(cfg *Config, resp *http.Response, page *fetchedPage) error {
+	wire := &countingReader{r: resp.Body}
+	decoded, ok, err := decodeBody(resp, wire)
+	if err != nil {
+		return err
+	}
+	page.undecoded = !ok
+	limited := &io.LimitedReader{R: decoded, N: maxPageBytes}
+	hash := sha256.New()
+	body := io.TeeReader(limited, hash)
+
+	// The head is also where the charset is looked for
+	headBytes := max(cfg.HTMLStreamThreshold, charsetSniffBytes)
+	head, err := io.ReadAll(io.LimitReader(body, headBytes))
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse HTML: %v", err)
+		return err
 	}
+	switch {
+	case isFeedContentType(page.mediaType):
+		// Feeds declare their encoding to the XML decoder
+		rest, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		page.body = append(head, rest...)
+	case int64(len(head)) == headBytes:
+		enc, decoding := chooseCharset(cfg, head, resp.Header.Get("Content-Type"))
+		counter := &replacementCounter{r: transform.NewReader(io.MultiReader(bytes.NewReader(head), body), enc.NewDecoder())}
+		// html.Parse only fails when its reader does
+		if page.doc, err = html.Parse(counter); err != nil {
+			return err
+		}
+		decoding.replacements = counter.n
+		page.decoding = decoding
+	default:
+		// The head is the whole page
+		enc, decoding := chooseCharset(cfg, head, resp.Header.Get("Content-Type"))
+		if page.body, _, err = transform.Bytes(enc.NewDecoder(), head); err != nil {
+			return err
+		}
+		decoding.replacements = bytes.Count(page.body, replacementChar)
+		page.decoding = decoding
+	}
+	page.bodySHA256 = hash.Sum(nil)
 
-	duration := time.Since(startTime).Milliseconds()
+	// A page that filled the limit is only cut off if there is more of it
+	if limited.N == 0 {
+		var probe [1]byte
+		n, _ := io.ReadFull(decoded, probe[:])
+		page.transfer.Truncated = n > 0
+	}
+	page.transfer.BytesOnWire = wire.n
+	page.transfer.BytesDecoded = maxPageBytes - limited.N
+	return nil
+}
 
+// parsePage extracts the metadata found in a fetched page. If ctx is done
+// while the document is walked, what was found so far is returned as partial.
+func parsePage(ctx context.Context, page *fetchedPage, parsedURL *url.URL, cfg *Config, opts ExtractOptions) (*MetadataResponse, error) {
 	metadata := &MetadataResponse{
-		URL:      targetURL,
-		Domain:   parsedURL.Host,
-		Duration: duration,
-		Images:   []string{},
-		SiteName: []string{},
+		Images:     []string{},
+		SiteName:   []string{},
+		HSTS:       page.hsts,
+		HSTSMaxAge: page.hstsMaxAge,
+		TLS:        page.tls,
+		Transfer:   page.transfer,
 	}
 
-	// Extract metadata from HTML
-	extractFromNode(doc, metadata, parsedURL)
+	metadata.FinalScheme = page.finalURL.Scheme
+	metadata.Downgraded = parsedURL.Scheme == "https" && metadata.FinalScheme == "http"
+	applyDecoding(metadata, page.decoding)
+	if page.undecoded {
+		addWarning(metadata, warnUnknownEncoding, fmt.Sprintf("unknown content encoding %.50q; the body was read as sent", page.transfer.ContentEncoding))
+	}
+	if page.transfer.Truncated {
+		addWarning(metadata, warnTruncated, fmt.Sprintf("page is over %d bytes; only the start of it was read", maxPageBytes))
+	}
+	if opts.SecurityInfo {
+		metadata.Security = securityInfo(page.header, metadata)
+	}
 
-	// If no favicon found, try default location
-	if metadata.Favicon == "" {
-		metadata.Favicon = fmt.Sprintf("%s://%s/favicon.ico", parsedURL.Scheme, parsedURL.Host)
+	if isFeedContentType(page.mediaType) {
+		// Feeds only carry a title and description
+		if err := extractFromFeed(page.body, metadata); err != nil {
+			return nil, codedErrorf(codeParseFailure, "failed to parse feed: %v", err)
+		}
+		return metadata, nil
+	}
+
+	// Parse HTML, unless that happened while it downloaded
+	doc := page.doc
+	if doc == nil {
+		var err error
+		if doc, err = html.Parse(bytes.NewReader(page.body)); err != nil {
+			return nil, codedErrorf(codeParseFailure, "failed to parse HTML: %v", err)
+		}
+	}
+
+	if opts.Mode == modeSimple {
+		extractEssentials(doc, metadata, parsedURL, cfg.MaxMetaContentBytes)
+		dropInvalidAssetURLs(metadata, cfg.AssetURLSchemes)
+		fallbackSiteName(doc, metadata, page.finalURL.Hostname(), cfg.MaxMetaContentBytes)
+		return metadata, nil
 	}
 
+	// Extract metadata from HTML
+	if opts.IncludeLinkStats {
+		metadata.LinkStats = &LinkStats{}
+	}
+	stats := domStats{collectMeta: opts.Debug, maxMetaContent: cfg.MaxMetaContentBytes, done: ctx.Done()}
+	extractFromNode(doc, metadata, parsedURL, &stats, 0)
+	if opts.Debug {
+		metadata.Debug = &DebugInfo{
+			DOMNodeCount: stats.nodeCount,
+			DOMMaxDepth:  stats.maxDepth,
+			Streamed:     page.doc != nil,
+			MetaTags:     stats.metaTags,
+		}
+	}
+	// Out of time: what the walk found so far beats a timeout error
+	if stats.stopped {
+		metadata.Partial = true
+		metadata.Paywalled = paywalledUnknown
+		dropInvalidAssetURLs(metadata, cfg.AssetURLSchemes)
+		addWarning(metadata, warnPartial, fmt.Sprintf("extraction stopped after %d nodes: %v; metadata is partial", stats.nodeCount, ctx.Err()))
+		return metadata, nil
+	}
+	applyLinkHeaders(page.header, metadata, parsedURL)
+	if opts.BodyImages {
+		extractBodyImages(doc, metadata, parsedURL)
+	}
+	dropInvalidAssetURLs(metadata, cfg.AssetURLSchemes)
+	if metadata.Favicon == "" && len(metadata.Icons) > 0 {
+		metadata.Favicon = metadata.Icons[0].URL
+		noteSource(metadata, "favicon", "link[rel="+metadata.Icons[0].Rel+"] (dark theme)")
+	}
+	if opts.CleanImageURLs {
+		cleanImageURLs(metadata, cfg.TrackingParams, cfg.PreservedParams)
+	}
+	if opts.IncludeTitleCandidates {
+		metadata.TitleCandidates = extractTitleCandidates(doc)
+	}
+	if opts.ExtractHeadings {
+		metadata.Headings = extractHeadings(doc)
+	}
+	metadata.Video = extractVideo(doc, parsedURL, cfg.MaxMetaContentBytes)
+	metadata.Publisher = extractPublisher(doc, metadata, parsedURL)
+	fallbackSiteName(doc, metadata, page.finalURL.Hostname(), cfg.MaxMetaContentBytes)
+	if match := detectConsentWall(doc, metadata, cfg.ConsentSignatures, cfg.MaxMetaContentBytes); match != nil {
+		applyConsentWall(metadata, match, opts.SkipConsentTitles)
+	}
+	detectPaywall(doc, metadata, cfg.PaywallMarkers, cfg.MaxMetaContentBytes)
+	metadata.ContentRating = extractContentRating(doc, cfg.MaxMetaContentBytes)
+	detectSoft404(doc, metadata, page.finalURL, cfg.Soft404Patterns)
+	detectParkedPage(doc, metadata, parsedURL, page.finalURL, cfg.ParkingSignatures)
 	return metadata, nil
 }
 
-func extractFromNode(n *html.Node, metadata *MetadataResponse, baseURL *url.URL) {
+// titleText joins the text children of a <title>, which some pages split
+// with comments or markup the parser keeps as separate nodes
+func titleText(n *html.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			b.WriteString(c.Data)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func extractFromNode(n *html.Node, metadata *MetadataResponse, baseURL *url.URL, stats *domStats, depth int) {
+	if stats.stopped {
+		return
+	}
+	select {
+	case <-stats.done:
+		stats.stopped = true
+		return
+	default:
+	}
+	stats.nodeCount++
+	if depth > stats.maxDepth {
+		stats.maxDepth = depth
+	}
+
 	if n.Type == html.ElementNode {
 		switch n.Data {
 		case "title":
-			if n.FirstChild != nil && metadata.Title == "" {
-				metadata.Title = strings.TrimSpace(n.FirstChild.Data)
+			if metadata.Title == "" {
+				metadata.Title = titleText(n)
+				noteSource(metadata, "title", "title")
 			}
 		case "meta":
-			extractMetaTag(n, metadata, baseURL)
+			stats.addMetaTag(n)
+			extractMetaTag(n, metadata, baseURL, stats.maxMetaContent)
 		case "link":
 			extractLinkTag(n, metadata, baseURL)
+		case "a":
+			if metadata.LinkStats != nil {
+				countLink(n, metadata.LinkStats, baseURL)
+			}
 		}
 	}
 
 	// Traverse children
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		extractFromNode(c, metadata, baseURL)
+		extractFromNode(c, metadata, baseURL, stats, depth+1)
+	}
+}
+
+// metaContent returns the content of the <meta> n cut to maxContent bytes,
+// which is MAX_META_CONTENT_BYTES wherever meta tags are read
+func metaContent(n *html.Node, maxContent int) string {
+	content := attrValue(n, "content")
+	if len(content) > maxContent {
+		content = truncateUTF8(content, maxContent)
 	}
+	return content
 }
 
-func extractMetaTag(n *html.Node, metadata *MetadataResponse, baseURL *url.URL) {
-	var name, property, content string
+// extractMetaTag records what a <meta> tag says about the page. Its content
+// is cut to maxContent bytes first, so that one absurdly long value can't
+// bloat the metadata.
+func extractMetaTag(n *html.Node, metadata *MetadataResponse, baseURL *url.URL, maxContent int) {
+	var name, property string
 
 	for _, attr := range n.Attr {
 		switch attr.Key {
@@ -362,42 +1058,69 @@ func extractMetaTag(n *html.Node, metadata *MetadataResponse, baseURL *url.URL)
 			name = strings.ToLower(attr.Val)
 		case "property":
 			property = strings.ToLower(attr.Val)
-		case "content":
-			content = attr.Val
 		}
 	}
+	content := metaContent(n, maxContent)
 
 	if content == "" {
 		return
 	}
+	if strings.HasPrefix(property, "og:") {
+		noteOpenGraph(metadata, property)
+	}
 
 	// Handle different meta tags
 	switch {
 	case name == "description" && metadata.Description == "":
 		metadata.Description = content
+		noteSource(metadata, "description", "meta[name=description]")
 	case property == "og:description" && metadata.Description == "":
 		metadata.Description = content
+		noteSource(metadata, "description", "og:description")
 	case property == "og:title" && metadata.Title == "":
 		metadata.Title = content
-	case property == "og:image":
-		imageURL := resolveURL(content, baseURL)
-		metadata.Images = append(metadata.Images, imageURL)
+		noteSource(metadata, "title", "og:title")
+	case property == "og:image" || property == "og:image:url":
+		addImage(metadata, resolveURL(content, baseURL), property)
+	case property == "og:image:width":
+		if img := lastImage(metadata); img != nil {
+			img.Width, _ = strconv.Atoi(strings.TrimSpace(content))
+		}
+	case property == "og:image:height":
+		if img := lastImage(metadata); img != nil {
+			img.Height, _ = strconv.Atoi(strings.TrimSpace(content))
+		}
+	case property == "og:image:type":
+		if img := lastImage(metadata); img != nil {
+			img.Type = content
+		}
+	case property == "og:image:alt":
+		if img := lastImage(metadata); img != nil {
+			img.Alt = content
+		}
 	case property == "og:site_name":
 		metadata.SiteName = append(metadata.SiteName, content)
+		noteSource(metadata, "sitename", "og:site_name")
 	case name == "twitter:image":
 		imageURL := resolveURL(content, baseURL)
 		if !contains(metadata.Images, imageURL) {
-			metadata.Images = append(metadata.Images, imageURL)
+			addImage(metadata, imageURL, "twitter:image")
 		}
 	case name == "twitter:title" && metadata.Title == "":
 		metadata.Title = content
+		noteSource(metadata, "title", "twitter:title")
 	case name == "twitter:description" && metadata.Description == "":
 		metadata.Description = content
+		noteSource(metadata, "description", "twitter:description")
+	case strings.HasPrefix(name, "twitter:app:"):
+		addAppLink(metadata, name, strings.TrimSpace(content))
+	case strings.HasPrefix(property, "al:"):
+		addAppLink(metadata, property, strings.TrimSpace(content))
 	}
 }
 
 func extractLinkTag(n *html.Node, metadata *MetadataResponse, baseURL *url.URL) {
-	var rel, href string
+	var rel, href, linkType, sizes, media, hreflang string
 
 	for _, attr := range n.Attr {
 		switch attr.Key {
@@ -405,6 +1128,14 @@ func extractLinkTag(n *html.Node, metadata *MetadataResponse, baseURL *url.URL)
 			rel = strings.ToLower(attr.Val)
 		case "href":
 			href = attr.Val
+		case "type":
+			linkType = attr.Val
+		case "sizes":
+			sizes = attr.Val
+		case "media":
+			media = strings.TrimSpace(attr.Val)
+		case "hreflang":
+			hreflang = attr.Val
 		}
 	}
 
@@ -412,9 +1143,42 @@ func extractLinkTag(n *html.Node, metadata *MetadataResponse, baseURL *url.URL)
 		return
 	}
 
+	if hasRel(rel, "canonical") && metadata.Canonical == "" {
+		metadata.Canonical = resolveURL(href, baseURL)
+		noteSource(metadata, "canonical", "link[rel=canonical]")
+	}
+
+	if hasRel(rel, "alternate") {
+		addOEmbedLink(metadata, linkType, href, baseURL)
+		addHreflangLink(metadata, hreflang, href, baseURL)
+	}
+
+	if hasRel(rel, "preconnect", "dns-prefetch") {
+		addPreconnectHost(metadata, href, baseURL)
+	}
+
 	// Extract favicon
-	if strings.Contains(rel, "icon") && metadata.Favicon == "" {
-		metadata.Favicon = resolveURL(href, baseURL)
+	if strings.Contains(rel, "icon") {
+		icon := IconLink{URL: resolveURL(href, baseURL), Rel: rel, Type: linkType, Sizes: sizes, Media: media}
+		metadata.Icons = append(metadata.Icons, icon)
+		// Dark-theme variants are only used when there is nothing else
+		if metadata.Favicon == "" && !isDarkMedia(media) {
+			metadata.Favicon = icon.URL
+			noteSource(metadata, "favicon", "link[rel="+rel+"]")
+		}
+	}
+}
+
+// addPreconnectHost records the host of a preconnect or dns-prefetch hint,
+// once, such as fonts.gstatic.com for "//fonts.gstatic.com"
+func addPreconnectHost(metadata *MetadataResponse, href string, baseURL *url.URL) {
+	u, err := url.Parse(resolveURL(href, baseURL))
+	if err != nil {
+		return
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host != "" && !contains(metadata.PreconnectHosts, host) {
+		metadata.PreconnectHosts = append(metadata.PreconnectHosts, host)
 	}
 }
 
@@ -444,23 +1208,33 @@ func contains(slice []string, item string) bool {
 }
 
 // validateURLForSSRF checks if a URL is safe to fetch (SSRF protection)
-func validateURLForSSRF(parsedURL *url.URL) error {
+func validateURLForSSRF(cfg *Config, parsedURL *url.URL) error {
 	host := parsedURL.Hostname()
-	
-	// Resolve the hostname to IP addresses
-	ips, err := net.LookupIP(host)
-	if err != nil {
-		return fmt.Errorf("failed to resolve hostname: %v", err)
+
+	// Operator host lists are checked before spending a DNS lookup
+	if matchesHost(host, cfg.BlockedHosts) {
+		return codedErrorf(codeBlocked, "access to host %s is not allowed", host)
+	}
+	if len(cfg.AllowedHosts) > 0 && !matchesHost(host, cfg.AllowedHosts) {
+		return codedErrorf(codeBlocked, "host %s is not in the allowed hosts list", host)
 	}
 
-	// Check each resolved IP
-	for _, ip := range ips {
-		if isBlockedIP(ip) {
-			return fmt.Errorf("access to private/internal IP addresses is not allowed: %s", ip.String())
+	return ssrfValidations.validate(host, cfg.SSRFCacheTTL, func() error {
+		// Resolve the hostname to IP addresses
+		ips, err := resolveHost(context.Background(), cfg, host)
+		if err != nil {
+			return &extractError{code: errorCode(err), err: fmt.Errorf("failed to resolve hostname: %v", err)}
 		}
-	}
 
-	return nil
+		// Check each resolved IP
+		for _, ip := range ips {
+			if err := checkIP(cfg, host, ip); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 }
 
 // isBlockedIP checks if an IP address should be blocked (SSRF protection)
@@ -491,22 +1265,22 @@ func isBlockedIP(ip net.IP) bool {
 		if ipv4[0] == 0 {
 			return true
 		}
-		
+
 		// Block 169.254.0.0/16 (AWS metadata service and link-local)
 		if ipv4[0] == 169 && ipv4[1] == 254 {
 			return true
 		}
-		
+
 		// Block 127.0.0.0/8 (loopback, extra check)
 		if ipv4[0] == 127 {
 			return true
 		}
-		
+
 		// Block 224.0.0.0/4 (multicast, extra check)
 		if ipv4[0] >= 224 && ipv4[0] <= 239 {
 			return true
 		}
-		
+
 		// Block 240.0.0.0/4 (reserved)
 		if ipv4[0] >= 240 {
 			return true
@@ -516,3 +1290,37 @@ func isBlockedIP(ip net.IP) bool {
 	return false
 }
 
+// inCIDRs reports whether ip falls in any of cidrs
+func inCIDRs(ip net.IP, cidrs []*net.IPNet) bool {
+	for _, cidr := range cidrs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ssrfExempt reports whether host is in SSRF_ALLOW_HOSTS. Unlike the other
+// host lists, subdomains don't match; IPs are compared as addresses, so
+// ::1 matches 0:0:0:0:0:0:0:1.
+func ssrfExempt(cfg *Config, host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	ip := net.ParseIP(host)
+	for _, h := range cfg.SSRFAllowHosts {
+		if host == h || (ip != nil && ip.Equal(net.ParseIP(h))) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesHost reports whether host is one of hosts or a subdomain of one
+func matchesHost(host string, hosts []string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixturePage is the HTML page in testdata/name as if it had been fetched
+// from pageURL
+func fixturePage(t testing.TB, name, pageURL string) (*fetchedPage, *url.URL) {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fetchedPage{
+		body:      body,
+		mediaType: "text/html",
+		finalURL:  u,
+		header:    http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		transfer:  &Transfer{},
+	}, u
+}
+
+// parseFixture runs the HTML page in testdata/name through parsePage
+func parseFixture(t testing.TB, name, pageURL string, opts ExtractOptions) *MetadataResponse {
+	t.Helper()
+	page, u := fixturePage(t, name, pageURL)
+	metadata, err := parsePage(context.Background(), page, u, defaultConfig(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata
+}
+
+func BenchmarkParsePage(b *testing.B) {
+	page, u := fixturePage(b, "article.html", "https://news.example.com/2024/03/harbour-rebuilt")
+	cfg := defaultConfig()
+	for _, bm := range []struct {
+		name string
+		opts ExtractOptions
+	}{
+		{"simple", ExtractOptions{Mode: modeSimple}},
+		{"full", ExtractOptions{}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(page.body)))
+			for i := 0; i < b.N; i++ {
+				if _, err := parsePage(context.Background(), page, u, cfg, bm.opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// startServer serves the API as main does, through every middleware and
+// with the production timeouts
+func startServer(t *testing.T, cfg *Config) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = newServer(newConfigStore(cfg, nil))
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// An upstream slower than the server's WriteTimeout used to have its
+// response dropped with the connection instead of written
+func TestSlowUpstreamThroughMiddleware(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits 20s on the upstream")
+	}
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(20 * time.Second):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>Slow</title></head></html>"))
+	}))
+	defer origin.Close()
+	api := startServer(t, testConfig())
+
+	tests := []struct {
+		name, body string
+		status     int
+		want       string
+	}{
+		{"completes", `{"url":"` + origin.URL + `/slow"}`, http.StatusOK, "Slow"},
+		{"times out", `{"url":"` + origin.URL + `/timeout","timeout_ms":1000}`, http.StatusGatewayTimeout, "timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, api.URL+"/extract?pretty=1", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Origin", "https://example.com")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			var body map[string]any
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("reading the response: %v", err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d: %v", resp.StatusCode, tt.status, body)
+			}
+			if tt.status == http.StatusOK && body["title"] != tt.want {
+				t.Errorf("title = %v, want %q", body["title"], tt.want)
+			}
+			if tt.status != http.StatusOK && body["code"] != tt.want {
+				t.Errorf("code = %v, want %q", body["code"], tt.want)
+			}
+		})
+	}
+}
+
+// Every walker that reads <meta content> must cut it to
+// MAX_META_CONTENT_BYTES, not only extractMetaTag
+func TestMetaContentCappedEverywhere(t *testing.T) {
+	long := "https://example.com/" + strings.Repeat("a", 64*1024)
+	body := `<html><head><title>Long</title>
+<meta property="og:video" content="` + long + `">
+<meta name="rating" content="` + long + `">
+<meta property="og:restrictions:age" content="` + long + `">
+<meta itemprop="isFamilyFriendly" content="` + long + `">
+<meta name="application-name" content="` + long + `">
+</head></html>`
+	u, _ := url.Parse("https://example.com/")
+	page := &fetchedPage{body: []byte(body), mediaType: "text/html", finalURL: u, header: http.Header{}, transfer: &Transfer{}}
+	cfg := defaultConfig()
+	cfg.MaxMetaContentBytes = 100
+
+	metadata, err := parsePage(context.Background(), page, u, cfg, ExtractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Video == nil || metadata.ContentRating == nil {
+		t.Fatalf("video %v and content rating %v, want both", metadata.Video, metadata.ContentRating)
+	}
+	values := map[string]string{
+		"video":           metadata.Video.EmbedURL,
+		"site_name":       strings.Join(metadata.SiteName, ""),
+		"rating":          strings.Join(metadata.ContentRating.Rating, ""),
+		"age_restriction": metadata.ContentRating.AgeRestriction,
+		"family_friendly": metadata.ContentRating.FamilyFriendly,
+	}
+	for field, v := range values {
+		if v == "" || len(v) > cfg.MaxMetaContentBytes {
+			t.Errorf("%s is %d bytes, want 1 to %d", field, len(v), cfg.MaxMetaContentBytes)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// parkedNSTimeout bounds the nameserver lookup of a possibly parked domain
+const parkedNSTimeout = 2 * time.Second
+
+// defaultParkingSignatures is used when PARKING_SIGNATURES_FILE is not set
+var defaultParkingSignatures = &signatureList{
+	ScriptHosts: []string{
+		"sedoparking.com",
+		"sedo.com",
+		"bodis.com",
+		"parkingcrew.net",
+		"dan.com",
+		"afternic.com",
+		"above.com",
+		"parklogic.com",
+		"hugedomains.com",
+		"domainmarket.com",
+		"undeveloped.com",
+		"uniregistry.com",
+		"dsparking.com",
+		"domainsponsor.com",
+	},
+	Phrases: []string{
+		"domain is for sale",
+		"domain may be for sale",
+		"domain for sale",
+		"buy this domain",
+		"this domain has been registered",
+		"this domain is parked",
+		"parked free",
+		"parked domain",
+		"domain parking",
+		"domain steht zum verkauf",
+		"diese domain kaufen",
+		"domaine est à vendre",
+		"dominio está a la venta",
+		"dominio en venta",
+		"dominio è in vendita",
+		"domein is te koop",
+	},
+	Nameservers: []string{
+		"sedoparking.com",
+		"bodis.com",
+		"parkingcrew.net",
+		"above.com",
+		"dan.com",
+		"afternic.com",
+		"hugedomains.com",
+		"parklogic.com",
+		"dsredirection.com",
+	},
+}
+
+// detectParkedPage flags a page served by a domain parking or sale service:
+// a redirect took the fetch of requestedURL onto the service, the page loads
+// its scripts or frames, or the title or description is a for-sale notice.
+// Pages requested from the service itself, such as its blog, aren't parked.
+func detectParkedPage(doc *html.Node, metadata *MetadataResponse, requestedURL, finalURL *url.URL, sigs *signatureList) {
+	signal := ""
+	for _, host := range sigs.ScriptHosts {
+		if matchesHost(finalURL.Hostname(), []string{host}) && !matchesHost(requestedURL.Hostname(), []string{host}) {
+			signal = "redirect: " + host
+			break
+		}
+	}
+	if signal == "" {
+		if phrase := matchPhrase(metadata.Title, sigs.Phrases); phrase != "" {
+			signal = "title: " + phrase
+		} else if phrase := matchPhrase(metadata.Description, sigs.Phrases); phrase != "" {
+			signal = "description: " + phrase
+		}
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if signal != "" {
+			return
+		}
+		if n.Type == html.ElementNode {
+			if n.DataAtom == atom.Script || n.DataAtom == atom.Iframe {
+				if u, err := url.Parse(attrValue(n, "src")); err == nil && u.Host != "" {
+					for _, host := range sigs.ScriptHosts {
+						if matchesHost(u.Hostname(), []string{host}) {
+							signal = n.Data + ": " + host
+							return
+						}
+					}
+				}
+			}
+			ids := strings.ToLower(attrValue(n, "id") + " " + attrValue(n, "class"))
+			for _, marker := range sigs.Markers {
+				if marker != "" && strings.Contains(ids, marker) {
+					signal = "element: " + marker
+					return
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	if signal != "" {
+		metadata.Parked, metadata.ParkedSignal = true, signal
+	}
+}
+
+// checkParkedNameservers flags a page as parked when its domain is delegated
+// to a parking service. Parking pages are often rendered by script and show
+// nothing to match, so the lookup is only made for pages without metadata.
+// It is skipped when the resolver can't look up nameservers.
+func checkParkedNameservers(ctx context.Context, metadata *MetadataResponse, host string, sigs *signatureList) {
+	if metadata.Parked || !lacksMetadata(metadata) || len(sigs.Nameservers) == 0 {
+		return
+	}
+	nameservers, err := lookupNameservers(ctx, registrableDomain(host))
+	if err != nil {
+		return
+	}
+	for _, ns := range nameservers {
+		if matchesHost(ns, sigs.Nameservers) {
+			metadata.Parked, metadata.ParkedSignal = true, "nameserver: "+strings.TrimSuffix(ns, ".")
+			return
+		}
+	}
+}
+
+// nsResolver is implemented by resolvers that can look up nameservers
+type nsResolver interface {
+	lookupNS(ctx context.Context, domain string) ([]string, error)
+}
+
+var errNSUnsupported = errors.New("the resolver can't look up nameservers")
+
+// lookupNameservers returns the nameservers of domain through the configured
+// resolver
+func lookupNameservers(ctx context.Context, domain string) ([]string, error) {
+	r, ok := dnsCache.resolver.(nsResolver)
+	if !ok {
+		return nil, errNSUnsupported
+	}
+	ctx, cancel := context.WithTimeout(ctx, parkedNSTimeout)
+	defer cancel()
+	return r.lookupNS(ctx, domain)
+}
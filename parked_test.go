@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"testing"
+)
+
+func TestDetectParkedPageFixtures(t *testing.T) {
+	tests := []struct {
+		fixture, requestedURL, finalURL, signal string
+	}{
+		{"sedo-script.html", "https://example.com/", "https://example.com/", "script: sedoparking.com"},
+		{"bodis-iframe.html", "https://example.net/", "https://example.net/", "iframe: bodis.com"},
+		{"for-sale-title.html", "https://example.org/", "https://example.org/", "title: domain is for sale"},
+		{"for-sale-description-de.html", "https://beispiel.de/", "https://beispiel.de/", "description: domain steht zum verkauf"},
+		{"dan-landing.html", "https://example.io/", "https://dan.com/buy-domain/example.io", "redirect: dan.com"},
+		{"dan-landing.html", "https://example.io/", "https://example.io/", ""},
+		// The service's own pages, even after a redirect within it
+		{"dan-landing.html", "https://dan.com/blog", "https://dan.com/blog", ""},
+		{"dan-landing.html", "https://www.afternic.com/", "https://afternic.com/", ""},
+		{"car-parking-blog.html", "https://blog.example.com/parking", "https://blog.example.com/parking", ""},
+	}
+	for _, tt := range tests {
+		page, finalURL := fixturePage(t, "parked/"+tt.fixture, tt.finalURL)
+		requestedURL, err := url.Parse(tt.requestedURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metadata, err := parsePage(context.Background(), page, requestedURL, defaultConfig(), ExtractOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metadata.Parked != (tt.signal != "") || metadata.ParkedSignal != tt.signal {
+			t.Errorf("%s requested at %s, fetched from %s: parked %t with signal %q, want %q",
+				tt.fixture, tt.requestedURL, finalURL, metadata.Parked, metadata.ParkedSignal, tt.signal)
+		}
+	}
+}
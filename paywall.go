@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Values of MetadataResponse.Paywalled
+const (
+	paywalledYes     = "true"
+	paywalledNo      = "false"
+	paywalledUnknown = "unknown"
+)
+
+// defaultPaywallMarkers is used when PAYWALL_MARKERS is not set
+var defaultPaywallMarkers = []string{
+	"paywall",
+	"pay-wall",
+	"regwall",
+	"subscriber-only",
+	"subscribers-only",
+	"premium-content",
+	"piano-inline",
+	"tp-container",
+}
+
+// parsePaywallMarkers parses a comma-separated PAYWALL_MARKERS list
+func parsePaywallMarkers(v string) []string {
+	var markers []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			markers = append(markers, item)
+		}
+	}
+	return markers
+}
+
+// paywallSignals collects what a page says about its access while it is walked
+type paywallSignals struct {
+	schema       string // From schema.org JSON-LD or microdata
+	schemaSource string
+	contentTier  string // From article:content_tier
+	marker       string // The paywall marker an element matched
+}
+
+// detectPaywall sets metadata.Paywalled from the page's signals. Schema.org
+// isAccessibleForFree, in JSON-LD (including its hasPart sections) or
+// microdata, is what publishers declare for search engines and wins. The
+// article:content_tier meta tag and elements matching markers are weaker:
+// metered sites use them for pages that are free to most readers, so they
+// are flagged as heuristic.
+func detectPaywall(doc *html.Node, metadata *MetadataResponse, markers []string, maxMetaContent int) {
+	var signals paywallSignals
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Script:
+				if strings.EqualFold(strings.TrimSpace(attrValue(n, "type")), "application/ld+json") {
+					if free, ok := accessFromJSONLD(textContent(n)); ok && signals.schema != paywalledYes {
+						signals.schema, signals.schemaSource = accessValue(free), "json-ld"
+					}
+				}
+			case atom.Meta:
+				content := strings.ToLower(strings.TrimSpace(metaContent(n, maxMetaContent)))
+				if attrValue(n, "itemprop") == "isAccessibleForFree" && signals.schema != paywalledYes {
+					if free, ok := parseSchemaBoolean(content); ok {
+						signals.schema, signals.schemaSource = accessValue(free), "microdata"
+					}
+				}
+				if strings.EqualFold(attrValue(n, "property")+attrValue(n, "name"), "article:content_tier") && signals.contentTier == "" {
+					signals.contentTier = content
+				}
+			}
+			if signals.marker == "" {
+				ids := strings.ToLower(attrValue(n, "id") + " " + attrValue(n, "class"))
+				for _, marker := range markers {
+					if strings.Contains(ids, marker) {
+						signals.marker = marker
+						break
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	metadata.Paywalled = paywalledUnknown
+	switch {
+	case signals.schema != "":
+		metadata.Paywalled, metadata.PaywallSource = signals.schema, signals.schemaSource
+	case signals.contentTier == "locked" || signals.contentTier == "metered":
+		metadata.Paywalled, metadata.PaywallSource, metadata.PaywallHeuristic = paywalledYes, "meta", true
+	case signals.marker != "":
+		metadata.Paywalled, metadata.PaywallSource, metadata.PaywallHeuristic = paywalledYes, "element: "+signals.marker, true
+	case signals.contentTier == "free":
+		metadata.Paywalled, metadata.PaywallSource, metadata.PaywallHeuristic = paywalledNo, "meta", true
+	}
+}
+
+// accessFromJSONLD reports whether a JSON-LD document declares its content
+// free. Any object, including a hasPart section, that isn't free makes the
+// page paywalled.
+func accessFromJSONLD(data string) (free, ok bool) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		return false, false
+	}
+
+	free = true
+	var visit func(v interface{})
+	visit = func(v interface{}) {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, item := range v {
+				visit(item)
+			}
+		case map[string]interface{}:
+			if value, found := v["isAccessibleForFree"]; found {
+				var isFree, valid bool
+				switch value := value.(type) {
+				case bool:
+					isFree, valid = value, true
+				case string:
+					isFree, valid = parseSchemaBoolean(value)
+				}
+				if valid {
+					ok = true
+					free = free && isFree
+				}
+			}
+			for _, child := range v {
+				visit(child)
+			}
+		}
+	}
+	visit(doc)
+	return free, ok
+}
+
+// parseSchemaBoolean parses a schema.org Boolean written as text, such as
+// "False" or "https://schema.org/True"
+func parseSchemaBoolean(s string) (value, ok bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "https://schema.org/"), "http://schema.org/")
+	switch s {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	}
+	return false, false
+}
+
+// accessValue converts isAccessibleForFree into a Paywalled value
+func accessValue(free bool) string {
+	if free {
+		return paywalledNo
+	}
+	return paywalledYes
+}
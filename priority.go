@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// priority orders fetches queued for a host. Requests a client is waiting
+// on are high; bulk uploads default to low.
+type priority int
+
+const (
+	priorityLow priority = iota
+	priorityNormal
+	priorityHigh
+)
+
+var priorityNames = [...]string{"low", "normal", "high"}
+
+func (p priority) String() string {
+	return priorityNames[p]
+}
+
+// priorityAging is how long a queued fetch waits before it counts as one
+// class higher, so that a stream of high priority work can't starve the rest
+const priorityAging = 2 * time.Second
+
+// parsePriority reads the name of a priority
+func parsePriority(name string) (priority, error) {
+	for p, n := range priorityNames {
+		if n == name {
+			return priority(p), nil
+		}
+	}
+	return 0, fmt.Errorf("'priority' must be low, normal or high")
+}
+
+// priorityKey carries an extraction's priority to fetchURL
+type priorityKey struct{}
+
+func withPriority(ctx context.Context, p priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityFromContext returns the priority stored by withPriority, or high
+// for the synchronous API
+func priorityFromContext(ctx context.Context) priority {
+	if p, ok := ctx.Value(priorityKey{}).(priority); ok {
+		return p
+	}
+	return priorityHigh
+}
+
+// fetchQueue counts the fetches admitted by hostLimits, and those waiting,
+// for /admin/stats
+var fetchQueue fetchQueueCounters
+
+type fetchQueueCounters struct {
+	classes [len(priorityNames)]struct {
+		queued   atomic.Int64
+		admitted atomic.Int64
+		waitNs   atomic.Int64
+	}
+}
+
+// FetchQueueStats reports one priority class: the fetches waiting for a
+// host slot now, those admitted so far and how long they waited on average
+type FetchQueueStats struct {
+	Queued    int64   `json:"queued"`
+	Admitted  int64   `json:"admitted"`
+	AvgWaitMs float64 `json:"avg_wait_ms"`
+}
+
+func (c *fetchQueueCounters) admit(p priority, wait time.Duration) {
+	c.classes[p].admitted.Add(1)
+	c.classes[p].waitNs.Add(int64(wait))
+}
+
+func (c *fetchQueueCounters) stats() map[string]FetchQueueStats {
+	stats := make(map[string]FetchQueueStats, len(priorityNames))
+	for p, name := range priorityNames {
+		class := &c.classes[p]
+		s := FetchQueueStats{Queued: class.queued.Load(), Admitted: class.admitted.Load()}
+		if s.Admitted > 0 {
+			s.AvgWaitMs = float64(class.waitNs.Load()) / float64(s.Admitted) / float64(time.Millisecond)
+		}
+		stats[name] = s
+	}
+	return stats
+}
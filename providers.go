@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"time"
+)
+
+// providerTimeout bounds a provider's API calls for one page
+const providerTimeout = 5 * time.Second
+
+// provider enriches pages of one well-known site with data from its API.
+// match reports whether a page belongs to the site; fetch gets the data,
+// which apply puts into the page's metadata. Providers without apply return
+// their data under provider_data.<name>.
+type provider struct {
+	name  string
+	match func(pageURL *url.URL) bool
+	fetch func(ctx context.Context, cfg *Config, pageURL *url.URL) (interface{}, error)
+	apply func(metadata *MetadataResponse, data interface{})
+}
+
+// providers are tried in order; the first that matches a page is used
+var providers = []provider{githubProvider, wikipediaProvider}
+
+// providerResult is the outcome of a provider's API calls
+type providerResult struct {
+	provider provider
+	data     interface{}
+	err      error
+}
+
+// startProviderFetch calls the API of the provider matching pageURL in the
+// background. It returns nil when no provider matches.
+func startProviderFetch(ctx context.Context, cfg *Config, pageURL *url.URL) <-chan providerResult {
+	for _, p := range providers {
+		if !p.match(pageURL) {
+			continue
+		}
+		result := make(chan providerResult, 1)
+		go func(p provider) {
+			ctx, cancel := context.WithTimeout(ctx, providerTimeout)
+			defer cancel()
+
+			data, err := p.fetch(ctx, cfg, pageURL)
+			result <- providerResult{provider: p, data: data, err: err}
+		}(p)
+		return result
+	}
+	return nil
+}
+
+// applyProviderData adds a provider's data. The page's own metadata is
+// complete without it, so a failure is only logged.
+func applyProviderData(metadata *MetadataResponse, result providerResult) {
+	if result.err != nil {
+		log.Printf("⚠️  %s provider failed for %s: %v\n", result.provider.name, metadata.URL, result.err)
+		return
+	}
+	if result.provider.apply != nil {
+		result.provider.apply(metadata, result.data)
+		return
+	}
+	if metadata.ProviderData == nil {
+		metadata.ProviderData = make(map[string]interface{})
+	}
+	metadata.ProviderData[result.provider.name] = result.data
+}
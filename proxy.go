@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// proxyTimeout bounds a single proxied image fetch
+	proxyTimeout = 10 * time.Second
+
+	// defaultProxyMaxBytes is used when IMAGE_PROXY_MAX_BYTES is not set
+	defaultProxyMaxBytes = 10 * 1024 * 1024
+
+	// maxProxyWidth caps the w parameter
+	maxProxyWidth = 4096
+)
+
+// proxyPassthroughHeaders are copied from the origin response
+var proxyPassthroughHeaders = []string{"Cache-Control", "Etag", "Expires", "Last-Modified"}
+
+// proxyConditionalHeaders are forwarded to the origin so it can answer 304
+var proxyConditionalHeaders = []string{"If-None-Match", "If-Modified-Since"}
+
+// signImageURL returns the hex HMAC-SHA256 that authorizes proxying
+// imageURL. The prefix keeps it from being valid for another endpoint
+// signed with the same secret.
+func signImageURL(secret string, imageURL string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("proxy:" + imageURL))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// imageProxyURL returns the signed /img URL for imageURL
+func imageProxyURL(cfg *Config, imageURL string) string {
+	query := url.Values{}
+	query.Set("url", imageURL)
+	query.Set("sig", signImageURL(cfg.ImageProxySecret, imageURL))
+	return cfg.PublicURL + "/img?" + query.Encode()
+}
+
+// addProxyURLs signs every image candidate when the proxy is enabled
+func addProxyURLs(cfg *Config, metadata *MetadataResponse) {
+	if cfg.ImageProxySecret == "" {
+		return
+	}
+	for i := range metadata.ImageDetails {
+		metadata.ImageDetails[i].ProxyURL = imageProxyURL(cfg, metadata.ImageDetails[i].URL)
+	}
+}
+
+// imageProxyHandler streams a signed image URL through the service so that
+// clients never contact the origin directly
+func imageProxyHandler(store *configStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveImageProxy(store.Load(), w, r)
+	}
+}
+
+func serveImageProxy(cfg *Config, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		proxyError(w, http.StatusMethodNotAllowed, "Method not allowed. Use GET.")
+		return
+	}
+	if cfg.ImageProxySecret == "" {
+		proxyError(w, http.StatusNotFound, "Image proxy is disabled")
+		return
+	}
+
+	imageURL := r.URL.Query().Get("url")
+	sig := r.URL.Query().Get("sig")
+	if imageURL == "" || sig == "" {
+		proxyError(w, http.StatusBadRequest, "Both 'url' and 'sig' parameters are required")
+		return
+	}
+	if !hmac.Equal([]byte(sig), []byte(signImageURL(cfg.ImageProxySecret, imageURL))) {
+		proxyError(w, http.StatusForbidden, "Invalid signature")
+		return
+	}
+
+	var maxWidth int
+	if v := r.URL.Query().Get("w"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxProxyWidth {
+			proxyError(w, http.StatusBadRequest, fmt.Sprintf("'w' must be between 1 and %d", maxProxyWidth))
+			return
+		}
+		maxWidth = n
+	}
+
+	parsedURL, err := url.Parse(imageURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		proxyError(w, http.StatusBadRequest, "Invalid image URL")
+		return
+	}
+	if err := validateURLForSSRF(cfg, parsedURL); err != nil {
+		proxyError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), proxyTimeout)
+	defer cancel()
+
+	header := http.Header{"Accept": {"image/*"}}
+	for _, key := range proxyConditionalHeaders {
+		if v := r.Header.Get(key); v != "" && maxWidth == 0 {
+			header.Set(key, v)
+		}
+	}
+
+	resp, release, err := fetchURL(ctx, cfg, parsedURL, header)
+	if err != nil {
+		proxyError(w, http.StatusBadGateway, fmt.Sprintf("failed to fetch image: %v", err))
+		return
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		copyProxyHeaders(w, resp)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		proxyError(w, http.StatusBadGateway, fmt.Sprintf("HTTP error: %d", resp.StatusCode))
+		return
+	}
+
+	mediaType := responseMediaType(resp)
+	// SVG can carry script, so it is never served from our origin
+	if !strings.HasPrefix(mediaType, "image/") || mediaType == "image/svg+xml" {
+		proxyError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("unsupported content type: %s", mediaType))
+		return
+	}
+	if resp.ContentLength > cfg.ImageProxyMaxBytes {
+		proxyError(w, http.StatusRequestEntityTooLarge, "Image too large")
+		return
+	}
+
+	if maxWidth > 0 {
+		if serveResizedImage(w, r, resp, maxWidth, cfg.ImageProxyMaxBytes) {
+			return
+		}
+	}
+
+	copyProxyHeaders(w, resp)
+	w.Header().Set("Content-Type", mediaType)
+	if resp.ContentLength >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	n, err := io.Copy(w, io.LimitReader(resp.Body, cfg.ImageProxyMaxBytes+1))
+	if err == nil && n > cfg.ImageProxyMaxBytes {
+		// Headers are already sent, so the only way to signal failure is to abort
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// serveResizedImage downscales JPEG, PNG and GIF images wider than maxWidth,
+// sending only the headers for HEAD requests. It returns false, having
+// consumed nothing, when the image should be streamed as-is instead.
+func serveResizedImage(w http.ResponseWriter, r *http.Request, resp *http.Response, maxWidth int, maxBytes int64) bool {
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		proxyError(w, http.StatusBadGateway, fmt.Sprintf("failed to read image: %v", err))
+		return true
+	}
+	if int64(len(data)) > maxBytes {
+		proxyError(w, http.StatusRequestEntityTooLarge, "Image too large")
+		return true
+	}
+
+	imgCfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || imgCfg.Width <= maxWidth || imgCfg.Width*imgCfg.Height > maxColorImagePixels {
+		// Nothing to do or nothing we can do; pass the original through
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		resp.ContentLength = int64(len(data))
+		return false
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		resp.ContentLength = int64(len(data))
+		return false
+	}
+
+	var buf bytes.Buffer
+	contentType := "image/png"
+	scaled := downscaleImage(img, maxWidth)
+	if format == "jpeg" {
+		contentType = "image/jpeg"
+		err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, scaled)
+	}
+	if err != nil {
+		proxyError(w, http.StatusInternalServerError, "Failed to encode image")
+		return true
+	}
+
+	copyProxyHeaders(w, resp)
+	// The validators describe the original bytes, not ours
+	w.Header().Del("Etag")
+	w.Header().Del("Last-Modified")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if r.Method != http.MethodHead {
+		w.Write(buf.Bytes())
+	}
+	return true
+}
+
+// downscaleImage resizes img to width by averaging the source pixels covered
+// by each destination pixel
+func downscaleImage(img image.Image, width int) image.Image {
+	src := img.Bounds()
+	height := src.Dy() * width / src.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := src.Min.Y + y*src.Dy()/height
+		y1 := src.Min.Y + (y+1)*src.Dy()/height
+		for x := 0; x < width; x++ {
+			x0 := src.Min.X + x*src.Dx()/width
+			x1 := src.Min.X + (x+1)*src.Dx()/width
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+pr, g+pg, b+pb, a+pa
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+func copyProxyHeaders(w http.ResponseWriter, resp *http.Response) {
+	for _, key := range proxyPassthroughHeaders {
+		if v := resp.Header.Get(key); v != "" {
+			w.Header().Set(key, v)
+		}
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+}
+
+func proxyError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// testConfig returns the default configuration with SSRF protection lifted
+// for the loopback address httptest servers listen on
+func testConfig() *Config {
+	cfg := defaultConfig()
+	cfg.SSRFAllowHosts = []string{"127.0.0.1"}
+	return cfg
+}
+
+func TestSignImageURLIsDomainSeparated(t *testing.T) {
+	const secret, target = "secret", "https://example.com/a.png"
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(target))
+	if signImageURL(secret, target) == hex.EncodeToString(mac.Sum(nil)) {
+		t.Error("the image proxy signs the bare URL, which another endpoint may sign too")
+	}
+}
+
+func TestImageProxyHeadResized(t *testing.T) {
+	var wide bytes.Buffer
+	if err := png.Encode(&wide, image.NewRGBA(image.Rect(0, 0, 400, 100))); err != nil {
+		t.Fatal(err)
+	}
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(wide.Bytes())
+	}))
+	defer origin.Close()
+
+	cfg := testConfig()
+	cfg.ImageProxySecret = "secret"
+	imageURL := origin.URL + "/wide.png"
+	query := url.Values{"url": {imageURL}, "sig": {signImageURL(cfg.ImageProxySecret, imageURL)}, "w": {"100"}}
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		rec := httptest.NewRecorder()
+		serveImageProxy(cfg, rec, httptest.NewRequest(method, "/img?"+query.Encode(), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", method, rec.Code, rec.Body)
+		}
+		length, _ := strconv.Atoi(rec.Header().Get("Content-Length"))
+		if length == 0 || length == wide.Len() {
+			t.Errorf("%s: Content-Length %d, want that of the resized image", method, length)
+		}
+		want := length
+		if method == http.MethodHead {
+			want = 0
+		}
+		if rec.Body.Len() != want {
+			t.Errorf("%s: body is %d bytes, want %d", method, rec.Body.Len(), want)
+		}
+	}
+}
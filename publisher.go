@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Publisher is who publishes the page, for attribution
+type Publisher struct {
+	Name    string `json:"name,omitempty"`
+	LogoURL string `json:"logo_url,omitempty"`
+}
+
+// extractPublisher returns the page's publisher from the first JSON-LD
+// document that names one, falling back to og:site_name for its name
+func extractPublisher(doc *html.Node, metadata *MetadataResponse, baseURL *url.URL) *Publisher {
+	var publisher *Publisher
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if publisher != nil {
+			return
+		}
+		if n.Type == html.ElementNode && n.DataAtom == atom.Script && strings.EqualFold(strings.TrimSpace(attrValue(n, "type")), "application/ld+json") {
+			publisher = publisherFromJSONLD(textContent(n))
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	if publisher == nil {
+		publisher = &Publisher{}
+	}
+	if publisher.Name == "" && len(metadata.SiteName) > 0 {
+		publisher.Name = metadata.SiteName[0]
+	}
+	if publisher.LogoURL != "" {
+		publisher.LogoURL = resolveURL(publisher.LogoURL, baseURL)
+	}
+	if publisher.Name == "" && publisher.LogoURL == "" {
+		return nil
+	}
+	return publisher
+}
+
+// publisherFromJSONLD finds the publisher of a JSON-LD document: the first
+// publisher property, which may refer to a node of the @graph by its @id,
+// or else the first Organization
+func publisherFromJSONLD(data string) *Publisher {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		return nil
+	}
+
+	nodes := make(map[string]map[string]interface{})
+	var publisher, organization interface{}
+	var visit func(v interface{})
+	visit = func(v interface{}) {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, item := range v {
+				visit(item)
+			}
+		case map[string]interface{}:
+			if id, ok := v["@id"].(string); ok && len(v) > 1 {
+				nodes[id] = v
+			}
+			if p, ok := v["publisher"]; ok && publisher == nil {
+				publisher = p
+			}
+			if organization == nil && (hasJSONLDType(v["@type"], "Organization") || hasJSONLDType(v["@type"], "NewsMediaOrganization")) {
+				organization = v
+			}
+			for _, child := range v {
+				visit(child)
+			}
+		}
+	}
+	visit(doc)
+
+	if publisher == nil {
+		publisher = organization
+	}
+	if list, ok := publisher.([]interface{}); ok && len(list) > 0 {
+		publisher = list[0]
+	}
+	obj, ok := publisher.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	// A reference holds only the @id of a node described elsewhere
+	if id, ok := obj["@id"].(string); ok && len(obj) == 1 {
+		if obj = nodes[id]; obj == nil {
+			return nil
+		}
+	}
+
+	p := &Publisher{LogoURL: jsonLDURL(obj["logo"])}
+	if name, ok := obj["name"].(string); ok {
+		p.Name = strings.TrimSpace(name)
+	}
+	if p.Name == "" && p.LogoURL == "" {
+		return nil
+	}
+	return p
+}
@@ -0,0 +1,77 @@
+package main
+
+// Quality summarizes how well a page's preview will render: whether it
+// declares the Open Graph tags previews are built from, and a score from 0
+// to 100 that gives fields found through fallbacks less credit
+type Quality struct {
+	HasOGTitle       bool `json:"has_og_title"`
+	HasOGDescription bool `json:"has_og_description"`
+	HasOGImage       bool `json:"has_og_image"`
+	Score            int  `json:"score"`
+}
+
+// Points each field adds to Quality.Score, when it comes from Open Graph or
+// from a fallback such as <title>, a Twitter card or an <img>
+const (
+	qualityOGTitle             = 25
+	qualityFallbackTitle       = 15
+	qualityOGDescription       = 25
+	qualityFallbackDescription = 15
+	qualityOGImage             = 35
+	qualityFallbackImage       = 20
+	qualitySiteName            = 10
+	qualityFavicon             = 5
+)
+
+// noteOpenGraph records that the page declares an Open Graph property,
+// whether or not it is the one a field was taken from
+func noteOpenGraph(metadata *MetadataResponse, property string) {
+	if metadata.Quality == nil {
+		metadata.Quality = &Quality{}
+	}
+	switch property {
+	case "og:title":
+		metadata.Quality.HasOGTitle = true
+	case "og:description":
+		metadata.Quality.HasOGDescription = true
+	case "og:image", "og:image:url":
+		metadata.Quality.HasOGImage = true
+	}
+}
+
+// scoreQuality sets Quality.Score from the fields extraction ended up with.
+// It runs before finishSources, while image sources are still known.
+func scoreQuality(metadata *MetadataResponse) {
+	if metadata.Quality == nil {
+		metadata.Quality = &Quality{}
+	}
+	q := metadata.Quality
+	score := 0
+	switch {
+	case metadata.Title != "" && q.HasOGTitle:
+		score += qualityOGTitle
+	case metadata.Title != "":
+		score += qualityFallbackTitle
+	}
+	switch {
+	case metadata.Description != "" && q.HasOGDescription:
+		score += qualityOGDescription
+	case metadata.Description != "":
+		score += qualityFallbackDescription
+	}
+	if len(metadata.ImageDetails) > 0 {
+		switch metadata.ImageDetails[0].Source {
+		case "og:image", "og:image:url":
+			score += qualityOGImage
+		default:
+			score += qualityFallbackImage
+		}
+	}
+	if len(metadata.SiteName) > 0 && metadata.Sources["sitename"] != "domain" {
+		score += qualitySiteName
+	}
+	if metadata.Favicon != "" && metadata.Sources["favicon"] != "fallback" {
+		score += qualityFavicon
+	}
+	q.Score = score
+}
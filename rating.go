@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Values of ContentRating.Adult
+const (
+	adultYes     = "true"
+	adultNo      = "false"
+	adultUnknown = "unknown"
+)
+
+const (
+	// rtaLabel is the Restricted To Adults label adult sites declare
+	rtaLabel = "rta-5042-1996-1400-1577-rta"
+
+	// maxRatings and maxRatingBytes bound the <meta name="rating"> values
+	// kept, which are short labels on any honest page
+	maxRatings     = 10
+	maxRatingBytes = 100
+)
+
+// ContentRating is what a page declares about its audience, as written, with
+// Adult derived from it. Nothing is inferred from the content itself.
+type ContentRating struct {
+	Rating         []string `json:"rating,omitempty"`          // <meta name="rating">
+	AgeRestriction string   `json:"age_restriction,omitempty"` // og:restrictions:age
+	FamilyFriendly string   `json:"family_friendly,omitempty"` // schema.org isFamilyFriendly
+	Adult          string   `json:"adult"`
+}
+
+// extractContentRating collects the page's rating declarations
+func extractContentRating(doc *html.Node, maxMetaContent int) *ContentRating {
+	rating := &ContentRating{}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Script:
+				if rating.FamilyFriendly == "" && strings.EqualFold(strings.TrimSpace(attrValue(n, "type")), "application/ld+json") {
+					rating.FamilyFriendly = familyFriendlyFromJSONLD(textContent(n))
+				}
+			case atom.Meta:
+				content := strings.TrimSpace(metaContent(n, maxMetaContent))
+				switch {
+				case content == "":
+				case strings.EqualFold(attrValue(n, "name"), "rating"):
+					rating.addRating(content)
+				case strings.EqualFold(attrValue(n, "property"), "og:restrictions:age"):
+					if rating.AgeRestriction == "" {
+						rating.AgeRestriction = content
+					}
+				case attrValue(n, "itemprop") == "isFamilyFriendly":
+					if rating.FamilyFriendly == "" {
+						rating.FamilyFriendly = content
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	rating.Adult = rating.adult()
+	return rating
+}
+
+// addRating keeps a rating value, cut to maxRatingBytes, unless maxRatings
+// were kept already or it repeats one regardless of case
+func (r *ContentRating) addRating(value string) {
+	if len(r.Rating) >= maxRatings {
+		return
+	}
+	if len(value) > maxRatingBytes {
+		value = truncateUTF8(value, maxRatingBytes)
+	}
+	for _, kept := range r.Rating {
+		if strings.EqualFold(kept, value) {
+			return
+		}
+	}
+	r.Rating = append(r.Rating, value)
+}
+
+// adult derives Adult: any declaration of adult content makes it "true",
+// otherwise any declaration of a general audience makes it "false"
+func (r *ContentRating) adult() string {
+	adult, general := false, false
+	for _, value := range r.Rating {
+		switch strings.ToLower(value) {
+		case "adult", "mature", "restricted", rtaLabel:
+			adult = true
+		case "general", "safe for kids":
+			general = true
+		}
+	}
+	if age, ok := parseAgeRestriction(r.AgeRestriction); ok {
+		if age >= 18 {
+			adult = true
+		} else {
+			general = true
+		}
+	}
+	if friendly, ok := parseSchemaBoolean(r.FamilyFriendly); ok {
+		if friendly {
+			general = true
+		} else {
+			adult = true
+		}
+	}
+
+	switch {
+	case adult:
+		return adultYes
+	case general:
+		return adultNo
+	}
+	return adultUnknown
+}
+
+// parseAgeRestriction reads the minimum age of an og:restrictions:age value
+// such as "18+" or "21"
+func parseAgeRestriction(s string) (int, bool) {
+	age, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(s), "+"))
+	return age, err == nil && age > 0
+}
+
+// familyFriendlyFromJSONLD returns the first isFamilyFriendly value in a
+// JSON-LD document, as text
+func familyFriendlyFromJSONLD(data string) string {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		return ""
+	}
+
+	var find func(v interface{}) string
+	find = func(v interface{}) string {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, item := range v {
+				if found := find(item); found != "" {
+					return found
+				}
+			}
+		case map[string]interface{}:
+			switch value := v["isFamilyFriendly"].(type) {
+			case bool:
+				return strconv.FormatBool(value)
+			case string:
+				return strings.TrimSpace(value)
+			}
+			if found := find(v["@graph"]); found != "" {
+				return found
+			}
+		}
+		return ""
+	}
+	return find(doc)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestExtractContentRatingFixtures(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    ContentRating
+	}{
+		{"rta.html", ContentRating{Rating: []string{"RTA-5042-1996-1400-1577-RTA"}, Adult: adultYes}},
+		{"general.html", ContentRating{Rating: []string{"General", "safe for kids"}, Adult: adultNo}},
+		{"og-age.html", ContentRating{AgeRestriction: "21+", Adult: adultYes}},
+		{"jsonld-family-friendly.html", ContentRating{FamilyFriendly: "true", Adult: adultNo}},
+		{"microdata-not-family-friendly.html", ContentRating{FamilyFriendly: "https://schema.org/False", Adult: adultYes}},
+		{"conflicting.html", ContentRating{Rating: []string{"general"}, AgeRestriction: "18", FamilyFriendly: "true", Adult: adultYes}},
+		{"undeclared.html", ContentRating{Rating: []string{"5 stars"}, AgeRestriction: "none", Adult: adultUnknown}},
+	}
+	for _, tt := range tests {
+		metadata := parseFixture(t, "rating/"+tt.fixture, "https://example.com/", ExtractOptions{})
+		if metadata.ContentRating == nil || !reflect.DeepEqual(*metadata.ContentRating, tt.want) {
+			t.Errorf("%s: content rating %+v, want %+v", tt.fixture, metadata.ContentRating, tt.want)
+		}
+	}
+}
+
+func TestContentRatingAdult(t *testing.T) {
+	tests := []struct {
+		rating ContentRating
+		want   string
+	}{
+		{ContentRating{}, adultUnknown},
+		{ContentRating{Rating: []string{"Mature"}}, adultYes},
+		{ContentRating{Rating: []string{"restricted", "general"}}, adultYes},
+		{ContentRating{AgeRestriction: "17"}, adultNo},
+		{ContentRating{AgeRestriction: "0"}, adultUnknown},
+		{ContentRating{FamilyFriendly: "False"}, adultYes},
+		{ContentRating{FamilyFriendly: "http://schema.org/True"}, adultNo},
+		{ContentRating{FamilyFriendly: "yes"}, adultUnknown},
+	}
+	for _, tt := range tests {
+		if got := tt.rating.adult(); got != tt.want {
+			t.Errorf("%+v: adult = %q, want %q", tt.rating, got, tt.want)
+		}
+	}
+}
+
+func TestExtractContentRatingBounded(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("<html><head>")
+	for i := 0; i < 3; i++ {
+		b.WriteString(`<meta name="rating" content="General"><meta name="rating" content="GENERAL">`)
+	}
+	b.WriteString(`<meta name="rating" content="` + strings.Repeat("x", 5000) + `">`)
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&b, `<meta name="rating" content="label %d">`, i)
+	}
+	b.WriteString("</head></html>")
+	doc, err := html.Parse(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rating := extractContentRating(doc, defaultMaxMetaContentBytes)
+	if len(rating.Rating) != maxRatings {
+		t.Fatalf("kept %d ratings, want %d", len(rating.Rating), maxRatings)
+	}
+	if rating.Rating[0] != "General" || len(rating.Rating[1]) != maxRatingBytes || rating.Rating[2] != "label 0" {
+		t.Errorf("ratings = %.40q", rating.Rating)
+	}
+}
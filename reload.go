@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// configStore holds the live configuration. Handlers load it once per request
+// so a request sees one consistent configuration even if a reload lands
+// while it is running.
+type configStore struct {
+	args    []string
+	mu      sync.Mutex // serializes reloads
+	current atomic.Pointer[Config]
+}
+
+func newConfigStore(cfg *Config, args []string) *configStore {
+	store := &configStore{args: args}
+	store.current.Store(cfg)
+	return store
+}
+
+// Load returns the current configuration, which must not be modified
+func (s *configStore) Load() *Config {
+	return s.current.Load()
+}
+
+// reload re-reads the configuration from the same sources as at startup and
+// swaps in the reloadable settings. On error the current configuration is
+// kept. It returns the names of the settings that changed.
+func (s *configStore) reload() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fresh, err := loadConfig(s.args)
+	if err != nil {
+		return nil, err
+	}
+
+	current := s.Load()
+	next := *current
+	var changed []string
+	for _, setting := range settings {
+		compare := setting.get
+		if setting.fingerprint != nil {
+			compare = setting.fingerprint
+		}
+		if compare(fresh) == compare(current) {
+			continue
+		}
+		if !setting.reloadable {
+			log.Printf("⚠️  %s changed but requires a restart to take effect\n", setting.name)
+			continue
+		}
+		// Values round-trip through their string form, which set already validates
+		if err := setting.set(&next, setting.get(fresh)); err != nil {
+			return nil, err
+		}
+		changed = append(changed, setting.name)
+	}
+
+	s.current.Store(&next)
+	// Cached SSRF results were computed against the old blocklists, and
+	// cached metadata was fetched under them
+	ssrfValidations.clear()
+	metadataCache.clear()
+	return changed, nil
+}
+
+// reloadConfig reloads store and logs the outcome
+func reloadConfig(store *configStore) ([]string, error) {
+	changed, err := store.reload()
+	if err != nil {
+		log.Printf("❌ Configuration reload failed, keeping current configuration: %v\n", err)
+		return nil, err
+	}
+	if len(changed) == 0 {
+		log.Println("🔄 Configuration reloaded, nothing changed")
+	} else {
+		log.Printf("🔄 Configuration reloaded, changed: %s\n", strings.Join(changed, ", "))
+	}
+	return changed, nil
+}
+
+// adminReloadHandler triggers the same reload as SIGHUP
+func adminReloadHandler(store *configStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(store.Load(), w, r) {
+			return
+		}
+		if r.Method != http.MethodPost {
+			proxyError(w, http.StatusMethodNotAllowed, "Method not allowed. Use POST.")
+			return
+		}
+
+		changed, err := reloadConfig(store)
+		if err != nil {
+			proxyError(w, http.StatusUnprocessableEntity, "reload failed, keeping current configuration: "+err.Error())
+			return
+		}
+		if changed == nil {
+			changed = []string{}
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":  "reloaded",
+			"changed": changed,
+		})
+	}
+}
+
+// adminStatsHandler reports internal counters
+func adminStatsHandler(store *configStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(store.Load(), w, r) {
+			return
+		}
+		if r.Method != http.MethodGet {
+			proxyError(w, http.StatusMethodNotAllowed, "Method not allowed. Use GET.")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"dns_cache":        dnsCache.stats(),
+			"circuit_breakers": breakers.stats(),
+			"hedging":          hedges.stats(),
+			"metadata_cache":   metadataCache.stats(),
+			"inflight":         inflight.stats(),
+			"fetch_queue":      fetchQueue.stats(),
+			"connections":      connections.stats(),
+			"encode_failures":  encodeFailures.Load(),
+		})
+	}
+}
+
+// adminBreakerResetHandler closes the circuit breaker for ?domain=, or all of
+// them when no domain is given
+func adminBreakerResetHandler(store *configStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(store.Load(), w, r) {
+			return
+		}
+		if r.Method != http.MethodPost {
+			proxyError(w, http.StatusMethodNotAllowed, "Method not allowed. Use POST.")
+			return
+		}
+
+		n := breakers.reset(r.URL.Query().Get("domain"))
+		log.Printf("🔌 Reset %d circuit breaker(s)\n", n)
+		writeJSON(w, http.StatusOK, map[string]int{"reset": n})
+	}
+}
+
+// authorizeAdmin checks the bearer token of an admin request, writing the
+// error response when it fails. Admin endpoints don't exist without a token.
+func authorizeAdmin(cfg *Config, w http.ResponseWriter, r *http.Request) bool {
+	if cfg.AdminToken == "" {
+		proxyError(w, http.StatusNotFound, "Not found")
+		return false
+	}
+	if !hasAdminToken(cfg, r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		proxyError(w, http.StatusUnauthorized, "Unauthorized")
+		return false
+	}
+	return true
+}
+
+// hasAdminToken reports whether r carries ADMIN_TOKEN, which must be set
+func hasAdminToken(cfg *Config, r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return cfg.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// defaultDNSTimeout bounds one attempt against the configured resolver
+	// when DNS_TIMEOUT is not set
+	defaultDNSTimeout = 3 * time.Second
+
+	// maxDoHResponseBytes caps a DNS-over-HTTPS answer
+	maxDoHResponseBytes = 64 * 1024
+)
+
+// newResolver builds the resolver described by cfg: DNS-over-HTTPS, explicit
+// DNS servers or the system resolver, optionally falling back to the system
+// resolver when the configured one can't be reached
+func newResolver(cfg *Config) resolver {
+	var primary resolver
+	switch {
+	case cfg.DoHURL != "":
+		primary = &dohResolver{url: cfg.DoHURL, client: &http.Client{Timeout: cfg.DNSTimeout}}
+	case len(cfg.DNSServers) > 0:
+		primary = newServerResolver(cfg.DNSServers, cfg.DNSTimeout)
+	default:
+		return systemResolver{}
+	}
+	if cfg.DNSFallback {
+		return fallbackResolver{primary: primary, fallback: systemResolver{}}
+	}
+	return primary
+}
+
+// serverResolver sends queries to an explicit list of DNS servers instead of
+// the ones the system is configured with
+type serverResolver struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+}
+
+func newServerResolver(servers []string, timeout time.Duration) *serverResolver {
+	d := &net.Dialer{Timeout: timeout}
+	return &serverResolver{
+		timeout: timeout,
+		resolver: &net.Resolver{
+			PreferGo: true,
+			// Each query dials the servers in order until one accepts; UDP
+			// "connections" always succeed, so unanswered queries fail by
+			// timing out
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var firstErr error
+				for _, server := range servers {
+					conn, err := d.DialContext(ctx, network, server)
+					if err == nil {
+						return conn, nil
+					}
+					if firstErr == nil {
+						firstErr = err
+					}
+				}
+				return nil, firstErr
+			},
+		},
+	}
+}
+
+func (r *serverResolver) lookupIP(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	addrs, err := r.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, 0, nil
+}
+
+func (r *serverResolver) lookupNS(ctx context.Context, domain string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return nsHosts(r.resolver.LookupNS(ctx, domain))
+}
+
+// dohResolver resolves over DNS-over-HTTPS (RFC 8484). Its client uses the
+// default transport so that reaching the DoH server never goes through the
+// resolver it implements.
+type dohResolver struct {
+	url    string
+	client *http.Client
+}
+
+func (r *dohResolver) lookupIP(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	type answer struct {
+		ips []net.IP
+		ttl time.Duration
+		err error
+	}
+	answers := make(chan answer, 2)
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		go func(qtype dnsmessage.Type) {
+			ips, ttl, err := r.query(ctx, host, qtype)
+			answers <- answer{ips, ttl, err}
+		}(qtype)
+	}
+
+	var ips []net.IP
+	var ttl time.Duration
+	var firstErr error
+	for i := 0; i < 2; i++ {
+		a := <-answers
+		if a.err != nil {
+			if firstErr == nil {
+				firstErr = a.err
+			}
+			continue
+		}
+		ips = append(ips, a.ips...)
+		if len(a.ips) > 0 && (ttl == 0 || a.ttl < ttl) {
+			ttl = a.ttl
+		}
+	}
+	if len(ips) > 0 {
+		return ips, ttl, nil
+	}
+	if firstErr != nil {
+		return nil, 0, firstErr
+	}
+	return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// query asks the DoH server for one record type and returns the addresses
+// with the lowest TTL among them
+func (r *dohResolver) query(ctx context.Context, host string, qtype dnsmessage.Type) ([]net.IP, time.Duration, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: "invalid hostname", Name: host}
+	}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		var netErr net.Error
+		timeout := errors.As(err, &netErr) && netErr.Timeout()
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: host, Server: r.url, IsTimeout: timeout, IsTemporary: true}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, &net.DNSError{Err: fmt.Sprintf("DoH server returned HTTP %d", resp.StatusCode), Name: host, Server: r.url, IsTemporary: true}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDoHResponseBytes))
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: host, Server: r.url, IsTemporary: true}
+	}
+
+	var reply dnsmessage.Message
+	if err := reply.Unpack(body); err != nil {
+		return nil, 0, &net.DNSError{Err: "malformed DoH response", Name: host, Server: r.url}
+	}
+	switch reply.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, Server: r.url, IsNotFound: true}
+	default:
+		return nil, 0, &net.DNSError{Err: "server answered " + reply.RCode.String(), Name: host, Server: r.url, IsTemporary: true}
+	}
+
+	var ips []net.IP
+	var ttl uint32
+	for _, rr := range reply.Answers {
+		var ip net.IP
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			ip = net.IP(body.A[:])
+		case *dnsmessage.AAAAResource:
+			ip = net.IP(body.AAAA[:])
+		default:
+			// CNAMEs are followed by the server; their targets' records follow
+			continue
+		}
+		ips = append(ips, ip)
+		if len(ips) == 1 || rr.Header.TTL < ttl {
+			ttl = rr.Header.TTL
+		}
+	}
+	return ips, time.Duration(ttl) * time.Second, nil
+}
+
+// fallbackResolver uses fallback when primary can't give an answer. A name
+// that primary says doesn't exist is not looked up again.
+type fallbackResolver struct {
+	primary  resolver
+	fallback resolver
+}
+
+func (r fallbackResolver) lookupIP(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	ips, ttl, err := r.primary.lookupIP(ctx, host)
+	var dnsErr *net.DNSError
+	if err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound) || ctx.Err() != nil {
+		return ips, ttl, err
+	}
+	log.Printf("⚠️  DNS lookup of %s failed, falling back to the system resolver: %v\n", host, err)
+	return r.fallback.lookupIP(ctx, host)
+}
+
+// lookupNS asks primary only, since the nameserver check is advisory and
+// isn't worth a second resolver
+func (r fallbackResolver) lookupNS(ctx context.Context, domain string) ([]string, error) {
+	if ns, ok := r.primary.(nsResolver); ok {
+		return ns.lookupNS(ctx, domain)
+	}
+	return nil, errNSUnsupported
+}
+
+// parseDNSServers parses a comma-separated list of DNS server addresses,
+// defaulting to port 53
+func parseDNSServers(v string) ([]string, error) {
+	var servers []string
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(item); err != nil {
+			item = net.JoinHostPort(strings.Trim(item, "[]"), "53")
+		}
+		host, _, _ := net.SplitHostPort(item)
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("invalid DNS server %q (expected an IP address, optionally with a port)", item)
+		}
+		servers = append(servers, item)
+	}
+	return servers, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fixedResolver answers every lookup with ip and counts the lookups
+type fixedResolver struct {
+	ip      net.IP
+	lookups *int
+}
+
+func (r fixedResolver) lookupIP(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	*r.lookups++
+	return []net.IP{r.ip}, 0, nil
+}
+
+// deadDNSServer returns the address of a UDP port nothing listens on
+func deadDNSServer(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+	return addr
+}
+
+// silentDNSServer returns the address of a UDP server that reads queries
+// and never answers them
+func silentDNSServer(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// dohServer returns a DoH endpoint that answers every query with rcode
+// after delay, or with an HTTP status when status isn't 200
+func dohServer(t *testing.T, status int, rcode dnsmessage.RCode, delay time.Duration) string {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		if status != http.StatusOK {
+			http.Error(w, "resolver unavailable", status)
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reply := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true, RCode: rcode},
+			Questions: query.Questions,
+		}
+		packed, _ := reply.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestResolverFailures(t *testing.T) {
+	const timeout = 300 * time.Millisecond
+	tests := []struct {
+		name        string
+		cfg         func(cfg *Config)
+		wantTimeout bool
+		// notFound answers must not be looked up again
+		notFound bool
+	}{
+		{"dead DNS server", func(cfg *Config) { cfg.DNSServers = []string{deadDNSServer(t)} }, false, false},
+		{"silent DNS server", func(cfg *Config) { cfg.DNSServers = []string{silentDNSServer(t)} }, true, false},
+		{"DoH server error", func(cfg *Config) { cfg.DoHURL = dohServer(t, http.StatusBadGateway, 0, 0) }, false, false},
+		{"slow DoH server", func(cfg *Config) { cfg.DoHURL = dohServer(t, http.StatusOK, 0, 5*time.Second) }, true, false},
+		{"DoH SERVFAIL", func(cfg *Config) { cfg.DoHURL = dohServer(t, http.StatusOK, dnsmessage.RCodeServerFailure, 0) }, false, false},
+		{"DoH NXDOMAIN", func(cfg *Config) { cfg.DoHURL = dohServer(t, http.StatusOK, dnsmessage.RCodeNameError, 0) }, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.DNSTimeout = timeout
+			tt.cfg(cfg)
+
+			start := time.Now()
+			_, _, err := newResolver(cfg).lookupIP(context.Background(), "example.test")
+			if elapsed := time.Since(start); elapsed > 3*timeout {
+				t.Errorf("lookup took %s with DNS_TIMEOUT %s", elapsed, timeout)
+			}
+			var dnsErr *net.DNSError
+			if !errors.As(err, &dnsErr) {
+				t.Fatalf("err = %v, want a *net.DNSError", err)
+			}
+			if dnsErr.IsTimeout != tt.wantTimeout || dnsErr.IsNotFound != tt.notFound {
+				t.Errorf("err = %v, IsTimeout %t and IsNotFound %t", err, dnsErr.IsTimeout, dnsErr.IsNotFound)
+			}
+			if code := errorCode(err); code != codeDNSFailure {
+				t.Errorf("error code %q, want %q", code, codeDNSFailure)
+			}
+
+			// With DNS_FALLBACK the same failure is retried elsewhere, unless
+			// the name doesn't exist
+			var lookups int
+			fallback := fixedResolver{ip: net.IPv4(192, 0, 2, 1), lookups: &lookups}
+			primary := newResolver(cfg)
+			ips, _, err := fallbackResolver{primary: primary, fallback: fallback}.lookupIP(context.Background(), "example.test")
+			switch {
+			case tt.notFound && (err == nil || lookups != 0):
+				t.Errorf("nonexistent name was looked up again: ips %v, err %v", ips, err)
+			case !tt.notFound && (err != nil || lookups != 1 || !ips[0].Equal(fallback.ip)):
+				t.Errorf("fallback gave ips %v, err %v after %d lookups", ips, err, lookups)
+			}
+		})
+	}
+}
+
+func TestNewResolverFallback(t *testing.T) {
+	cfg := defaultConfig()
+	if _, ok := newResolver(cfg).(systemResolver); !ok {
+		t.Errorf("without DNS_SERVERS or DOH_URL the system resolver should be used")
+	}
+	cfg.DNSServers = []string{"127.0.0.1:53"}
+	if _, ok := newResolver(cfg).(fallbackResolver); ok {
+		t.Errorf("DNS_SERVERS fell back without DNS_FALLBACK")
+	}
+	cfg.DNSFallback = true
+	if r, ok := newResolver(cfg).(fallbackResolver); !ok {
+		t.Errorf("DNS_FALLBACK didn't fall back")
+	} else if _, ok := r.fallback.(systemResolver); !ok {
+		t.Errorf("fallback is %T, want the system resolver", r.fallback)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// encodeFailures counts responses that couldn't be encoded, for /admin/stats
+var encodeFailures atomic.Int64
+
+// prettyWriter marks a response whose JSON should be indented, as asked for
+// with ?pretty=1
+type prettyWriter struct {
+	http.ResponseWriter
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w prettyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// prettyMiddleware indents the JSON of requests with ?pretty=1, for people
+// reading responses in a browser or terminal
+func prettyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("pretty") == "1" {
+			w = prettyWriter{w}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON encodes v in full before writing anything, so that the status
+// and Content-Length match the body. When v can't be encoded the client gets
+// a 500 with a complete error body instead of truncated JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	var callback string
+	if jw, ok := w.(jsonpWriter); ok {
+		callback, w = jw.callback, jw.ResponseWriter
+	}
+
+	var body []byte
+	var err error
+	if _, pretty := w.(prettyWriter); pretty {
+		body, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		body, err = json.Marshal(v)
+	}
+	if err != nil {
+		encodeFailures.Add(1)
+		log.Printf("❌ Failed to encode %T response: %v\n", v, err)
+		status = http.StatusInternalServerError
+		body, _ = json.Marshal(map[string]string{
+			"error":      "failed to encode response",
+			"code":       codeInternal,
+			"request_id": w.Header().Get("X-Request-ID"),
+		})
+	}
+	contentType := "application/json"
+	if callback != "" {
+		// Script tags don't run responses with error statuses, so the status
+		// goes to the callback instead. The leading comment keeps the body
+		// from starting with bytes the client chose.
+		body = []byte(fmt.Sprintf("/**/%s(%s, %d);", callback, body, status))
+		contentType = "application/javascript"
+		status = http.StatusOK
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+	body = append(body, '\n')
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
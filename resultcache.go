@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultMetadataCacheMaxTTL is used when METADATA_CACHE_MAX_TTL is not set
+	defaultMetadataCacheMaxTTL = time.Hour
+
+	// maxMetadataCacheEntries bounds the cache; expired, then oldest, entries
+	// are evicted when it fills up
+	maxMetadataCacheEntries = 1024
+)
+
+// metadataCache holds extraction results for METADATA_CACHE_TTL, or the
+// request's cache_ttl_ms
+var metadataCache = newResultCache()
+
+// resultCache stores extraction results as JSON, so every hit gets its own
+// copy to modify
+type resultCache struct {
+	mu      sync.Mutex
+	entries map[string]*resultEntry
+	hits    atomic.Int64
+	misses  atomic.Int64
+}
+
+type resultEntry struct {
+	data    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+func newResultCache() *resultCache {
+	return &resultCache{entries: make(map[string]*resultEntry)}
+}
+
+// MetadataCacheStats reports the result cache's size and effectiveness
+type MetadataCacheStats struct {
+	Entries int     `json:"entries"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+func (c *resultCache) stats() MetadataCacheStats {
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+
+	s := MetadataCacheStats{Entries: entries, Hits: c.hits.Load(), Misses: c.misses.Load()}
+	if total := s.Hits + s.Misses; total > 0 {
+		s.HitRate = float64(s.Hits) / float64(total)
+	}
+	return s
+}
+
+// get returns the result stored for key, marked with when it was stored,
+// unless it expired or is older than ttl, which lets a request for a
+// volatile page skip a result another request was happy to keep for longer
+func (c *resultCache) get(key string, ttl time.Duration) *MetadataResponse {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+
+	now := time.Now()
+	if !ok || now.After(entry.expires) || now.Sub(entry.stored) >= ttl {
+		c.misses.Add(1)
+		return nil
+	}
+	var metadata MetadataResponse
+	if err := json.Unmarshal(entry.data, &metadata); err != nil {
+		c.misses.Add(1)
+		return nil
+	}
+	c.hits.Add(1)
+	age := int64(now.Sub(entry.stored) / time.Second)
+	metadata.Cached = true
+	metadata.CachedAt = entry.stored.UTC().Format(time.RFC3339)
+	metadata.Age = &age
+	return &metadata
+}
+
+// put stores metadata under key for ttl
+func (c *resultCache) put(key string, metadata *MetadataResponse, ttl time.Duration) {
+	stored := *metadata
+	stored.Debug = nil
+	// The favicon is inlined again for each request that asks
+	stored.FaviconData = ""
+	dropWarnings(&stored, warnFaviconNotInlined)
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxMetadataCacheEntries {
+		c.evict(now)
+	}
+	c.entries[key] = &resultEntry{data: data, stored: now, expires: now.Add(ttl)}
+}
+
+// evict drops expired entries, or the oldest one when none has expired.
+// c.mu must be held.
+func (c *resultCache) evict(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldestKey == "" || entry.stored.Before(oldest) {
+			oldestKey, oldest = key, entry.stored
+		}
+	}
+	if len(c.entries) >= maxMetadataCacheEntries {
+		delete(c.entries, oldestKey)
+	}
+}
+
+// clear forgets every result, for when the configuration they were
+// extracted under changes
+func (c *resultCache) clear() {
+	c.mu.Lock()
+	c.entries = make(map[string]*resultEntry)
+	c.mu.Unlock()
+}
+
+// metadataCacheTTL is how long a result extracted with opts may be reused:
+// the request's cache_ttl_ms, or METADATA_CACHE_TTL, capped at
+// METADATA_CACHE_MAX_TTL. A METADATA_CACHE_TTL of 0 turns the cache off for
+// every request.
+func metadataCacheTTL(cfg *Config, opts ExtractOptions) time.Duration {
+	if cfg.MetadataCacheTTL == 0 {
+		return 0
+	}
+	if opts.CacheTTLMs != nil {
+		// Compared in milliseconds so a huge value can't overflow
+		if *opts.CacheTTLMs >= cfg.MetadataCacheMaxTTL.Milliseconds() {
+			return cfg.MetadataCacheMaxTTL
+		}
+		return time.Duration(*opts.CacheTTLMs) * time.Millisecond
+	}
+	return min(cfg.MetadataCacheTTL, cfg.MetadataCacheMaxTTL)
+}
+
+// metadataCacheKey identifies a result by its URL and the options that
+// shape it. inline_favicon, follow_canonical and follow_hreflang are
+// applied to cached results, so they don't count.
+func metadataCacheKey(targetURL string, opts ExtractOptions) string {
+	opts.CacheTTLMs = nil
+	opts.InlineFavicon = false
+	opts.FollowCanonical = false
+	opts.FollowHreflang, opts.Lang = false, ""
+	key, _ := json.Marshal(opts)
+	return targetURL + "\n" + string(key)
+}
+
+// extractMetadata returns the metadata of targetURL. Icon data is never
+// stored in the cache, which bounds entries rather than bytes: a fresh
+// extraction inlines the favicon alongside its other enrichment fetches,
+// and the favicon of a cached, simple or partial result, which had none,
+// is inlined here.
+func extractMetadata(ctx context.Context, cfg *Config, targetURL string, opts ExtractOptions) (*MetadataResponse, error) {
+	metadata, err := cachedMetadata(ctx, cfg, targetURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Canonicals are followed from the edition in the requested language
+	if opts.FollowHreflang && opts.Mode != modeSimple && !metadata.Partial {
+		metadata = followHreflang(ctx, cfg, metadata, opts)
+	}
+	if opts.FollowCanonical && opts.Mode != modeSimple && !metadata.Partial {
+		followCanonical(ctx, cfg, metadata, opts)
+	}
+	if opts.InlineFavicon && (metadata.Cached || metadata.Partial || opts.Mode == modeSimple) {
+		inlineFavicon(ctx, cfg, metadata)
+	}
+	return metadata, nil
+}
+
+// cachedMetadata returns the metadata of targetURL, from the cache when a
+// recent enough result is there
+func cachedMetadata(ctx context.Context, cfg *Config, targetURL string, opts ExtractOptions) (*MetadataResponse, error) {
+	ttl := metadataCacheTTL(cfg, opts)
+	if ttl <= 0 {
+		return extractFreshMetadata(ctx, cfg, targetURL, opts)
+	}
+
+	// The host lists, or what the host resolves to, may have changed since
+	// the result was stored. A URL that doesn't parse is left to fail in
+	// extractFreshMetadata.
+	if parsedURL, err := url.Parse(targetURL); err == nil {
+		if err := validateURLForSSRF(cfg, parsedURL); err != nil {
+			return nil, err
+		}
+	}
+
+	startTime := time.Now()
+	key := metadataCacheKey(targetURL, opts)
+	if metadata := metadataCache.get(key, ttl); metadata != nil {
+		metadata.Duration = time.Since(startTime).Milliseconds()
+		if opts.Debug {
+			metadata.Debug = &DebugInfo{CacheTTLMs: ttl.Milliseconds(), CacheHit: true}
+		}
+		return metadata, nil
+	}
+
+	metadata, err := extractFreshMetadata(ctx, cfg, targetURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	// A partial result is only what this request had time for
+	if metadata.Partial {
+		return metadata, nil
+	}
+	if metadata.Soft404 {
+		ttl = min(ttl, soft404CacheTTL)
+	}
+	metadataCache.put(key, metadata, ttl)
+	if metadata.Debug != nil {
+		metadata.Debug.CacheTTLMs = ttl.Milliseconds()
+	}
+	return metadata, nil
+}
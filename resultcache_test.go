@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// A result cached before its host was blocked must not be served after
+func TestCachedMetadataRechecksHost(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>Cached</title></head></html>"))
+	}))
+	defer origin.Close()
+	target := origin.URL + "/recheck"
+
+	cfg := testConfig()
+	cfg.MetadataCacheTTL = time.Minute
+	if _, err := extractMetadata(context.Background(), cfg, target, ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := extractMetadata(context.Background(), cfg, target, ExtractOptions{})
+	if err != nil || !metadata.Cached {
+		t.Fatalf("second extraction wasn't a cache hit: %+v, %v", metadata, err)
+	}
+
+	blocked := *cfg
+	blocked.BlockedHosts = []string{"127.0.0.1"}
+	if _, err := extractMetadata(context.Background(), &blocked, target, ExtractOptions{}); errorCode(err) != codeBlocked {
+		t.Errorf("blocked host got %v, want a %s error", err, codeBlocked)
+	}
+	allowed := *cfg
+	allowed.AllowedHosts = []string{"example.com"}
+	if _, err := extractMetadata(context.Background(), &allowed, target, ExtractOptions{}); errorCode(err) != codeBlocked {
+		t.Errorf("host missing from ALLOWED_HOSTS got %v, want a %s error", err, codeBlocked)
+	}
+}
+
+func TestReloadClearsMetadataCache(t *testing.T) {
+	metadataCache.put("https://example.com/reload", &MetadataResponse{URL: "https://example.com/reload"}, time.Minute)
+	store := newConfigStore(defaultConfig(), nil)
+	if _, err := store.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if n := metadataCache.stats().Entries; n != 0 {
+		t.Errorf("%d cached results survived the reload", n)
+	}
+}
+
+func TestMetadataCacheTTL(t *testing.T) {
+	ms := func(v int64) *int64 { return &v }
+	tests := []struct {
+		ttl, maxTTL time.Duration
+		requested   *int64
+		want        time.Duration
+	}{
+		{0, time.Hour, nil, 0},
+		{0, time.Hour, ms(60000), 0},
+		{time.Minute, time.Hour, nil, time.Minute},
+		{time.Minute, time.Hour, ms(1000), time.Second},
+		{time.Minute, time.Hour, ms(0), 0},
+		{time.Minute, time.Hour, ms(1 << 62), time.Hour},
+		{2 * time.Hour, time.Hour, nil, time.Hour},
+	}
+	for _, tt := range tests {
+		cfg := defaultConfig()
+		cfg.MetadataCacheTTL, cfg.MetadataCacheMaxTTL = tt.ttl, tt.maxTTL
+		if got := metadataCacheTTL(cfg, ExtractOptions{CacheTTLMs: tt.requested}); got != tt.want {
+			t.Errorf("METADATA_CACHE_TTL %s, MAX %s, cache_ttl_ms %v: got %s, want %s", tt.ttl, tt.maxTTL, tt.requested, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// RawMeta holds the title and description as the page declared them, before
+// sanitizeText
+type RawMeta struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// sanitizeMetadataText makes the title and description plain text, keeping
+// the originals in RawMeta when includeRaw is set
+func sanitizeMetadataText(metadata *MetadataResponse, includeRaw bool) {
+	if includeRaw {
+		metadata.RawMeta = &RawMeta{Title: metadata.Title, Description: metadata.Description}
+	}
+	metadata.Title = sanitizeText(metadata.Title)
+	metadata.Description = sanitizeText(metadata.Description)
+}
+
+// sanitizeText strips HTML tags that slipped into s, along with the contents
+// of scripts and styles, decodes entities, drops control and bidirectional
+// formatting characters and collapses whitespace
+func sanitizeText(s string) string {
+	if s == "" {
+		return s
+	}
+
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	skip := ""
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				return ""
+			}
+			break
+		}
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			switch {
+			case tt == html.StartTagToken && (tag == "script" || tag == "style"):
+				skip = tag
+			case tt == html.EndTagToken && tag == skip:
+				skip = ""
+			}
+			// Block elements separate words, as they would when rendered
+			if blockTags[tag] {
+				b.WriteByte(' ')
+			}
+		case html.TextToken:
+			if skip == "" {
+				b.Write(z.Text())
+			}
+		}
+	}
+
+	cleaned := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return ' '
+		case unicode.IsControl(r), isBidiControl(r), r == '\uFEFF':
+			return -1
+		}
+		return r
+	}, b.String())
+	return strings.Join(strings.Fields(cleaned), " ")
+}
+
+// blockTags are the elements that break a line when rendered
+var blockTags = map[string]bool{
+	"br": true, "p": true, "div": true, "li": true, "ul": true, "ol": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"tr": true, "td": true, "th": true, "blockquote": true, "hr": true,
+}
+
+// isBidiControl reports whether r is a bidirectional embedding, override or
+// isolate, which can make text display differently from how it reads
+func isBidiControl(r rune) bool {
+	return (r >= '\u202A' && r <= '\u202E') || (r >= '\u2066' && r <= '\u2069')
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// screenshotTimeout bounds a single render; it must fit in the server's WriteTimeout
+	screenshotTimeout = 12 * time.Second
+
+	// maxScreenshotBytes caps the renderer's output
+	maxScreenshotBytes = 5 * 1024 * 1024
+
+	defaultScreenshotWidth  = 1200
+	defaultScreenshotHeight = 630
+	maxScreenshotDimension  = 2000
+
+	// defaultScreenshotCacheTTL and defaultScreenshotCacheBytes apply when
+	// SCREENSHOT_CACHE_TTL and SCREENSHOT_CACHE_BYTES are not set
+	defaultScreenshotCacheTTL   = time.Hour
+	defaultScreenshotCacheBytes = 64 * 1024 * 1024
+)
+
+var (
+	// screenshots caches rendered screenshots; main sizes it from the config
+	screenshots = newScreenshotCache(defaultScreenshotCacheTTL, defaultScreenshotCacheBytes)
+
+	// rendererClient talks to the operator-configured renderer, which is
+	// trusted and therefore not subject to SSRF checks
+	rendererClient = &http.Client{Timeout: screenshotTimeout}
+)
+
+var errRendererUnavailable = errors.New("screenshot rendering is not configured")
+
+// screenshotsEnabled reports whether the screenshot endpoint can serve
+// requests. Rendering needs a RENDERER_URL, a headless browser service
+// accepting browserless-style POST /screenshot requests.
+func screenshotsEnabled(cfg *Config) bool {
+	return cfg.RendererURL != "" && cfg.ScreenshotSecret != ""
+}
+
+// signScreenshotURL returns the hex HMAC-SHA256 that authorizes a screenshot
+// of pageURL at one size and format. It covers the whole canonical query,
+// defaults filled in, so a signed link can't be reused for other renders.
+func signScreenshotURL(secret string, pageURL string, width, height int, format string) string {
+	query := url.Values{
+		"url":    {pageURL},
+		"width":  {strconv.Itoa(width)},
+		"height": {strconv.Itoa(height)},
+		"format": {format},
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("screenshot:" + query.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// screenshotURL returns the signed /screenshot URL for pageURL at the default size
+func screenshotURL(cfg *Config, pageURL string) string {
+	query := url.Values{}
+	query.Set("url", pageURL)
+	query.Set("sig", signScreenshotURL(cfg.ScreenshotSecret, pageURL, defaultScreenshotWidth, defaultScreenshotHeight, "png"))
+	return cfg.PublicURL + "/screenshot?" + query.Encode()
+}
+
+// screenshotHandler renders a page through the headless renderer and returns
+// the viewport as an image
+func screenshotHandler(store *configStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveScreenshot(store.Load(), w, r)
+	}
+}
+
+func serveScreenshot(cfg *Config, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		proxyError(w, http.StatusMethodNotAllowed, "Method not allowed. Use GET.")
+		return
+	}
+	if !screenshotsEnabled(cfg) {
+		proxyError(w, http.StatusServiceUnavailable, errRendererUnavailable.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	pageURL := query.Get("url")
+	sig := query.Get("sig")
+	if pageURL == "" || sig == "" {
+		proxyError(w, http.StatusBadRequest, "Both 'url' and 'sig' parameters are required")
+		return
+	}
+	width, ok := screenshotDimension(query.Get("width"), defaultScreenshotWidth)
+	if !ok {
+		proxyError(w, http.StatusBadRequest, fmt.Sprintf("'width' must be between 1 and %d", maxScreenshotDimension))
+		return
+	}
+	height, ok := screenshotDimension(query.Get("height"), defaultScreenshotHeight)
+	if !ok {
+		proxyError(w, http.StatusBadRequest, fmt.Sprintf("'height' must be between 1 and %d", maxScreenshotDimension))
+		return
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "png"
+	}
+	if format != "png" && format != "jpeg" {
+		proxyError(w, http.StatusBadRequest, "'format' must be png or jpeg")
+		return
+	}
+	if !hmac.Equal([]byte(sig), []byte(signScreenshotURL(cfg.ScreenshotSecret, pageURL, width, height, format))) {
+		proxyError(w, http.StatusForbidden, "Invalid signature")
+		return
+	}
+
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		proxyError(w, http.StatusBadRequest, "Invalid URL")
+		return
+	}
+	if err := validateURLForSSRF(cfg, parsedURL); err != nil {
+		proxyError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	key := fmt.Sprintf("%s|%d|%d|%s", pageURL, width, height, format)
+	shot, err := screenshots.get(r.Context(), key, func(ctx context.Context) (*screenshotEntry, error) {
+		return renderScreenshot(ctx, cfg.RendererURL, pageURL, width, height, format)
+	})
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		proxyError(w, status, fmt.Sprintf("failed to render screenshot: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", shot.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(shot.data)))
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(time.Until(shot.expires).Seconds())))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(shot.data)
+}
+
+func screenshotDimension(v string, def int) (int, bool) {
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxScreenshotDimension {
+		return 0, false
+	}
+	return n, true
+}
+
+// renderScreenshot asks the renderer for a viewport screenshot of pageURL
+func renderScreenshot(ctx context.Context, rendererURL string, pageURL string, width, height int, format string) (*screenshotEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, screenshotTimeout)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"url":      pageURL,
+		"options":  map[string]interface{}{"type": format, "fullPage": false},
+		"viewport": map[string]int{"width": width, "height": height},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rendererURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := rendererClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("renderer returned HTTP %d", resp.StatusCode)
+	}
+	contentType := responseMediaType(resp)
+	if contentType != "image/png" && contentType != "image/jpeg" {
+		return nil, fmt.Errorf("renderer returned unexpected content type: %s", contentType)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxScreenshotBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxScreenshotBytes {
+		return nil, fmt.Errorf("screenshot exceeds %d bytes", maxScreenshotBytes)
+	}
+
+	return &screenshotEntry{data: data, contentType: contentType}, nil
+}
+
+// screenshotEntry is a rendered screenshot held in the cache
+type screenshotEntry struct {
+	data        []byte
+	contentType string
+	expires     time.Time
+}
+
+// screenshotCall is a render in progress that concurrent requests wait on
+type screenshotCall struct {
+	done  chan struct{}
+	entry *screenshotEntry
+	err   error
+}
+
+// screenshotCache holds rendered screenshots for ttl, within a total byte
+// budget, and makes sure only one render per key is in flight at a time
+type screenshotCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	maxBytes int
+	size     int
+	entries  map[string]*screenshotEntry
+	inflight map[string]*screenshotCall
+}
+
+func newScreenshotCache(ttl time.Duration, maxBytes int) *screenshotCache {
+	return &screenshotCache{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*screenshotEntry),
+		inflight: make(map[string]*screenshotCall),
+	}
+}
+
+// get returns the cached screenshot for key, rendering it if needed. Callers
+// asking for a key that is already being rendered wait for that render.
+func (c *screenshotCache) get(ctx context.Context, key string, render func(context.Context) (*screenshotEntry, error)) (*screenshotEntry, error) {
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok && time.Now().Before(entry.expires) {
+		c.mu.Unlock()
+		return entry, nil
+	}
+	call, ok := c.inflight[key]
+	if !ok {
+		call = &screenshotCall{done: make(chan struct{})}
+		c.inflight[key] = call
+		// The render is shared, so it must not be cancelled by the first
+		// caller leaving, only by shutdown
+		service.goBackground(func(ctx context.Context) error {
+			c.render(ctx, key, call, render)
+			return call.err
+		})
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.entry, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *screenshotCache) render(ctx context.Context, key string, call *screenshotCall, render func(context.Context) (*screenshotEntry, error)) {
+	call.entry, call.err = render(ctx)
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if call.err == nil {
+		call.entry.expires = time.Now().Add(c.ttl)
+		c.store(key, call.entry)
+	}
+	c.mu.Unlock()
+	close(call.done)
+}
+
+// store adds entry and evicts expired, then oldest, entries until the cache
+// fits in maxBytes. c.mu must be held.
+func (c *screenshotCache) store(key string, entry *screenshotEntry) {
+	if len(entry.data) > c.maxBytes {
+		return
+	}
+	if old, ok := c.entries[key]; ok {
+		c.size -= len(old.data)
+	}
+	c.entries[key] = entry
+	c.size += len(entry.data)
+
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			c.size -= len(e.data)
+			delete(c.entries, k)
+		}
+	}
+	for c.size > c.maxBytes {
+		var oldestKey string
+		var oldest *screenshotEntry
+		for k, e := range c.entries {
+			if oldest == nil || e.expires.Before(oldest.expires) {
+				oldestKey, oldest = k, e
+			}
+		}
+		c.size -= len(oldest.data)
+		delete(c.entries, oldestKey)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestScreenshotSignatureCoversSizeAndFormat(t *testing.T) {
+	renderer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG"))
+	}))
+	defer renderer.Close()
+
+	cfg := testConfig()
+	cfg.RendererURL = renderer.URL
+	cfg.ScreenshotSecret = "secret"
+	link, err := url.Parse(screenshotURL(cfg, "http://127.0.0.1/page"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		extra url.Values
+		want  int
+	}{
+		{"as signed", nil, http.StatusOK},
+		{"defaults spelled out", url.Values{"width": {"1200"}, "height": {"630"}, "format": {"png"}}, http.StatusOK},
+		{"other width", url.Values{"width": {"2000"}}, http.StatusForbidden},
+		{"other height", url.Values{"height": {"2000"}}, http.StatusForbidden},
+		{"other format", url.Values{"format": {"jpeg"}}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		query := link.Query()
+		for k, v := range tt.extra {
+			query[k] = v
+		}
+		rec := httptest.NewRecorder()
+		serveScreenshot(cfg, rec, httptest.NewRequest(http.MethodGet, "/screenshot?"+query.Encode(), nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SecurityInfo summarizes the security headers of the page's final response
+type SecurityInfo struct {
+	HSTS             bool   `json:"hsts"`
+	HSTSHeader       string `json:"hsts_header,omitempty"`
+	CSP              bool   `json:"csp"`
+	CSPBlocksFraming bool   `json:"csp_blocks_framing"`
+	XFrameOptions    string `json:"x_frame_options,omitempty"`
+	ReferrerPolicy   string `json:"referrer_policy,omitempty"`
+	Downgraded       bool   `json:"downgraded"`
+}
+
+// openFrameAncestors are frame-ancestors sources that let any site of their
+// scheme embed the page
+var openFrameAncestors = map[string]bool{
+	"*":         true,
+	"http:":     true,
+	"https:":    true,
+	"http://*":  true,
+	"https://*": true,
+}
+
+// securityInfo summarizes header, the final response of a fetch whose HSTS
+// and downgrade were already worked out into metadata. HSTS only counts when
+// sent over HTTPS, as browsers ignore it otherwise.
+func securityInfo(header http.Header, metadata *MetadataResponse) *SecurityInfo {
+	info := &SecurityInfo{
+		HSTS:           metadata.HSTS,
+		XFrameOptions:  strings.TrimSpace(header.Get("X-Frame-Options")),
+		ReferrerPolicy: strings.TrimSpace(header.Get("Referrer-Policy")),
+		Downgraded:     metadata.Downgraded,
+	}
+	if metadata.FinalScheme == "https" {
+		info.HSTSHeader = strings.TrimSpace(header.Get("Strict-Transport-Security"))
+	}
+	// Every policy sent is enforced, so any of them can forbid framing
+	for _, policy := range header.Values("Content-Security-Policy") {
+		info.CSP = true
+		if cspBlocksFraming(policy) {
+			info.CSPBlocksFraming = true
+		}
+	}
+	return info
+}
+
+// cspBlocksFraming reports whether a policy's frame-ancestors directive
+// keeps at least some other sites from embedding the page
+func cspBlocksFraming(policy string) bool {
+	for _, directive := range strings.Split(policy, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], "frame-ancestors") {
+			continue
+		}
+		for _, source := range fields[1:] {
+			if openFrameAncestors[strings.ToLower(source)] {
+				return false
+			}
+		}
+		// No sources at all means 'none'
+		return true
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// defaultSocketMode is applied to the Unix socket when SOCKET_MODE is not set
+	defaultSocketMode = 0o660
+
+	// defaultAutocertCacheDir is used when AUTOCERT_CACHE_DIR is not set
+	defaultAutocertCacheDir = "autocert-cache"
+)
+
+// unixSocketPath returns the socket path of a LISTEN=unix:/path address
+func unixSocketPath(listen string) (string, bool) {
+	if !strings.HasPrefix(listen, "unix:") {
+		return "", false
+	}
+	return strings.TrimPrefix(listen, "unix:"), true
+}
+
+// listenAddress returns the address the server listens on: LISTEN when set,
+// :443 with autocert, otherwise PORT on all interfaces
+func listenAddress(cfg *Config) string {
+	if cfg.Listen != "" {
+		return cfg.Listen
+	}
+	if len(cfg.AutocertDomains) > 0 {
+		return ":443"
+	}
+	return ":" + cfg.Port
+}
+
+// listen opens the configured TCP or Unix socket listener
+func listen(cfg *Config) (net.Listener, error) {
+	addr := listenAddress(cfg)
+	path, ok := unixSocketPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, cfg.SocketMode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %v", err)
+	}
+	return ln, nil
+}
+
+// removeStaleSocket deletes a socket left behind by a previous run. A socket
+// that still accepts connections, or a path that isn't a socket, is an error.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("failed to check existing socket %s: %v", path, err)
+	}
+	return os.Remove(path)
+}
+
+// configureTLS sets up HTTPS on server. Certificate files are loaded now so
+// that a bad file fails startup rather than the first handshake. With
+// autocert it also returns the :80 server that answers HTTP-01 challenges
+// and redirects everything else to HTTPS.
+func configureTLS(cfg *Config, server *http.Server) (*http.Server, error) {
+	if len(cfg.AutocertDomains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		redirect := &http.Server{
+			Addr:              ":80",
+			Handler:           manager.HTTPHandler(nil),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		return redirect, nil
+	}
+
+	if cfg.TLSCertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	return nil, nil
+}
+
+// serve runs server on ln until it is shut down, over TLS when configured
+func serve(server *http.Server, ln net.Listener) error {
+	if server.TLSConfig != nil {
+		// ServeTLS takes the certificates from TLSConfig and enables HTTP/2
+		return server.ServeTLS(ln, "", "")
+	}
+	return server.Serve(ln)
+}
+
+// serverURL describes where the server can be reached, for the startup log
+func serverURL(cfg *Config) string {
+	addr := listenAddress(cfg)
+	if path, ok := unixSocketPath(addr); ok {
+		return "unix:" + path
+	}
+	scheme := "http"
+	if cfg.TLSCertFile != "" || len(cfg.AutocertDomains) > 0 {
+		scheme = "https"
+	}
+	host, port, _ := net.SplitHostPort(addr)
+	if len(cfg.AutocertDomains) > 0 {
+		host = cfg.AutocertDomains[0]
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// service ties extractions and background work to the server's lifetime.
+// main installs its context as the server's BaseContext, so every request
+// context derives from it and is cancelled when shutdown begins.
+var service = newServiceLifecycle()
+
+type serviceLifecycle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	active    int
+	draining  bool
+	drained   chan struct{}
+	completed int
+	cancelled int
+}
+
+func newServiceLifecycle() *serviceLifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &serviceLifecycle{ctx: ctx, cancel: cancel, drained: make(chan struct{})}
+}
+
+// Context is cancelled when shutdown begins
+func (s *serviceLifecycle) Context() context.Context {
+	return s.ctx
+}
+
+// track registers a unit of work. The returned func must be called when it
+// ends, saying whether the work was cut short.
+func (s *serviceLifecycle) track() func(cancelled bool) {
+	s.mu.Lock()
+	s.active++
+	s.mu.Unlock()
+
+	var once sync.Once
+	return func(cancelled bool) {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.active--
+			if s.draining {
+				if cancelled {
+					s.cancelled++
+				} else {
+					s.completed++
+				}
+				s.closeDrainedIfIdle()
+			}
+		})
+	}
+}
+
+// trackContext is track for work that is cut short by cancelling ctx
+func (s *serviceLifecycle) trackContext(ctx context.Context) func() {
+	done := s.track()
+	return func() { done(ctx.Err() != nil) }
+}
+
+// goBackground runs fn in a tracked goroutine with the service context
+func (s *serviceLifecycle) goBackground(fn func(ctx context.Context) error) {
+	done := s.track()
+	go func() {
+		err := fn(s.ctx)
+		done(errors.Is(err, context.Canceled))
+	}()
+}
+
+// closeDrainedIfIdle signals drain once nothing is running. s.mu must be held.
+func (s *serviceLifecycle) closeDrainedIfIdle() {
+	if s.active > 0 {
+		return
+	}
+	select {
+	case <-s.drained:
+	default:
+		close(s.drained)
+	}
+}
+
+// drain cancels the service context and waits, until ctx is done, for
+// tracked work to return. It reports how many runs finished normally and how
+// many were cancelled after draining began.
+func (s *serviceLifecycle) drain(ctx context.Context) (completed, cancelled int, err error) {
+	s.mu.Lock()
+	s.draining = true
+	s.closeDrainedIfIdle()
+	s.mu.Unlock()
+
+	s.cancel()
+
+	select {
+	case <-s.drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.completed, s.cancelled, err
+}
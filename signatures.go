@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// signatureList describes a kind of page that isn't what was asked for, such
+// as a cookie-consent wall or a parked domain. Matching is case-insensitive.
+type signatureList struct {
+	// ScriptHosts are services whose scripts or frames the page loads, or
+	// that it redirects to; the host or a subdomain matches
+	ScriptHosts []string `json:"script_hosts"`
+	// Markers are substrings of the element IDs and classes the page uses
+	Markers []string `json:"markers"`
+	// Phrases are what the page says in its title or description
+	Phrases []string `json:"phrases"`
+	// Nameservers are the DNS hosts of services the domain is delegated to;
+	// a nameserver's host or a subdomain matches
+	Nameservers []string `json:"nameservers"`
+}
+
+// readSignatureFile loads signatures from a JSON file shaped like
+// signatureList. They replace the built-in list.
+func readSignatureFile(path string) (*signatureList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sigs signatureList
+	if err := json.Unmarshal(data, &sigs); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, list := range [][]string{sigs.ScriptHosts, sigs.Markers, sigs.Phrases, sigs.Nameservers} {
+		for i := range list {
+			list[i] = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(list[i])), ".")
+		}
+	}
+	return &sigs, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Values of ExtractOptions.Mode
+const (
+	modeFull   = "full"
+	modeSimple = "simple"
+)
+
+// SimpleMetadata is the response to mode=simple: exactly what a chat app
+// needs to unfurl a link, as a flat object
+type SimpleMetadata struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	SiteName    string `json:"site_name"`
+	Favicon     string `json:"favicon"`
+}
+
+// SimpleResult is one entry of a batch response in simple mode
+type SimpleResult struct {
+	*SimpleMetadata
+	Status    int    `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Code      string `json:"code,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// BatchSimpleResponse is the batch response in simple mode
+type BatchSimpleResponse struct {
+	Results   []SimpleResult `json:"results"`
+	Total     int            `json:"total"`
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+}
+
+// newSimpleMetadata reduces metadata to the simple shape
+func newSimpleMetadata(metadata *MetadataResponse) *SimpleMetadata {
+	simple := &SimpleMetadata{
+		Title:       metadata.Title,
+		Description: metadata.Description,
+		Image:       primaryImage(metadata),
+		Favicon:     metadata.Favicon,
+	}
+	if len(metadata.SiteName) > 0 {
+		simple.SiteName = metadata.SiteName[0]
+	}
+	return simple
+}
+
+// newBatchSimpleResponse converts a batch response to simple mode
+func newBatchSimpleResponse(batch BatchMetadataResponse) BatchSimpleResponse {
+	results := make([]SimpleResult, len(batch.Results))
+	for i, res := range batch.Results {
+		results[i] = SimpleResult{
+			SimpleMetadata: newSimpleMetadata(res.MetadataResponse),
+			Status:         res.Status,
+			Error:          res.Error,
+			Code:           res.Code,
+			RequestID:      res.RequestID,
+		}
+	}
+	return BatchSimpleResponse{Results: results, Total: batch.Total, Succeeded: batch.Succeeded, Failed: batch.Failed}
+}
+
+// primaryImage picks the one image to show for a page: its first og:image,
+// which is the one meant for link previews, or else its first candidate
+func primaryImage(metadata *MetadataResponse) string {
+	for _, img := range metadata.ImageDetails {
+		if img.Source == "og:image" || img.Source == "og:image:url" {
+			return img.URL
+		}
+	}
+	if len(metadata.Images) > 0 {
+		return metadata.Images[0]
+	}
+	return ""
+}
+
+// extractEssentials is extractFromNode for mode=simple: it reads the title,
+// meta tags and the favicon, without listing icons or counting the DOM.
+// Meta content is cut to maxMetaContent bytes.
+func extractEssentials(doc *html.Node, metadata *MetadataResponse, baseURL *url.URL, maxMetaContent int) {
+	var darkIcon string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "title":
+				if metadata.Title == "" {
+					metadata.Title = titleText(n)
+				}
+			case "meta":
+				extractMetaTag(n, metadata, baseURL, maxMetaContent)
+			case "link":
+				rel, href := strings.ToLower(attrValue(n, "rel")), attrValue(n, "href")
+				if metadata.Favicon != "" || href == "" || !strings.Contains(rel, "icon") {
+					break
+				}
+				// Dark-theme variants are only used when there is nothing else
+				if !isDarkMedia(strings.TrimSpace(attrValue(n, "media"))) {
+					metadata.Favicon = resolveURL(href, baseURL)
+				} else if darkIcon == "" {
+					darkIcon = resolveURL(href, baseURL)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	if metadata.Favicon == "" {
+		metadata.Favicon = darkIcon
+	}
+}
+
+// finishSimpleMetadata completes a mode=simple extraction, which skips the
+// enrichment of full mode: hashes, providers, network and parked-domain
+// checks, probes and verification
+func finishSimpleMetadata(metadata *MetadataResponse, parsedURL *url.URL, targetURL string, opts ExtractOptions, startTime time.Time) *MetadataResponse {
+	if metadata.Favicon == "" && (opts.FaviconFallback == nil || *opts.FaviconFallback) {
+		metadata.Favicon = fmt.Sprintf("%s://%s/favicon.ico", parsedURL.Scheme, parsedURL.Host)
+	}
+	metadata.URL = targetURL
+	metadata.Domain, metadata.DomainUnicode = normalizeDomain(parsedURL.Host)
+	metadata.Duration = time.Since(startTime).Milliseconds()
+	return metadata
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// maxTitleSiteNameRunes bounds a site name taken from the title, past
+	// which the suffix is more likely part of the title itself
+	maxTitleSiteNameRunes = 40
+
+	// maxTitleSiteNameWords is the same bound in words
+	maxTitleSiteNameWords = 5
+)
+
+// titleSeparators split "Title | Site" titles, most telling first. Hyphens
+// come late since titles use them on their own.
+var titleSeparators = []string{" | ", " · ", " — ", " – ", " :: ", " » ", " - "}
+
+// brandSeparators set off a site's name at the end of a title, for
+// clean_title. Hyphens, which titles use on their own, aren't trusted.
+var brandSeparators = []string{" | ", " · ", " — ", " – ", " :: ", " » "}
+
+// fallbackSiteName names the site of a page without og:site_name, from the
+// first of: its application-name meta tag, the suffix of a "Title | Site"
+// <title>, or host's registrable domain, such as "Example.com". The source
+// is recorded as application-name, title or domain.
+func fallbackSiteName(doc *html.Node, metadata *MetadataResponse, host string, maxMetaContent int) {
+	if len(metadata.SiteName) > 0 {
+		return
+	}
+
+	var appName, title string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch {
+			case n.Data == "title" && title == "":
+				title = titleText(n)
+			case n.Data == "meta" && appName == "" && strings.EqualFold(attrValue(n, "name"), "application-name"):
+				appName = strings.TrimSpace(metaContent(n, maxMetaContent))
+			}
+		}
+		for c := n.FirstChild; c != nil && appName == ""; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	switch name := titleSiteName(title); {
+	case appName != "":
+		metadata.SiteName = []string{appName}
+		noteSource(metadata, "sitename", "application-name")
+	case name != "":
+		metadata.SiteName = []string{name}
+		noteSource(metadata, "sitename", "title")
+	default:
+		if name := domainSiteName(host); name != "" {
+			metadata.SiteName = []string{name}
+			noteSource(metadata, "sitename", "domain")
+		}
+	}
+}
+
+// titleSiteName is the "Site" of a "Title | Site" title, or "" if title
+// doesn't look like one
+func titleSiteName(title string) string {
+	for _, sep := range titleSeparators {
+		i := strings.LastIndex(title, sep)
+		if i <= 0 {
+			continue
+		}
+		name := strings.TrimSpace(title[i+len(sep):])
+		if name == "" || utf8.RuneCountInString(name) > maxTitleSiteNameRunes || len(strings.Fields(name)) > maxTitleSiteNameWords {
+			continue
+		}
+		return name
+	}
+	return ""
+}
+
+// domainSiteName turns host into a site name: its registrable domain, which
+// drops "www." and other subdomains, in Unicode and capitalized. IP
+// addresses are returned as they are.
+func domainSiteName(host string) string {
+	if host == "" || isIPHost(host) {
+		return host
+	}
+	_, domain := normalizeDomain(registrableDomain(host))
+	r, size := utf8.DecodeRuneInString(domain)
+	return string(unicode.ToUpper(r)) + domain[size:]
+}
+
+// cleanTitle sets TitleClean to the title without a trailing separator and
+// site name, as in "How to Make Sourdough – King Arthur Baking". The suffix
+// is only cut when it names the site: it matches the site name, or the
+// registrable domain of host or its first label, ignoring case, spaces and
+// punctuation. Otherwise TitleClean is the title as it is.
+func cleanTitle(metadata *MetadataResponse, host string) {
+	metadata.TitleClean = metadata.Title
+
+	identities := make([]string, 0, len(metadata.SiteName)+2)
+	// A site name read off the title's own suffix would always match it
+	if metadata.Sources["sitename"] != "title" {
+		for _, name := range metadata.SiteName {
+			identities = append(identities, brandKey(name))
+		}
+	}
+	if host != "" && !isIPHost(host) {
+		domain := registrableDomain(host)
+		label, _, _ := strings.Cut(domain, ".")
+		identities = append(identities, brandKey(domain), brandKey(label))
+	}
+
+	for _, sep := range brandSeparators {
+		i := strings.LastIndex(metadata.Title, sep)
+		if i <= 0 {
+			continue
+		}
+		suffix := brandKey(metadata.Title[i+len(sep):])
+		if suffix != "" && contains(identities, suffix) {
+			if clean := strings.TrimSpace(metadata.Title[:i]); clean != "" {
+				metadata.TitleClean = clean
+			}
+			return
+		}
+	}
+}
+
+// brandKey reduces a site name to what clean_title compares: its letters
+// and digits, lowercased
+func brandKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// soft404MaxTextBytes is the most visible text a page can have and still
+	// count as nearly empty
+	soft404MaxTextBytes = 256
+
+	// soft404CacheTTL caps how long a result flagged soft_404 is cached, since
+	// the flag may be wrong and the page may appear later
+	soft404CacheTTL = time.Minute
+)
+
+// defaultSoft404Patterns is used when SOFT_404_PATTERNS is not set
+var defaultSoft404Patterns = []string{
+	"not found",
+	"404",
+	"doesn't exist",
+	"does not exist",
+	"no longer exists",
+	"no longer available",
+	"nicht gefunden",
+	"existiert nicht",
+	"page introuvable",
+	"n'existe pas",
+	"no encontrada",
+	"no encontrado",
+	"no existe",
+	"non trovata",
+	"non esiste",
+	"niet gevonden",
+	"bestaat niet",
+	"não encontrada",
+	"não existe",
+	"nie znaleziono",
+	"ページが見つかりません",
+	"页面不存在",
+	"找不到页面",
+}
+
+// parseSoft404Patterns parses a |-separated SOFT_404_PATTERNS list
+func parseSoft404Patterns(v string) []string {
+	var patterns []string
+	for _, item := range strings.Split(v, "|") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			patterns = append(patterns, item)
+		}
+	}
+	return patterns
+}
+
+// detectSoft404 flags a page served with 200 that looks like an error page:
+// its title or description matches a pattern, or it is nearly empty and its
+// canonical URL is the home page. finalURL is where the fetch ended up.
+func detectSoft404(doc *html.Node, metadata *MetadataResponse, finalURL *url.URL, patterns []string) {
+	var reason string
+	if pattern := matchSoft404Pattern(metadata.Title, patterns); pattern != "" {
+		reason = fmt.Sprintf("title matches %q", pattern)
+	} else if pattern := matchSoft404Pattern(metadata.Description, patterns); pattern != "" {
+		reason = fmt.Sprintf("description matches %q", pattern)
+	} else if canonicalIsHome(metadata.Canonical, finalURL) && visibleTextBytes(doc) <= soft404MaxTextBytes {
+		reason = "nearly empty page whose canonical URL is the home page"
+	}
+	if reason == "" {
+		return
+	}
+	metadata.Soft404 = true
+	addWarning(metadata, warnSoft404, "page looks like a soft 404: "+reason)
+}
+
+// matchSoft404Pattern returns the first pattern found in s as a whole word,
+// ignoring case. Patterns that start or end with a letter or digit must not
+// run into one in s, so "404" doesn't match "14045".
+func matchSoft404Pattern(s string, patterns []string) string {
+	s = strings.ToLower(s)
+	for _, pattern := range patterns {
+		for offset := 0; ; {
+			i := strings.Index(s[offset:], pattern)
+			if i < 0 {
+				break
+			}
+			start, end := offset+i, offset+i+len(pattern)
+			if wordEdge(s[:start], pattern, false) && wordEdge(s[end:], pattern, true) {
+				return pattern
+			}
+			offset = start + 1
+		}
+	}
+	return ""
+}
+
+// wordEdge reports whether the text beside a match doesn't continue the word
+// the pattern starts or ends with. after is set for the text following it.
+func wordEdge(beside, pattern string, after bool) bool {
+	var edge, next rune
+	if after {
+		edge, _ = utf8.DecodeLastRuneInString(pattern)
+		next, _ = utf8.DecodeRuneInString(beside)
+	} else {
+		edge, _ = utf8.DecodeRuneInString(pattern)
+		next, _ = utf8.DecodeLastRuneInString(beside)
+	}
+	if beside == "" || edge > unicode.MaxASCII || !isWordRune(edge) {
+		return true
+	}
+	return !isWordRune(next)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// canonicalIsHome reports whether canonical is the root of its site while
+// the page itself is not
+func canonicalIsHome(canonical string, finalURL *url.URL) bool {
+	if canonical == "" {
+		return false
+	}
+	u, err := url.Parse(canonical)
+	if err != nil {
+		return false
+	}
+	isRoot := func(u *url.URL) bool { return (u.Path == "" || u.Path == "/") && u.RawQuery == "" }
+	return isRoot(u) && !isRoot(finalURL)
+}
+
+// visibleTextBytes counts the text of doc's body, outside scripts and styles,
+// without surrounding whitespace
+func visibleTextBytes(doc *html.Node) int {
+	total := 0
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.DataAtom == atom.Script || n.DataAtom == atom.Style || n.DataAtom == atom.Head || n.DataAtom == atom.Noscript) {
+			return
+		}
+		if n.Type == html.TextNode {
+			total += len(strings.TrimSpace(n.Data))
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return total
+}
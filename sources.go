@@ -0,0 +1,36 @@
+package main
+
+// noteSource records where field's value came from, unless an earlier source
+// already set it. Sources are always collected and dropped at the end unless
+// include_sources asked for them.
+func noteSource(metadata *MetadataResponse, field, source string) {
+	if metadata.Sources == nil {
+		metadata.Sources = make(map[string]string)
+	}
+	if _, ok := metadata.Sources[field]; !ok {
+		metadata.Sources[field] = source
+	}
+}
+
+// setSource records where field's value came from, replacing an earlier
+// source
+func setSource(metadata *MetadataResponse, field, source string) {
+	delete(metadata.Sources, field)
+	noteSource(metadata, field, source)
+}
+
+// finishSources adds the source of the primary image, which is only known
+// once verification has dropped the candidates that aren't images, or drops
+// the sources when they weren't asked for
+func finishSources(metadata *MetadataResponse, include bool) {
+	if !include {
+		metadata.Sources = nil
+		for i := range metadata.ImageDetails {
+			metadata.ImageDetails[i].Source = ""
+		}
+		return
+	}
+	if len(metadata.ImageDetails) > 0 {
+		setSource(metadata, "image", metadata.ImageDetails[0].Source)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSSRFCacheTTL is used when SSRF_CACHE_TTL is not set. It is kept
+	// short because a cached result outlives any change to the host's DNS.
+	defaultSSRFCacheTTL = 5 * time.Second
+
+	// maxSSRFCacheEntries bounds the cache; expired entries are swept when
+	// it fills up
+	maxSSRFCacheEntries = 4096
+)
+
+// ssrfValidations caches the outcome of resolving and checking a host
+var ssrfValidations = newValidationCache()
+
+// validationCache remembers, per host, whether its addresses passed the SSRF
+// checks. Only the result is kept, never the addresses, so fetches still
+// resolve for themselves. Concurrent validations of the same host share one
+// lookup.
+type validationCache struct {
+	mu      sync.Mutex
+	entries map[string]*validationEntry
+}
+
+type validationEntry struct {
+	done    chan struct{}
+	err     error
+	expires time.Time
+}
+
+func newValidationCache() *validationCache {
+	return &validationCache{entries: make(map[string]*validationEntry)}
+}
+
+// validate returns the cached result for host, or runs check and caches its
+// result for ttl. A ttl of 0 disables caching.
+func (c *validationCache) validate(host string, ttl time.Duration, check func() error) error {
+	if ttl <= 0 {
+		return check()
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	if ok && !c.expired(entry) {
+		c.mu.Unlock()
+		<-entry.done
+		return entry.err
+	}
+	if len(c.entries) >= maxSSRFCacheEntries {
+		c.sweep()
+	}
+	entry = &validationEntry{done: make(chan struct{})}
+	c.entries[host] = entry
+	c.mu.Unlock()
+
+	entry.err = check()
+	c.mu.Lock()
+	entry.expires = time.Now().Add(ttl)
+	c.mu.Unlock()
+	close(entry.done)
+	return entry.err
+}
+
+// expired reports whether a finished entry is past its TTL. Entries still
+// being checked have no expiry yet. c.mu must be held.
+func (c *validationCache) expired(entry *validationEntry) bool {
+	return !entry.expires.IsZero() && time.Now().After(entry.expires)
+}
+
+// sweep drops expired entries. c.mu must be held.
+func (c *validationCache) sweep() {
+	for host, entry := range c.entries {
+		if c.expired(entry) {
+			delete(c.entries, host)
+		}
+	}
+}
+
+// clear forgets every result, for when the blocked ranges change
+func (c *validationCache) clear() {
+	c.mu.Lock()
+	c.entries = make(map[string]*validationEntry)
+	c.mu.Unlock()
+}
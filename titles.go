@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// TitleCandidate is one of the titles a page declares, for clients that want
+// to choose for themselves
+type TitleCandidate struct {
+	Source string `json:"source"`
+	Value  string `json:"value"`
+}
+
+// titleSources lists the candidate sources in the order they are reported
+var titleSources = []string{"title", "og:title", "twitter:title", "h1"}
+
+// extractTitleCandidates returns the first <title>, og:title, twitter:title
+// and <h1> of the page, skipping any that are missing or empty
+func extractTitleCandidates(doc *html.Node) []TitleCandidate {
+	found := make(map[string]string, len(titleSources))
+	setOnce := func(source, value string) {
+		value = strings.Join(strings.Fields(value), " ")
+		if _, ok := found[source]; !ok && value != "" {
+			found[source] = value
+		}
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Title:
+				setOnce("title", textContent(n))
+			case atom.H1:
+				setOnce("h1", textContent(n))
+			case atom.Meta:
+				var key, content string
+				for _, attr := range n.Attr {
+					switch attr.Key {
+					case "property", "name":
+						if key == "" {
+							key = strings.ToLower(attr.Val)
+						}
+					case "content":
+						content = attr.Val
+					}
+				}
+				if key == "og:title" || key == "twitter:title" {
+					setOnce(key, content)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	var candidates []TitleCandidate
+	for _, source := range titleSources {
+		if value, ok := found[source]; ok {
+			candidates = append(candidates, TitleCandidate{Source: source, Value: value})
+		}
+	}
+	return candidates
+}
+
+// textContent concatenates the text inside n
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+			b.WriteString(" ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return b.String()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"math"
+	"net/http"
+	"time"
+)
+
+// TLSInfo describes the certificate and connection a page was served over.
+// Times are in RFC 3339.
+type TLSInfo struct {
+	Version          string `json:"version"`
+	Issuer           string `json:"issuer"`
+	IssuerOrg        string `json:"issuer_org,omitempty"`
+	Subject          string `json:"subject"`
+	NotBefore        string `json:"not_before"`
+	NotAfter         string `json:"not_after"`
+	DaysUntilExpiry  int    `json:"days_until_expiry"`
+	Verified         bool   `json:"verified"`
+	HostnameVerified bool   `json:"hostname_verified"`
+	SelfSigned       bool   `json:"self_signed,omitempty"`
+}
+
+// tlsInfo describes the TLS connection of resp, or returns nil for plain
+// HTTP. Fetches verify certificates, so Verified is false only if that is
+// ever relaxed; the checks are made here rather than assumed.
+func tlsInfo(resp *http.Response) *TLSInfo {
+	state := resp.TLS
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := state.PeerCertificates[0]
+
+	info := &TLSInfo{
+		Version:          tls.VersionName(state.Version),
+		Issuer:           leaf.Issuer.CommonName,
+		Subject:          leaf.Subject.CommonName,
+		NotBefore:        leaf.NotBefore.UTC().Format(time.RFC3339),
+		NotAfter:         leaf.NotAfter.UTC().Format(time.RFC3339),
+		DaysUntilExpiry:  int(math.Floor(time.Until(leaf.NotAfter).Hours() / 24)),
+		Verified:         len(state.VerifiedChains) > 0,
+		HostnameVerified: leaf.VerifyHostname(resp.Request.URL.Hostname()) == nil,
+		SelfSigned:       bytes.Equal(leaf.RawIssuer, leaf.RawSubject) && leaf.CheckSignatureFrom(leaf) == nil,
+	}
+	if len(leaf.Issuer.Organization) > 0 {
+		info.IssuerOrg = leaf.Issuer.Organization[0]
+	}
+	if info.Subject == "" && len(leaf.DNSNames) > 0 {
+		info.Subject = leaf.DNSNames[0]
+	}
+	return info
+}
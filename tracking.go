@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// defaultTrackingParams is used when TRACKING_PARAMS is not set. Entries
+// ending in * match any parameter starting with the rest.
+var defaultTrackingParams = []string{
+	"utm_*",
+	"fbclid",
+	"gclid",
+	"dclid",
+	"msclkid",
+	"mc_cid",
+	"mc_eid",
+	"igshid",
+	"yclid",
+	"_ga",
+	"_gl",
+	"ref_src",
+	"cmpid",
+}
+
+// defaultPreservedParams is used when PRESERVED_PARAMS is not set. It keeps
+// the signatures of signed CDN URLs, which stop working without them, even
+// when TRACKING_PARAMS would match them.
+var defaultPreservedParams = []string{
+	"x-amz-*",
+	"signature",
+	"expires",
+	"key-pair-id",
+	"policy",
+	"x-goog-*",
+	"sig",
+	"se",
+	"sp",
+	"sv",
+	"token",
+}
+
+// parseParamNames parses a comma-separated list of query parameter names
+func parseParamNames(v string) []string {
+	var names []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			names = append(names, item)
+		}
+	}
+	return names
+}
+
+// matchParam reports whether the parameter name is in names, ignoring case
+func matchParam(name string, names []string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range names {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// stripTrackingParams removes the tracking parameters of rawURL, keeping
+// preserved ones and the order and encoding of the rest
+func stripTrackingParams(rawURL string, tracking, preserved []string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+	var kept []string
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		name, _, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if pair == "" || (matchParam(name, tracking) && !matchParam(name, preserved)) {
+			continue
+		}
+		kept = append(kept, pair)
+	}
+	u.RawQuery = strings.Join(kept, "&")
+	u.ForceQuery = false
+	return u.String()
+}
+
+// cleanImageURLs strips the tracking parameters of the page's images, then
+// drops the images that turn out to be the same
+func cleanImageURLs(metadata *MetadataResponse, tracking, preserved []string) {
+	seen := make(map[string]bool, len(metadata.ImageDetails))
+	images := metadata.Images[:0]
+	details := metadata.ImageDetails[:0]
+	for _, img := range metadata.ImageDetails {
+		img.URL = stripTrackingParams(img.URL, tracking, preserved)
+		if seen[img.URL] {
+			continue
+		}
+		seen[img.URL] = true
+		images = append(images, img.URL)
+		details = append(details, img)
+	}
+	metadata.Images, metadata.ImageDetails = images, details
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Transfer describes how a page's body came over the network
+type Transfer struct {
+	BytesOnWire     int64  `json:"bytes_on_wire"` // Body bytes as sent, before decompression
+	BytesDecoded    int64  `json:"bytes_decoded"`
+	Truncated       bool   `json:"truncated"`
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	Protocol        string `json:"protocol"`
+	ConnReused      bool   `json:"connection_reused"`
+}
+
+// connTrace records whether the connection a request went out on came from
+// the pool. Redirects and hedges make more than one request; the last one
+// to get a connection is reported.
+type connTrace struct {
+	reused atomic.Bool
+}
+
+// withConnTrace returns a context whose requests report to the returned trace
+func withConnTrace(ctx context.Context) (context.Context, *connTrace) {
+	trace := &connTrace{}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { trace.reused.Store(info.Reused) },
+	}), trace
+}
+
+// connections counts page fetches by protocol for /admin/stats, with how
+// many of them went out on a pooled connection
+var connections = &connectionCounters{byProtocol: make(map[string]*ConnectionStats)}
+
+type connectionCounters struct {
+	mu         sync.Mutex
+	byProtocol map[string]*ConnectionStats
+}
+
+// ConnectionStats reports the page fetches made over one HTTP version
+type ConnectionStats struct {
+	Fetches int64 `json:"fetches"`
+	Reused  int64 `json:"reused"`
+}
+
+func (c *connectionCounters) record(transfer *Transfer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.byProtocol[transfer.Protocol]
+	if s == nil {
+		s = &ConnectionStats{}
+		c.byProtocol[transfer.Protocol] = s
+	}
+	s.Fetches++
+	if transfer.ConnReused {
+		s.Reused++
+	}
+}
+
+func (c *connectionCounters) stats() map[string]ConnectionStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := make(map[string]ConnectionStats, len(c.byProtocol))
+	for protocol, s := range c.byProtocol {
+		stats[protocol] = *s
+	}
+	return stats
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decodeBody returns resp's body decompressed. Pages are fetched with their
+// own Accept-Encoding, so the transport leaves gzip bodies to us and wire
+// is what actually arrived. A body in an encoding we don't know is returned
+// as it is, with ok false, for the caller to warn about.
+func decodeBody(resp *http.Response, wire io.Reader) (body io.Reader, ok bool, err error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return wire, true, nil
+	case "gzip", "x-gzip":
+		r, err := gzip.NewReader(wire)
+		return r, true, err
+	case "deflate":
+		// deflate is meant to be zlib-wrapped, but some servers send raw
+		// deflate data, so the header is checked before it is trusted
+		buffered := bufio.NewReader(wire)
+		if header, _ := buffered.Peek(2); isZlibHeader(header) {
+			r, err := zlib.NewReader(buffered)
+			return r, true, err
+		}
+		return flate.NewReader(buffered), true, nil
+	default:
+		return wire, false, nil
+	}
+}
+
+// isZlibHeader reports whether b starts with a zlib header for deflate data
+func isZlibHeader(b []byte) bool {
+	return len(b) == 2 && b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// protocolVersion names the HTTP version resp came over, such as "1.1" or "2"
+func protocolVersion(resp *http.Response) string {
+	if resp.ProtoMajor >= 2 {
+		return fmt.Sprint(resp.ProtoMajor)
+	}
+	return fmt.Sprintf("%d.%d", resp.ProtoMajor, resp.ProtoMinor)
+}
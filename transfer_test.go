@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// adminConnectionStats reads the connections section of /admin/stats
+func adminConnectionStats(t *testing.T, api *httptest.Server, token string) map[string]ConnectionStats {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, api.URL+"/admin/stats", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var stats struct {
+		Connections map[string]ConnectionStats `json:"connections"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	return stats.Connections
+}
+
+// Concurrent fetches from one host should share a pool of as many
+// connections as may be open to it at once
+func TestUpstreamConnectionReuse(t *testing.T) {
+	const fetches, workers = 200, 8
+
+	var opened atomic.Int64
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><head><title>%s</title></head></html>", r.URL.Path)
+	}))
+	origin.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			opened.Add(1)
+		}
+	}
+	origin.Start()
+	defer origin.Close()
+
+	cfg := testConfig()
+	cfg.AdminToken = "admin"
+	configureTransport(cfg)
+	api := startServer(t, cfg)
+	before := adminConnectionStats(t, api, cfg.AdminToken)["1.1"]
+
+	var reused atomic.Int64
+	paths := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range paths {
+				body := fmt.Sprintf(`{"url":"%s/page/%d"}`, origin.URL, n)
+				resp, err := http.Post(api.URL+"/extract", "application/json", strings.NewReader(body))
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				var metadata MetadataResponse
+				err = json.NewDecoder(resp.Body).Decode(&metadata)
+				resp.Body.Close()
+				switch {
+				case err != nil:
+					t.Error(err)
+				case resp.StatusCode != http.StatusOK || metadata.Transfer == nil:
+					t.Errorf("page %d: status %d, transfer %v", n, resp.StatusCode, metadata.Transfer)
+				case metadata.Transfer.Protocol != "1.1":
+					t.Errorf("page %d: protocol %q", n, metadata.Transfer.Protocol)
+				case metadata.Transfer.ConnReused:
+					reused.Add(1)
+				}
+			}
+		}()
+	}
+	for n := 0; n < fetches; n++ {
+		paths <- n
+	}
+	close(paths)
+	wg.Wait()
+
+	// A fetch can start before the one it follows has put its connection
+	// back in the pool, so allow a few more than MAX_REQUESTS_PER_HOST; a
+	// pool smaller than that keeps opening connections throughout
+	if limit := 2 * int64(cfg.MaxRequestsPerHost); opened.Load() > limit {
+		t.Errorf("%d fetches opened %d connections, want at most %d", fetches, opened.Load(), limit)
+	}
+	// A connection dialed for a fetch that then got a pooled one goes
+	// unused until a later fetch, so only the fewest reuses are known
+	if want := fetches - opened.Load(); reused.Load() < want {
+		t.Errorf("%d fetches reported connection_reused, want at least %d", reused.Load(), want)
+	}
+
+	after := adminConnectionStats(t, api, cfg.AdminToken)["1.1"]
+	if got := after.Fetches - before.Fetches; got != fetches {
+		t.Errorf("/admin/stats counted %d fetches, want %d", got, fetches)
+	}
+	if got := after.Reused - before.Reused; got != reused.Load() {
+		t.Errorf("/admin/stats counted %d reused connections, want %d", got, reused.Load())
+	}
+}
+
+func TestDecodeBody(t *testing.T) {
+	const page = "<html><head><title>Deflated</title></head></html>"
+	var wrapped, raw bytes.Buffer
+	zw := zlib.NewWriter(&wrapped)
+	zw.Write([]byte(page))
+	zw.Close()
+	fw, _ := flate.NewWriter(&raw, flate.DefaultCompression)
+	fw.Write([]byte(page))
+	fw.Close()
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		want     string
+		wantOK   bool
+	}{
+		{"zlib deflate", "deflate", wrapped.Bytes(), page, true},
+		{"raw deflate", "Deflate", raw.Bytes(), page, true},
+		{"identity", "", []byte(page), page, true},
+		{"unknown", "br", []byte("\x1b\x00"), "\x1b\x00", false},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{"Content-Encoding": {tt.encoding}}}
+		r, ok, err := decodeBody(resp, bytes.NewReader(tt.body))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Errorf("%s: reading: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want || ok != tt.wantOK {
+			t.Errorf("%s: got %q, ok %v; want %q, ok %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
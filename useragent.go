@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultUserAgentStickiness is used when USER_AGENT_STICKINESS is not set
+	defaultUserAgentStickiness = time.Hour
+
+	// maxStickyHosts bounds the host to user agent table; expired entries are
+	// swept when it fills up
+	maxStickyHosts = 4096
+)
+
+// userAgentPin sends every request for a host, and its subdomains, with ua
+type userAgentPin struct {
+	host string
+	ua   string
+}
+
+// userAgents picks the User-Agent for upstream requests
+var userAgents = newUserAgentPicker()
+
+// userAgentPicker chooses user agents from the configured pool, keeping the
+// same one for a host for a while so that its requests look consistent
+type userAgentPicker struct {
+	mu     sync.Mutex
+	sticky map[string]stickyUserAgent
+}
+
+type stickyUserAgent struct {
+	ua      string
+	expires time.Time
+}
+
+func newUserAgentPicker() *userAgentPicker {
+	return &userAgentPicker{sticky: make(map[string]stickyUserAgent)}
+}
+
+// pick returns the User-Agent for a request to host. A per-request override
+// wins, then a pinned host, then the host's sticky choice from the pool. With
+// no pool configured the built-in user agent is used.
+func (p *userAgentPicker) pick(cfg *Config, host string, override string) string {
+	if override != "" {
+		return override
+	}
+	host = strings.ToLower(host)
+	for _, pin := range cfg.UserAgentPins {
+		if host == pin.host || strings.HasSuffix(host, "."+pin.host) {
+			return pin.ua
+		}
+	}
+	pool := userAgentPool(cfg)
+	if len(pool) == 0 {
+		return userAgent
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	// A reload may have dropped the sticky choice from the pool
+	if s, ok := p.sticky[host]; ok && now.Before(s.expires) && contains(pool, s.ua) {
+		return s.ua
+	}
+	if len(p.sticky) >= maxStickyHosts {
+		for h, s := range p.sticky {
+			if now.After(s.expires) {
+				delete(p.sticky, h)
+			}
+		}
+	}
+	ua := pool[rand.Intn(len(pool))]
+	p.sticky[host] = stickyUserAgent{ua: ua, expires: now.Add(cfg.UserAgentStickiness)}
+	return ua
+}
+
+// userAgentPool returns the configured user agents, inline ones first
+func userAgentPool(cfg *Config) []string {
+	if len(cfg.UserAgentFileEntries) == 0 {
+		return cfg.UserAgents
+	}
+	if len(cfg.UserAgents) == 0 {
+		return cfg.UserAgentFileEntries
+	}
+	pool := make([]string, 0, len(cfg.UserAgents)+len(cfg.UserAgentFileEntries))
+	pool = append(pool, cfg.UserAgents...)
+	return append(pool, cfg.UserAgentFileEntries...)
+}
+
+// parseUserAgents splits a |-separated list of user agents. Commas and
+// semicolons are common inside user agents, so they can't separate entries.
+func parseUserAgents(v string) []string {
+	var agents []string
+	for _, ua := range strings.Split(v, "|") {
+		if ua = strings.TrimSpace(ua); ua != "" {
+			agents = append(agents, ua)
+		}
+	}
+	return agents
+}
+
+// readUserAgentsFile reads one user agent per line, skipping blank lines and
+// # comments
+func readUserAgentsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var agents []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			agents = append(agents, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(agents) == 0 {
+		return nil, fmt.Errorf("%s lists no user agents", path)
+	}
+	return agents, nil
+}
+
+// parseUserAgentPins parses host=user agent pairs separated by |
+func parseUserAgentPins(v string) ([]userAgentPin, error) {
+	var pins []userAgentPin
+	for _, item := range strings.Split(v, "|") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		host, ua, ok := strings.Cut(item, "=")
+		host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+		ua = strings.TrimSpace(ua)
+		if !ok || host == "" || ua == "" {
+			return nil, fmt.Errorf("invalid pin %q (expected host=user agent)", item)
+		}
+		pins = append(pins, userAgentPin{host: host, ua: ua})
+	}
+	return pins, nil
+}
+
+func formatUserAgentPins(pins []userAgentPin) string {
+	items := make([]string, len(pins))
+	for i, pin := range pins {
+		items[i] = pin.host + "=" + pin.ua
+	}
+	return strings.Join(items, "|")
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ResponseV2 is the envelope of every /v2 response. Data is the result, or
+// a list of results with null for failed URLs in batches; Errors and
+// Warnings are always lists so clients never check for their absence.
+type ResponseV2 struct {
+	Data      interface{} `json:"data"`
+	Errors    []ErrorV2   `json:"errors"`
+	Warnings  []WarningV2 `json:"warnings"`
+	RequestID string      `json:"request_id"`
+}
+
+// ErrorV2 is why a request, or one URL of it, failed. Code is one of the
+// codes in errors.go. Index is the URL's position in a batch.
+type ErrorV2 struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	URL     string `json:"url,omitempty"`
+	Index   *int   `json:"index,omitempty"`
+}
+
+// WarningV2 is something that went wrong without failing an extraction.
+// Code is one of the warning codes in warnings.go.
+type WarningV2 struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	URL     string `json:"url,omitempty"`
+	Index   *int   `json:"index,omitempty"`
+}
+
+// MetadataV2 is the /v2 shape of an extraction result: MetadataResponse with
+// the fields whose shape changed replaced. Fields declared here shadow the
+// embedded ones of the same JSON name; the Legacy ones are always nil, which
+// drops theirs from the output.
+type MetadataV2 struct {
+	*MetadataResponse
+	SiteName string      `json:"site_name"`
+	Images   []ImageInfo `json:"images"`
+	Favicon  *FaviconV2  `json:"favicon"`
+
+	LegacySiteName       *struct{} `json:"sitename,omitempty"`
+	LegacyImageDetails   *struct{} `json:"image_details,omitempty"`
+	LegacyFaviconData    *struct{} `json:"favicon_data,omitempty"`
+	LegacyFaviconSizes   *struct{} `json:"favicon_sizes,omitempty"`
+	LegacyWarnings       *struct{} `json:"warnings,omitempty"` // Moved to the envelope
+	LegacyWarningDetails *struct{} `json:"warning_details,omitempty"`
+}
+
+// FaviconV2 is the page's favicon with what else is known about it
+type FaviconV2 struct {
+	URL   string   `json:"url"`
+	Data  string   `json:"data,omitempty"`  // With inline_favicon
+	Sizes []string `json:"sizes,omitempty"` // With probe_favicon
+}
+
+// newMetadataV2 converts an extraction result to the /v2 shape
+func newMetadataV2(metadata *MetadataResponse) *MetadataV2 {
+	v2 := &MetadataV2{
+		MetadataResponse: metadata,
+		Images:           make([]ImageInfo, 0, len(metadata.ImageDetails)),
+	}
+	if len(metadata.SiteName) > 0 {
+		v2.SiteName = metadata.SiteName[0]
+	}
+	v2.Images = append(v2.Images, metadata.ImageDetails...)
+	if metadata.Favicon != "" {
+		v2.Favicon = &FaviconV2{URL: metadata.Favicon, Data: metadata.FaviconData, Sizes: metadata.FaviconSizes}
+	}
+	return v2
+}
+
+// dataV2 is the data of a successful extraction in mode
+func dataV2(metadata *MetadataResponse, mode string) interface{} {
+	if mode == modeSimple {
+		return newSimpleMetadata(metadata)
+	}
+	return newMetadataV2(metadata)
+}
+
+// newResponseV2 builds the envelope of a response to r
+func newResponseV2(r *http.Request, data interface{}, errs []ErrorV2, warnings []WarningV2) ResponseV2 {
+	if errs == nil {
+		errs = []ErrorV2{}
+	}
+	if warnings == nil {
+		warnings = []WarningV2{}
+	}
+	return ResponseV2{Data: data, Errors: errs, Warnings: warnings, RequestID: requestID(r.Context())}
+}
+
+// isV2Request reports whether r was sent to a /v2 endpoint, whose errors
+// come in the envelope
+func isV2Request(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/v2/")
+}
+
+func extractV2Handler(store *configStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveExtractV2(store.Load(), w, r)
+	}
+}
+
+// serveExtractV2 answers /v2/extract from the same extractions as /extract.
+// GET takes the request as query parameters, POST as the same JSON body.
+func serveExtractV2(cfg *Config, w http.ResponseWriter, r *http.Request) {
+	var req MetadataRequest
+	switch r.Method {
+	case http.MethodGet:
+		var err error
+		if req, err = requestFromQuery(r.URL); err != nil {
+			writeError(w, r, codeInvalidRequest, err.Error())
+			return
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, codeInvalidRequest, "invalid JSON body")
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, r, codeMethodNotAllowed, "method not allowed, use GET or POST")
+		return
+	}
+	req.Debug = r.URL.Query().Get("debug") == "1"
+	req.EchoConfig = r.URL.Query().Get("echo_config") == "1"
+	if mode := r.URL.Query().Get("mode"); mode != "" {
+		req.Mode = mode
+	}
+
+	urls, opts, err := requestURLs(&req)
+	if err != nil {
+		writeError(w, r, codeInvalidRequest, err.Error())
+		return
+	}
+	extractions := extractURLs(w, r, cfg, urls, opts)
+
+	if len(urls) == 1 {
+		res := extractions[0]
+		if res.err != nil {
+			code := errorCode(res.err)
+			setRetryAfter(w, res.err)
+			writeJSON(w, errorStatuses[code], newResponseV2(r, nil, []ErrorV2{{Code: code, Message: res.err.Error(), URL: urls[0]}}, nil))
+			return
+		}
+		var warnings []WarningV2
+		for _, warning := range res.metadata.WarningDetails {
+			warnings = append(warnings, WarningV2{Code: warning.Code, Message: warning.Message, URL: urls[0]})
+		}
+		writeJSON(w, http.StatusOK, newResponseV2(r, dataV2(res.metadata, req.Mode), nil, warnings))
+		return
+	}
+
+	// Statuses follow the batch rules of /extract
+	data := make([]interface{}, len(urls))
+	results := make([]MetadataResult, len(urls))
+	var errs []ErrorV2
+	var warnings []WarningV2
+	for i, res := range extractions {
+		index := i
+		results[i] = newMetadataResult(r, urls[i], res.metadata, res.err)
+		if res.err != nil {
+			errs = append(errs, ErrorV2{Code: results[i].Code, Message: results[i].Error, URL: urls[i], Index: &index})
+			continue
+		}
+		data[i] = dataV2(res.metadata, req.Mode)
+		for _, warning := range res.metadata.WarningDetails {
+			warnings = append(warnings, WarningV2{Code: warning.Code, Message: warning.Message, URL: urls[i], Index: &index})
+		}
+	}
+	writeJSON(w, batchStatus(results, req.MinSuccessRatio), newResponseV2(r, data, errs, warnings))
+}
+
+// requestFromQuery reads a GET extract request from u's query: the URLs,
+// and options named as in the JSON body with JSON values, such as
+// verify_images=true&max_images=5. Values that aren't JSON are strings.
+// Parameters in ignore are left to the caller.
+func requestFromQuery(u *url.URL, ignore ...string) (MetadataRequest, error) {
+	query := u.Query()
+	fields := make(map[string]interface{})
+	for key, values := range query {
+		switch {
+		case key == "url", key == "urls", key == "debug", key == "echo_config", key == "pretty", contains(ignore, key):
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(values[0]), &value); err != nil {
+			value = values[0]
+		}
+		fields[key] = value
+	}
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return MetadataRequest{}, err
+	}
+
+	var req MetadataRequest
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return MetadataRequest{}, fmt.Errorf("invalid query parameters: %v", err)
+	}
+	for _, u := range queryURLs(query["url"], u.RawQuery) {
+		req.URLs = append(req.URLs, BatchURL{URL: u})
+	}
+	return req, nil
+}
+
+// queryURLs merges the URLs of a query, given as repeated url parameters and
+// comma-separated urls lists, dropping repeats. The lists are split before
+// they are unescaped, so a comma escaped as %2C stays inside its URL.
+func queryURLs(urls []string, rawQuery string) []string {
+	for _, pair := range strings.Split(rawQuery, "&") {
+		key, value, _ := strings.Cut(pair, "=")
+		if key, err := url.QueryUnescape(key); err != nil || key != "urls" {
+			continue
+		}
+		for _, item := range strings.Split(value, ",") {
+			if item, err := url.QueryUnescape(item); err == nil && strings.TrimSpace(item) != "" {
+				urls = append(urls, strings.TrimSpace(item))
+			}
+		}
+	}
+
+	var merged []string
+	for _, u := range urls {
+		if !contains(merged, u) {
+			merged = append(merged, u)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Video describes the main video of a page, from its JSON-LD VideoObject
+// with og:video tags filling the gaps. Duration is in seconds.
+type Video struct {
+	Duration     int64  `json:"duration,omitempty"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	UploadDate   string `json:"upload_date,omitempty"`
+	EmbedURL     string `json:"embed_url,omitempty"`
+}
+
+// isoDurationPattern matches ISO 8601 durations without years or months,
+// whose length in seconds depends on the calendar
+var isoDurationPattern = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)W)?(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// parseISODuration converts a duration like PT1M30S to seconds
+func parseISODuration(s string) (int64, bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	m := isoDurationPattern.FindStringSubmatch(s)
+	if m == nil || s == "P" || s == "PT" {
+		return 0, false
+	}
+	units := []float64{7 * 24 * 3600, 24 * 3600, 3600, 60, 1}
+	var seconds float64
+	for i, unit := range units {
+		if m[i+1] != "" {
+			n, _ := strconv.ParseFloat(m[i+1], 64)
+			seconds += n * unit
+		}
+	}
+	return int64(math.Round(seconds)), true
+}
+
+// extractVideo returns the page's video, or nil when it declares none
+func extractVideo(doc *html.Node, baseURL *url.URL, maxMetaContent int) *Video {
+	var video *Video
+	var og Video
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Script:
+				if video == nil && strings.EqualFold(strings.TrimSpace(attrValue(n, "type")), "application/ld+json") {
+					video = videoFromJSONLD(textContent(n))
+				}
+			case atom.Meta:
+				content := strings.TrimSpace(metaContent(n, maxMetaContent))
+				switch strings.ToLower(attrValue(n, "property")) {
+				case "og:video", "og:video:url", "og:video:secure_url":
+					if og.EmbedURL == "" {
+						og.EmbedURL = content
+					}
+				case "video:duration", "og:video:duration":
+					if og.Duration == 0 {
+						og.Duration, _ = strconv.ParseInt(content, 10, 64)
+					}
+				case "video:release_date":
+					if og.UploadDate == "" {
+						og.UploadDate = content
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	if video == nil {
+		if og.EmbedURL == "" {
+			return nil
+		}
+		video = &Video{}
+	}
+	if video.Duration == 0 {
+		video.Duration = og.Duration
+	}
+	if video.UploadDate == "" {
+		video.UploadDate = og.UploadDate
+	}
+	if video.EmbedURL == "" {
+		video.EmbedURL = og.EmbedURL
+	}
+	if video.ThumbnailURL != "" {
+		video.ThumbnailURL = resolveURL(video.ThumbnailURL, baseURL)
+	}
+	if video.EmbedURL != "" {
+		video.EmbedURL = resolveURL(video.EmbedURL, baseURL)
+	}
+	return video
+}
+
+// videoFromJSONLD finds the first VideoObject in a JSON-LD document, looking
+// through arrays and @graph
+func videoFromJSONLD(data string) *Video {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		return nil
+	}
+
+	var find func(v interface{}) map[string]interface{}
+	find = func(v interface{}) map[string]interface{} {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, item := range v {
+				if found := find(item); found != nil {
+					return found
+				}
+			}
+		case map[string]interface{}:
+			if hasJSONLDType(v["@type"], "VideoObject") {
+				return v
+			}
+			if found := find(v["@graph"]); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	obj := find(doc)
+	if obj == nil {
+		return nil
+	}
+
+	video := &Video{
+		ThumbnailURL: jsonLDURL(obj["thumbnailUrl"]),
+		EmbedURL:     jsonLDURL(obj["embedUrl"]),
+	}
+	if video.ThumbnailURL == "" {
+		video.ThumbnailURL = jsonLDURL(obj["thumbnail"])
+	}
+	if s, ok := obj["uploadDate"].(string); ok {
+		video.UploadDate = strings.TrimSpace(s)
+	}
+	if s, ok := obj["duration"].(string); ok {
+		video.Duration, _ = parseISODuration(s)
+	}
+	return video
+}
+
+// hasJSONLDType reports whether an @type value, a string or a list of them,
+// includes want
+func hasJSONLDType(v interface{}, want string) bool {
+	switch v := v.(type) {
+	case string:
+		return v == want || v == "https://schema.org/"+want || v == "http://schema.org/"+want
+	case []interface{}:
+		for _, t := range v {
+			if hasJSONLDType(t, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonLDURL returns the first URL of a JSON-LD value, which may be a string,
+// an object with a url (such as an ImageObject) or a list of either
+func jsonLDURL(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case map[string]interface{}:
+		return jsonLDURL(v["url"])
+	case []interface{}:
+		for _, item := range v {
+			if u := jsonLDURL(item); u != "" {
+				return u
+			}
+		}
+	}
+	return ""
+}
+
+// attrValue returns the value of n's attribute key, or "" when it has none
+func attrValue(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
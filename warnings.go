@@ -0,0 +1,51 @@
+package main
+
+// Codes of extraction warnings. A code names the kind of problem, so that
+// clients can act on it without parsing the message.
+const (
+	warnPartial              = "partial"
+	warnTruncated            = "truncated"
+	warnUnknownEncoding      = "unknown_encoding"
+	warnCharsetFallback      = "charset_fallback"
+	warnDecodingErrors       = "decoding_errors"
+	warnInvalidAssetURL      = "invalid_asset_url"
+	warnConsentWall          = "consent_wall"
+	warnSoft404              = "soft_404"
+	warnOEmbedFailed         = "oembed_failed"
+	warnColorsSkipped        = "colors_skipped"
+	warnColorProbeFailed     = "color_probe_failed"
+	warnFaviconNotInlined    = "favicon_not_inlined"
+	warnFaviconNotProbed     = "favicon_not_probed"
+	warnASNUnavailable       = "asn_unavailable"
+	warnEnrichmentTimeout    = "enrichment_timeout"
+	warnCanonicalNotFollowed = "canonical_not_followed"
+	warnHreflangNotFollowed  = "hreflang_not_followed"
+)
+
+// Warning is a non-fatal problem met while extracting a page
+type Warning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// addWarning records a problem both in the warnings list, which has always
+// held the messages alone, and with its code in warning_details
+func addWarning(metadata *MetadataResponse, code, message string) {
+	metadata.Warnings = append(metadata.Warnings, message)
+	metadata.WarningDetails = append(metadata.WarningDetails, Warning{Code: code, Message: message})
+}
+
+// dropWarnings removes the warnings with code from metadata. The lists are
+// rebuilt rather than filtered in place, so a shallow copy of metadata can
+// drop them without touching the original.
+func dropWarnings(metadata *MetadataResponse, code string) {
+	var messages []string
+	var details []Warning
+	for _, warning := range metadata.WarningDetails {
+		if warning.Code != code {
+			messages = append(messages, warning.Message)
+			details = append(details, warning)
+		}
+	}
+	metadata.Warnings, metadata.WarningDetails = messages, details
+}
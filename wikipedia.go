@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxWikipediaAPIBytes caps the download of a Wikipedia summary
+const maxWikipediaAPIBytes = 1024 * 1024
+
+// wikipediaNamespaces are the English names of the namespaces that aren't
+// articles. Other editions name them differently, which the summary's own
+// namespace catches.
+var wikipediaNamespaces = map[string]bool{
+	"special": true, "talk": true, "user": true, "wikipedia": true,
+	"file": true, "mediawiki": true, "template": true, "help": true,
+	"category": true, "portal": true, "draft": true, "module": true,
+	"timedtext": true, "media": true,
+}
+
+// wikipediaSummary is the part of the REST summary endpoint's response
+// that is used
+type wikipediaSummary struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Titles struct {
+		Normalized string `json:"normalized"`
+	} `json:"titles"`
+	Namespace struct {
+		ID int `json:"id"`
+	} `json:"namespace"`
+	Extract       string `json:"extract"`
+	OriginalImage *struct {
+		Source string `json:"source"`
+		Width  int    `json:"width"`
+		Height int    `json:"height"`
+	} `json:"originalimage"`
+}
+
+var wikipediaProvider = provider{
+	name: "wikipedia",
+	match: func(pageURL *url.URL) bool {
+		_, _, ok := wikipediaArticle(pageURL)
+		return ok
+	},
+	fetch: func(ctx context.Context, cfg *Config, pageURL *url.URL) (interface{}, error) {
+		edition, title, _ := wikipediaArticle(pageURL)
+		return fetchWikipediaSummary(ctx, cfg, edition, title)
+	},
+	apply: applyWikipediaSummary,
+}
+
+// wikipediaArticle returns the host of the language edition an article URL
+// such as https://de.m.wikipedia.org/wiki/Berlin belongs to, without the
+// mobile subdomain, and the article's title
+func wikipediaArticle(u *url.URL) (edition, title string, ok bool) {
+	host := strings.ToLower(u.Hostname())
+	lang, ok := strings.CutSuffix(host, ".wikipedia.org")
+	if !ok {
+		return "", "", false
+	}
+	lang = strings.TrimSuffix(lang, ".m")
+	if lang == "" || lang == "www" || strings.Contains(lang, ".") {
+		return "", "", false
+	}
+
+	title, ok = strings.CutPrefix(u.Path, "/wiki/")
+	if !ok || title == "" {
+		return "", "", false
+	}
+	if namespace, _, found := strings.Cut(title, ":"); found {
+		namespace = strings.ToLower(strings.ReplaceAll(namespace, "_", " "))
+		if wikipediaNamespaces[namespace] || strings.HasSuffix(namespace, " talk") {
+			return "", "", false
+		}
+	}
+	return lang + ".wikipedia.org", title, true
+}
+
+// fetchWikipediaSummary calls the summary endpoint of an article's edition.
+// Pages that aren't articles, or have no extract, are reported as errors so
+// the page's own metadata is kept.
+func fetchWikipediaSummary(ctx context.Context, cfg *Config, edition, title string) (*wikipediaSummary, error) {
+	apiURL, err := url.Parse(fmt.Sprintf("https://%s/api/rest_v1/page/summary/%s", edition, url.PathEscape(title)))
+	if err != nil {
+		return nil, err
+	}
+	if err := validateURLForSSRF(cfg, apiURL); err != nil {
+		return nil, err
+	}
+
+	resp, release, err := fetchURL(ctx, cfg, apiURL, http.Header{"Accept": {"application/json"}})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP error: %d", resp.StatusCode)
+	}
+
+	var summary wikipediaSummary
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxWikipediaAPIBytes)).Decode(&summary); err != nil {
+		return nil, fmt.Errorf("invalid API response: %v", err)
+	}
+	if summary.Namespace.ID != 0 {
+		return nil, fmt.Errorf("not an article: namespace %d", summary.Namespace.ID)
+	}
+	if summary.Type != "standard" || strings.TrimSpace(summary.Extract) == "" {
+		return nil, fmt.Errorf("no summary for %s page", summary.Type)
+	}
+	// the extract replaces the page's description, so it gets the same cap
+	if len(summary.Extract) > cfg.MaxMetaContentBytes {
+		summary.Extract = truncateUTF8(summary.Extract, cfg.MaxMetaContentBytes)
+	}
+	return &summary, nil
+}
+
+// applyWikipediaSummary replaces the boilerplate a Wikipedia article
+// declares with its title, lead extract and lead image. Provider data
+// arrives after the page's text was sanitized, so it is sanitized here.
+func applyWikipediaSummary(metadata *MetadataResponse, data interface{}) {
+	summary := data.(*wikipediaSummary)
+
+	title := sanitizeText(summary.Titles.Normalized)
+	if title == "" {
+		title = sanitizeText(summary.Title)
+	}
+	if title != "" {
+		metadata.Title = title
+		setSource(metadata, "title", "wikipedia api")
+	}
+	metadata.Description = sanitizeText(summary.Extract)
+	setSource(metadata, "description", "wikipedia api")
+
+	if img := summary.OriginalImage; img != nil && img.Source != "" {
+		prependImage(metadata, ImageInfo{URL: img.Source, Width: img.Width, Height: img.Height, Source: "wikipedia api"})
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestApplyWikipediaSummarySanitizes(t *testing.T) {
+	summary := &wikipediaSummary{Title: "Berlin", Extract: "<b>Berlin</b> is the\u202e capital\n\nof Germany.<script>alert(1)</script>"}
+	summary.Titles.Normalized = "Berlin<br>\u0007"
+
+	metadata := &MetadataResponse{Title: "Berlin - Wikipedia", Description: "From Wikipedia"}
+	applyWikipediaSummary(metadata, summary)
+
+	if metadata.Title != "Berlin" {
+		t.Errorf("title = %q, want %q", metadata.Title, "Berlin")
+	}
+	if want := "Berlin is the capital of Germany."; metadata.Description != want {
+		t.Errorf("description = %q, want %q", metadata.Description, want)
+	}
+}